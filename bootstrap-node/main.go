@@ -3,7 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,18 +13,35 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/chat"
 	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/content"
+	"github.com/Jerry-se/libp2p-node/pkg/delegated"
+	"github.com/Jerry-se/libp2p-node/pkg/keystore"
+	"github.com/Jerry-se/libp2p-node/pkg/names"
+	nodepkg "github.com/Jerry-se/libp2p-node/pkg/node"
+	"github.com/Jerry-se/libp2p-node/pkg/perf"
+	"github.com/Jerry-se/libp2p-node/pkg/portmap"
+	"github.com/Jerry-se/libp2p-node/pkg/reachability"
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+	"github.com/Jerry-se/libp2p-node/pkg/relays"
 
 	"github.com/libp2p/go-libp2p"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/routing"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
 	"github.com/multiformats/go-multiaddr"
 
 	// ds "github.com/ipfs/go-datastore"
@@ -34,12 +51,7 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 )
 
-var DefaultBootstrapPeers = convertPeers([]string{
-	"/ip4/122.99.183.54/tcp/7001/p2p/12D3KooWSpgWzEXE5GNjY6hgdAhuuBLe4d3ocqWDnVLdCa8U3cig",
-	"/ip4/122.99.183.54/tcp/8511/p2p/12D3KooWJaNdwbwsvESYZmeEhTwkG1KirKxXpE6CdPx2Z9VqM3Rt",
-	"/ip4/122.99.183.54/tcp/8515/p2p/12D3KooWNp5pyEAtXs52RqssBkrCGojyNLWiZysjr8EpXKK4rpyp",
-	"/ip4/82.157.50.32/tcp/7001/p2p/12D3KooWFrTcDtocZWEvEAk2X4poyn13LzT3G7JMBRoPD73YPAoB",
-})
+var DefaultBootstrapPeers = convertPeers(append(append([]string{}, nodepkg.DefaultBootstrapPeers...), nodepkg.ExtraBootstrapPeers...))
 
 func convertPeers(peers []string) []multiaddr.Multiaddr {
 	maddrs := make([]multiaddr.Multiaddr, len(peers))
@@ -66,15 +78,48 @@ func doEcho(s network.Stream) error {
 	return err
 }
 
+// doEchoFrame reads a /chat/2.0.0 frame from a stream, acknowledges it and
+// writes it back
+func doEchoFrame(s network.Stream) error {
+	buf := bufio.NewReader(s)
+	msg, err := chat.ReadFrame(buf)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("read %s frame: %q\n", msg.Type, msg.Body)
+	if msg.Type == chat.TypeText || msg.Type == chat.TypeFileOffer {
+		if err := chat.WriteFrame(s, chat.Message{Type: chat.TypeAck, ID: msg.ID}); err != nil {
+			return err
+		}
+	}
+	return chat.WriteFrame(s, msg)
+}
+
 func main() {
 	logLevelString := flag.String("logLevel", "info",
 		"log severity level in [debug, info, warn, error ...]")
 	listenF := flag.Int("l", 6000, "listening port waiting for incoming connections")
-	pskString := flag.String("psk", "", "Pre-Shared Key")
-	peerKeyPath := flag.String("peerkey", "", "the file path of peer key")
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
+	peerKeyPath := flag.String("peerkey", "", "the file path of peer key, defaults to Identity.PrivKey of -config when unset")
 	ping := flag.Bool("ping", false, "whether to enable ipfs ping")
 	protocolPrefix := flag.String("protocol", "", "the prefix attached to all DHT protocols")
 	topicNameFlag := flag.String("topicName", "applesauce", "name of topic to join")
+	apiAddr := flag.String("api", "", "listen address of the HTTP API used by p2pctl, e.g. 127.0.0.1:5001, disabled if empty")
+	routingAddr := flag.String("routing-v1", "", "address to serve the Delegated Routing V1 HTTP API on, e.g. :8080, disabled if empty")
+	datastorePath := flag.String("datastore", "", "directory of the LevelDB datastore holding blocks, in memory if empty")
+	configPath := flag.String("config", "", "the file path of the Kubo style json configuration")
+	keystorePath := flag.String("keystore", "", "keystore directory, its self key is the identity when -peerkey is unset")
+	perfServer := flag.Bool("perf", false, "answer the /perf/1.0.0 throughput benchmark of other peers")
+	reachabilityFlag := flag.String("reachability", string(reachability.ModePublic), "reachability mode: auto asks AutoNAT, public or private assume it")
+	autonatService := flag.Bool("autonat", true, "run the AutoNAT service telling other peers whether they are reachable")
+	autonatGlobal := flag.Int("autonat-limit", reachability.DefaultLimits.Global, "AutoNAT and dial back requests answered per -autonat-interval")
+	autonatPeer := flag.Int("autonat-peer-limit", reachability.DefaultLimits.PerPeer, "AutoNAT and dial back requests of a peer answered per -autonat-interval")
+	autonatInterval := flag.Duration("autonat-interval", reachability.DefaultLimits.Interval, "interval of the AutoNAT rate limits")
+	dialback := flag.Bool("dialback", true, "answer the per address reachability checks of other peers")
+	portMap := flag.Bool("portmap", false, "map the listen port on the router with UPnP or NAT-PMP, for nodes behind a home router")
+	quicListen := flag.Bool("quic", false, "also listen on QUIC on the UDP port of -l, not available in private networks")
 	flag.Parse()
 
 	logLevel, err := golog.LevelFromString(*logLevelString)
@@ -83,18 +128,82 @@ func main() {
 	}
 	golog.SetAllLoggers(logLevel)
 
-	if *peerKeyPath == "" {
-		log.Fatal("Please provide a filepath to save peer key")
+	cfg := &config.Config{}
+	if *configPath != "" {
+		cfg, err = config.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Load configuration: %v", err)
+		}
 	}
-	peerKey, _, err := config.LoadPeerKey(*peerKeyPath)
+	reprovideStrategy, err := content.ParseStrategy(cfg.Reprovider.Strategy)
 	if err != nil {
-		// log.Fatalf("Load peer key: %v", err)
-		peerKey, _, err = config.GeneratePeerKey(*peerKeyPath)
+		log.Fatalf("Reprovider.Strategy: %v", err)
+	}
+	reprovideInterval, err := cfg.Reprovider.IntervalDuration(content.DefaultReprovideInterval)
+	if err != nil {
+		log.Fatalf("Reprovider.Interval: %v", err)
+	}
+	republishPeriod, err := cfg.Ipns.RepublishPeriodDuration(names.DefaultRepublishInterval)
+	if err != nil {
+		log.Fatalf("Ipns.RepublishPeriod: %v", err)
+	}
+	recordLifetime, err := cfg.Ipns.RecordLifetimeDuration(names.DefaultRecordLifetime)
+	if err != nil {
+		log.Fatalf("Ipns.RecordLifetime: %v", err)
+	}
+	dhtMode := dht.ModeAutoServer
+	if cfg.Routing.Type != "" {
+		if dhtMode, err = nodepkg.ParseDHTMode(cfg.Routing.Type); err != nil {
+			log.Fatalf("Routing.Type: %v", err)
+		}
+	}
+
+	var peerKey crypto.PrivKey
+	var ks *keystore.Keystore
+	if *keystorePath != "" {
+		if ks, err = keystore.Open(*keystorePath); err != nil {
+			log.Fatalf("Open keystore: %v", err)
+		}
+	}
+	if *peerKeyPath == "" && ks != nil {
+		peerKey, err = ks.Get(keystore.Self)
+		if errors.Is(err, keystore.ErrNoSuchKey) {
+			peerKey, err = ks.Generate(keystore.Self, crypto.Ed25519, 0)
+		}
 		if err != nil {
-			log.Fatalf("Generate peer key: %v", err)
+			log.Fatalf("Load identity from keystore: %v", err)
+		}
+		log.Println("Load peer key from keystore success")
+	} else if *peerKeyPath == "" && cfg.Identity.PrivKey != "" {
+		if peerKey, err = cfg.Identity.PrivateKey(); err != nil {
+			log.Fatalf("Identity: %v", err)
 		}
+		log.Println("Load peer key from config success")
 	} else {
-		log.Println("Load peer key success")
+		if *peerKeyPath == "" {
+			log.Fatal("Please provide a filepath to save peer key")
+		}
+		peerKey, _, err = config.LoadPeerKey(*peerKeyPath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Fatalf("Load peer key: %v", err)
+			}
+			peerKey, _, err = config.GeneratePeerKey(*peerKeyPath)
+			if err != nil {
+				log.Fatalf("Generate peer key: %v", err)
+			}
+		} else {
+			log.Println("Load peer key success")
+		}
+	}
+
+	reachabilityMode, err := reachability.ParseMode(*reachabilityFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	autonatLimits := reachability.Limits{Global: *autonatGlobal, PerPeer: *autonatPeer, Interval: *autonatInterval}
+	if err := autonatLimits.Validate(); err != nil {
+		log.Fatalf("AutoNAT limits: %v", err)
 	}
 
 	ctx := context.Background()
@@ -109,6 +218,14 @@ func main() {
 		log.Fatalf("Create connection manager: %v", err)
 	}
 
+	// Opened before the host: while rotating the pre-shared key, the DHT
+	// shares it with the node on the next key.
+	dstore, err := nodepkg.OpenDatastore(*datastorePath)
+	if err != nil {
+		log.Fatalf("Open datastore: %v", err)
+	}
+	defer dstore.Close()
+
 	opts := []libp2p.Option{
 		// Multiple listen addresses
 		libp2p.ListenAddrStrings(
@@ -130,57 +247,103 @@ func main() {
 		libp2p.ConnectionManager(connmgr),
 		libp2p.DefaultMuxers,
 		libp2p.DefaultSecurity,
-		// Attempt to open ports using uPNP for NATed hosts.
-		// libp2p.NATPortMap(),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
 			dhtOpts := []dht.Option{
-				dht.Mode(dht.ModeAutoServer),
+				dht.Mode(dhtMode),
+				dht.NamespacedValidator(records.Namespace, records.Validator{}),
+			}
+			if cfg.Pnet.NextSwarmKey != "" {
+				// Shared with the node on the next key, so that the records
+				// stored here are served on both networks.
+				dhtOpts = append(dhtOpts, dht.Datastore(dstore))
 			}
 			if *protocolPrefix != "" {
 				dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(*protocolPrefix)))
 			}
-			kadDHT, err = dht.New(ctx, h, dhtOpts...)
+			kadDHT, err = nodepkg.NewDHT(ctx, h, dhtOpts...)
 			return kadDHT, err
 		}),
 		// libp2p.ProtocolVersion("ipfs/0.1.0"),
-		// If you want to help other peers to figure out if they are behind
-		// NATs, you can launch the server-side of AutoNAT too (AutoRelay
-		// already runs the client)
-		//
-		// This service is highly rate-limited and should not cause any
-		// performance issues.
 		libp2p.DisableRelay(),
-		libp2p.EnableNATService(),
-		libp2p.ForceReachabilityPublic(),
+		reachabilityMode.Option(),
 		libp2p.EnableRelayService(relay.WithResources(relay.DefaultResources())),
 		// libp2p.EnableHolePunching(),
 	}
 
-	if *pskString != "" {
-		psk, err := hex.DecodeString(*pskString)
+	if *swarmKeyPath == "" {
+		*swarmKeyPath = cfg.Pnet.SwarmKey
+	}
+	psk, err := config.LoadPSK(*pskString, *swarmKeyPath)
+	if err != nil {
+		log.Fatalf("Pre-Shared Key: %v", err)
+	}
+	if psk != nil {
+		log.Println("Private network enabled")
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	announce, err := nodepkg.AnnounceOption(cfg.Addresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, announce)
+	// Help other peers to figure out if they are behind NATs with the
+	// server-side of AutoNAT. This service is rate-limited and should not
+	// cause any performance issues.
+	if *autonatService {
+		opts = append(opts, reachability.ServiceOptions(autonatLimits)...)
+	}
+	// Attempt to open ports using UPnP or NAT-PMP for NATed hosts.
+	portMappings := portmap.New(portmap.DefaultInterval)
+	if *portMap {
+		opts = append(opts, portMappings.Option())
+	}
+	if *perfServer {
+		// The default resource manager, plus the limits of the perf streams.
+		limits := rcmgr.DefaultLimits
+		libp2p.SetDefaultServiceLimits(&limits)
+		perf.SetServiceLimits(&limits)
+		rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
 		if err != nil {
-			log.Fatalf("Decoding PSK: %v", err)
+			log.Fatalf("Resource manager: %v", err)
 		}
-		log.Println("Pre-Shared Key ", psk)
-		opts = append(opts, libp2p.PrivateNetwork(psk))
+		opts = append(opts, libp2p.ResourceManager(rm))
+	}
+	if *quicListen {
+		if psk != nil {
+			log.Fatal("QUIC doesn't support private networks, remove -quic or the pre-shared key")
+		}
+		opts = append(opts,
+			libp2p.Transport(libp2pquic.NewTransport),
+			libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", *listenF)))
 	}
 
 	node, err := libp2p.New(opts...)
 	if err != nil {
 		log.Fatalf("Create libp2p host: %v", err)
 	}
+	if err := portMappings.Start(node); err != nil {
+		log.Fatalf("Port mapping events: %v", err)
+	}
 
 	// _, err = relay.New(node, relay.WithResources(relay.DefaultResources()))
 	// if err != nil {
 	// 	log.Fatalf("Failed to instantiate the relay service: %v", err)
 	// }
 
-	log.Println("Listen addresses:", node.Addrs())
+	log.Println("Listen addresses:", node.Network().ListenAddresses())
+	log.Println("Announced addresses:", node.Addrs())
 	log.Println("Node id:", node.ID())
 
+	// Start Bitswap before connecting to anyone, it only learns about new
+	// connections.
+	store := content.New(ctx, node, dstore, kadDHT,
+		content.WithStrategy(reprovideStrategy),
+		content.WithReprovideInterval(reprovideInterval))
+	defer store.Close()
+
 	// Set a stream handler on host A. /chat/1.0.0 is
 	// a user-defined protocol name.
-	node.SetStreamHandler("/chat/1.0.0", func(s network.Stream) {
+	node.SetStreamHandler(chat.ProtocolV1, func(s network.Stream) {
 		log.Println("Got a new stream!")
 		if err := doEcho(s); err != nil {
 			log.Println(err)
@@ -189,6 +352,35 @@ func main() {
 			s.Close()
 		}
 	})
+	node.SetStreamHandler(chat.ProtocolV2, func(s network.Stream) {
+		log.Println("Got a new framed stream!")
+		if err := doEchoFrame(s); err != nil {
+			log.Println(err)
+			s.Reset()
+		} else {
+			s.Close()
+		}
+	})
+
+	if *dialback {
+		dialer, err := reachability.NewDialer(psk)
+		if err != nil {
+			log.Fatalf("Create dial back host: %v", err)
+		}
+		defer dialer.Close()
+		dialbackServer, err := reachability.NewServer(node, dialer, autonatLimits)
+		if err != nil {
+			log.Fatalf("Dial back server: %v", err)
+		}
+		defer dialbackServer.Close()
+		log.Println("Dial back server enabled")
+	}
+
+	if *perfServer {
+		perfService := perf.NewService(node)
+		defer perfService.Close()
+		log.Println("Perf server enabled")
+	}
 
 	// print the node's PeerInfo in multiaddr format
 	peerInfo := peer.AddrInfo{
@@ -204,7 +396,11 @@ func main() {
 	// Let's connect to the bootstrap nodes first. They will tell us about the
 	// other nodes in the network.
 	var wg sync.WaitGroup
-	for _, peerAddr := range DefaultBootstrapPeers {
+	bootstrapPeers := DefaultBootstrapPeers
+	if len(cfg.Bootstrap) > 0 {
+		bootstrapPeers = convertPeers(cfg.Bootstrap)
+	}
+	for _, peerAddr := range bootstrapPeers {
 		peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
 		wg.Add(1)
 		go func() {
@@ -224,6 +420,77 @@ func main() {
 		log.Fatalf("Bootstrap the host: %v", err)
 	}
 
+	// Let the rendezvous peers find us as a relay when their bootstrap
+	// relays are saturated or down.
+	dutil.Advertise(ctx, drouting.NewRoutingDiscovery(kadDHT), relays.Namespace)
+
+	nameService, err := names.New(kadDHT, dstore, peerKey,
+		names.WithRepublishInterval(republishPeriod),
+		names.WithRecordLifetime(recordLifetime))
+	if err != nil {
+		log.Fatalf("Start name service: %v", err)
+	}
+	defer nameService.Close()
+	log.Println("IPNS name:", nameService.Name())
+
+	if cfg.Pnet.NextSwarmKey != "" {
+		// Announce lists the addresses on the current key, the next one
+		// listens elsewhere.
+		nextAnnounce, err := nodepkg.AnnounceOption(config.Addresses{NoAnnounce: cfg.Addresses.NoAnnounce})
+		if err != nil {
+			log.Fatal(err)
+		}
+		next, err := nodepkg.JoinNextNetwork(ctx, peerKey, psk, cfg.Pnet, nodepkg.Config{
+			ProtocolPrefix: *protocolPrefix,
+			DHTMode:        dhtMode,
+			DHTOptions:     []dht.Option{dht.Datastore(dstore)},
+			Options:        []libp2p.Option{nextAnnounce},
+		})
+		if err != nil {
+			log.Fatalf("Join the network of Pnet.NextSwarmKey: %v", err)
+		}
+		defer next.Close()
+		if err := next.Bootstrap(ctx); err != nil {
+			log.Println("Bootstrap on the next network:", err)
+		}
+		log.Println("Next network listen addresses:", next.Host.Addrs())
+	}
+
+	if ks != nil {
+		// Tell the peers still knowing our retired identities about this one.
+		go func() {
+			if err := ks.PublishTransitions(ctx, kadDHT); err != nil {
+				log.Println("Publish identity transitions:", err)
+			}
+		}()
+	}
+
+	if *apiAddr != "" {
+		apiServer := api.NewServer()
+		store.RegisterAPI(apiServer)
+		nameService.RegisterAPI(apiServer)
+		nodepkg.RegisterDHTAPI(apiServer, kadDHT)
+		records.RegisterAPI(apiServer, kadDHT, node.Peerstore().PrivKey(node.ID()))
+		portMappings.RegisterAPI(apiServer)
+		addr, err := apiServer.Serve(*apiAddr)
+		if err != nil {
+			log.Fatalf("Start API server: %v", err)
+		}
+		defer apiServer.Close()
+		log.Println("API server listening on", addr)
+	}
+
+	if *routingAddr != "" {
+		routingServer := api.NewServer()
+		routingServer.Mount(delegated.Prefix, delegated.Handler(node, kadDHT))
+		addr, err := routingServer.Serve(*routingAddr)
+		if err != nil {
+			log.Fatalf("Start delegated routing server: %v", err)
+		}
+		defer routingServer.Close()
+		log.Println("Delegated routing API listening on", addr)
+	}
+
 	ps, err := pubsub.NewGossipSub(ctx, node)
 	if err != nil {
 		log.Fatalf("New GossipSub: %v", err)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/node"
+	"github.com/Jerry-se/libp2p-node/pkg/transfer"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	"github.com/ipfs/go-log/v2"
+)
+
+var logger = log.Logger("file-transfer")
+
+func main() {
+	log.SetAllLoggers(log.LevelWarn)
+	log.SetLogLevel("file-transfer", "info")
+	log.SetLogLevel("transfer", "info")
+	listenF := flag.Int("l", 0, "listening port waiting for incoming connections")
+	peerKeyPath := flag.String("peerkey", "", "the file path of peer key")
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
+	protocolPrefix := flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	dir := flag.String("dir", ".", "directory where received files are stored")
+	acceptFrom := flag.String("accept-from", "", "comma separated peer IDs allowed to send files, * for everyone; required to receive")
+	maxSize := flag.Int64("max-size", transfer.DefaultMaxSize, "largest file accepted, in bytes")
+	sendPath := flag.String("send", "", "file to send, leave empty to receive files")
+	to := flag.String("to", "", "peer ID to send the file to")
+	delegatedRouting := flag.String("delegated-routing", "", "URL of a /routing/v1 endpoint to use instead of running a DHT")
+	retries := flag.Int("retries", 5, "number of times to resume an interrupted transfer")
+	flag.Parse()
+
+	if *peerKeyPath == "" {
+		logger.Fatal("Please provide a filepath to save peer key")
+	}
+	sending := *sendPath != ""
+	var target peer.ID
+	if sending {
+		var err error
+		target, err = peer.Decode(*to)
+		if err != nil {
+			logger.Fatalf("Decoding -to peer ID: %v", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	mode := dht.ModeAuto
+	if sending {
+		mode = dht.ModeClient
+	}
+	n, err := node.New(ctx, node.Config{
+		ListenAddrs:      []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *listenF)},
+		PeerKeyPath:      *peerKeyPath,
+		PSK:              *pskString,
+		SwarmKey:         *swarmKeyPath,
+		ProtocolPrefix:   *protocolPrefix,
+		DHTMode:          mode,
+		DelegatedRouting: *delegatedRouting,
+	})
+	if err != nil {
+		logger.Fatalf("Create libp2p host: %v", err)
+	}
+	defer n.Close()
+	logger.Info("Host created. We are:", n.Host.ID())
+
+	if err := n.Bootstrap(ctx); err != nil {
+		logger.Warnf("Bootstrap: %v", err)
+	}
+
+	if sending {
+		start := time.Now()
+		err := transfer.SendFile(ctx, n.Host, target, *sendPath,
+			transfer.WithRetries(*retries, 5*time.Second),
+			transfer.WithProgress(func(sent, total int64) {
+				fmt.Printf("\r%d/%d bytes (%.1f%%)", sent, total, float64(sent)*100/float64(total))
+			}))
+		fmt.Println()
+		if err != nil {
+			logger.Fatalf("Send %s to %s: %v", *sendPath, target, err)
+		}
+		logger.Infof("Sent %s to %s in %s", *sendPath, target, time.Since(start))
+		return
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		logger.Fatalf("Create download directory: %v", err)
+	}
+	allowed := make(map[peer.ID]bool)
+	acceptAll := false
+	for _, s := range strings.Split(*acceptFrom, ",") {
+		switch s = strings.TrimSpace(s); s {
+		case "":
+			continue
+		case "*":
+			acceptAll = true
+			continue
+		}
+		p, err := peer.Decode(s)
+		if err != nil {
+			logger.Fatalf("Decoding -accept-from peer ID %q: %v", s, err)
+		}
+		allowed[p] = true
+	}
+	if !acceptAll && len(allowed) == 0 {
+		logger.Fatal("Please set -accept-from to the peer IDs allowed to send files, or * to accept everyone")
+	}
+	if acceptAll {
+		logger.Warnf("Accepting files from EVERY peer of the network into %s, restrict -accept-from to trusted peer IDs", *dir)
+	}
+	transfer.NewReceiver(n.Host, *dir, func(p peer.ID, offer transfer.Offer) bool {
+		return acceptAll || allowed[p]
+	}, transfer.WithMaxSize(*maxSize))
+	logger.Infof("Waiting for files in %s", *dir)
+	<-ctx.Done()
+}
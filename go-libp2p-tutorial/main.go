@@ -2,34 +2,53 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+	"github.com/Jerry-se/libp2p-node/pkg/pinger"
+
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
 	peerstore "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
 	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
 	multiaddr "github.com/multiformats/go-multiaddr"
 )
 
 func main() {
-	pskString := flag.String("psk", "", "Pre-Shared Key")
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
 	target := flag.String("d", "", "target peer to dial")
+	relayAddr := flag.String("relay", "", "relay node address: reserve a slot on it when listening, dial the target through it when pinging")
+	count := flag.Int("c", pinger.DefaultCount, "number of pings to send, 0 to ping until interrupted")
+	interval := flag.Duration("i", pinger.DefaultInterval, "interval between pings")
+	timeout := flag.Duration("timeout", pinger.DefaultTimeout, "time to wait for each reply")
+	jsonOutput := flag.Bool("json", false, "print the statistics as JSON on stdout")
 	flag.Parse()
 
+	// keep stdout for the JSON statistics
+	var out io.Writer = os.Stdout
+	if *jsonOutput {
+		out = os.Stderr
+	}
+
 	opts := []libp2p.Option{
 		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
 		libp2p.Ping(false),
 	}
-	if *pskString != "" {
-		psk, err := hex.DecodeString(*pskString)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Pre-Shared Key ", psk)
+	psk, err := config.LoadPSK(*pskString, *swarmKeyPath)
+	if err != nil {
+		panic(err)
+	}
+	if psk != nil {
+		fmt.Fprintln(out, "Private network enabled")
 		opts = append(opts, libp2p.PrivateNetwork(psk))
 	}
 	// start a libp2p node that listens on a random local TCP port,
@@ -40,8 +59,8 @@ func main() {
 	}
 
 	// print the node's listening addresses
-	fmt.Println("Listen addresses:", node.Addrs())
-	fmt.Println("Node id:", node.ID())
+	fmt.Fprintln(out, "Listen addresses:", node.Addrs())
+	fmt.Fprintln(out, "Node id:", node.ID())
 
 	// configure our own ping protocol
 	pingService := &ping.PingService{Host: node}
@@ -56,38 +75,136 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	fmt.Println("libp2p node address:", addrs[0])
+	fmt.Fprintln(out, "libp2p node address:", addrs[0])
 
-	// if a remote peer has been passed on the command line, connect to it
-	// and send it 5 ping messages, otherwise wait for a signal to stop
-	if *target != "" {
-		addr, err := multiaddr.NewMultiaddr(*target)
-		if err != nil {
-			panic(err)
-		}
-		peer, err := peerstore.AddrInfoFromP2pAddr(addr)
+	var relay *peerstore.AddrInfo
+	if *relayAddr != "" {
+		relay, err = peerstore.AddrInfoFromString(*relayAddr)
 		if err != nil {
 			panic(err)
 		}
-		if err := node.Connect(context.Background(), *peer); err != nil {
-			panic(err)
-		}
-		fmt.Println("sending 5 ping messages to", addr)
-		ch := pingService.Ping(context.Background(), peer.ID)
-		for i := 0; i < 5; i++ {
-			res := <-ch
-			fmt.Println("pinged", addr, "in", res.RTT)
-		}
+	}
+
+	// if a remote peer has been passed on the command line, ping it,
+	// otherwise wait for a signal to stop
+	code := 0
+	if *target != "" {
+		code = runPing(node, *target, relay, pinger.Options{Count: *count, Interval: *interval, Timeout: *timeout, KeepResults: *jsonOutput}, *jsonOutput, out)
 	} else {
+		if relay != nil {
+			if err := reserve(node, *relay, out); err != nil {
+				panic(err)
+			}
+		}
 		// wait for a SIGINT or SIGTERM signal
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		<-ch
-		fmt.Println("Received signal, shutting down...")
+		fmt.Fprintln(out, "Received signal, shutting down...")
 	}
 
 	// shut the node down
 	if err := node.Close(); err != nil {
 		panic(err)
 	}
+	os.Exit(code)
+}
+
+// reserve reserves a slot on relay so that other peers can reach node
+// through it, and prints the relayed address of node.
+func reserve(node host.Host, relay peerstore.AddrInfo, out io.Writer) error {
+	ctx := context.Background()
+	if err := node.Connect(ctx, relay); err != nil {
+		return err
+	}
+	if _, err := client.Reserve(ctx, node, relay); err != nil {
+		return fmt.Errorf("reserve a slot on the relay: %w", err)
+	}
+	for _, a := range relay.Addrs {
+		fmt.Fprintf(out, "relayed address: %s/p2p/%s/p2p-circuit/p2p/%s\n", a, relay.ID, node.ID())
+	}
+	return nil
+}
+
+// runPing connects to target, directly or through relay, pings it and
+// prints the statistics. It returns the exit code, as ping(8): 1 when no
+// reply was received, 2 on other errors.
+func runPing(node host.Host, target string, relay *peerstore.AddrInfo, opts pinger.Options, jsonOutput bool, out io.Writer) int {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	addr, err := multiaddr.NewMultiaddr(target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	peer, err := peerstore.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if relay != nil {
+		// the relay must be connected for the circuit to be dialed
+		if err := node.Connect(ctx, *relay); err != nil {
+			fmt.Fprintln(os.Stderr, "connect to the relay:", err)
+			return 2
+		}
+		circuit, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit", relay.ID))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		peer.Addrs = nil
+		for _, a := range relay.Addrs {
+			peer.Addrs = append(peer.Addrs, a.Encapsulate(circuit))
+		}
+	}
+	connectCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	err = node.Connect(connectCtx, *peer)
+	cancel()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "connect:", err)
+		return 2
+	}
+
+	fmt.Fprintf(out, "PING %s (%s)\n", peer.ID, connectionKind(node, peer.ID))
+	stats := pinger.Run(ctx, node, peer.ID, opts, func(r pinger.Result) {
+		if r.Error != "" {
+			fmt.Fprintf(out, "seq=%d %s\n", r.Seq, r.Error)
+			return
+		}
+		via := "direct"
+		if r.Relayed {
+			via = "relayed"
+		}
+		fmt.Fprintf(out, "seq=%d time=%s %s\n", r.Seq, r.RTT, via)
+	})
+
+	fmt.Fprintf(out, "--- %s ping statistics ---\n", peer.ID)
+	fmt.Fprintf(out, "%d pings transmitted, %d received (%d relayed), %.1f%% loss\n", stats.Sent, stats.Received, stats.Relayed, stats.Loss)
+	if stats.Received > 0 {
+		fmt.Fprintf(out, "rtt min/avg/max/stddev = %s/%s/%s/%s\n", stats.Min, stats.Avg, stats.Max, stats.StdDev)
+	}
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if stats.Received == 0 {
+		return 1
+	}
+	return 0
+}
+
+// connectionKind tells whether node has a direct connection to p.
+func connectionKind(node host.Host, p peerstore.ID) string {
+	for _, c := range node.Network().ConnsToPeer(p) {
+		if !netutil.IsRelayed(c.RemoteMultiaddr()) {
+			return "direct"
+		}
+	}
+	return "relayed"
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/content"
+)
+
+func init() {
+	commands["add"] = command{"<file>: chunk a file into a UnixFS DAG and print its CID", runAdd}
+	commands["get"] = command{"[-o file] <cid>: fetch a file by CID", runGet}
+}
+
+func runAdd(ctx context.Context, c *api.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a file path")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var out content.AddResult
+	if err := c.Call(ctx, "add", nil, f, &out); err != nil {
+		return err
+	}
+	fmt.Printf("added %s %s (%d bytes)\n", out.Hash, args[0], out.Size)
+	return nil
+}
+
+func runGet(ctx context.Context, c *api.Client, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	output := fs.String("o", "", "output file, defaults to stdout")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected a CID")
+	}
+
+	rc, err := c.Stream(ctx, "cat", url.Values{"arg": {fs.Arg(0)}}, nil)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err = io.Copy(w, rc)
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
+)
+
+func init() {
+	commands["dht"] = command{"mode [server|client|auto] | table: show or switch the DHT mode, list the routing table", runDHT}
+}
+
+func runDHT(ctx context.Context, c *api.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected mode or table")
+	}
+	switch args[0] {
+	case "mode":
+		var v url.Values
+		if len(args) > 1 {
+			v = url.Values{"arg": {args[1]}}
+		}
+		var out node.DHTModeResult
+		if err := c.Call(ctx, "dht/mode", v, nil, &out); err != nil {
+			return err
+		}
+		state := "client"
+		if out.Serving {
+			state = "server"
+		}
+		fmt.Printf("mode %s, currently acting as %s\n", out.Mode, state)
+	case "table":
+		var out node.RoutingTable
+		if err := c.Call(ctx, "stats/dht", nil, nil, &out); err != nil {
+			return err
+		}
+		fmt.Printf("mode %s, %d peers in %d buckets\n", out.Mode, out.Size, len(out.Buckets))
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, b := range out.Buckets {
+			fmt.Fprintf(tw, "\nbucket %d (%d peers)\n", b.Cpl, len(b.Peers))
+			fmt.Fprintln(tw, "  PEER\tCONNECTED\tLATENCY\tLAST USEFUL\tLAST QUERIED\tAGENT")
+			for _, p := range b.Peers {
+				fmt.Fprintf(tw, "  %s\t%v\t%s\t%s\t%s\t%s\n", p.ID, p.Connected,
+					p.Latency.Round(time.Millisecond), ago(p.LastUsefulAt), ago(p.LastSuccessfulOutboundQueryAt), p.AgentVersion)
+			}
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown dht command %q", args[0])
+	}
+	return nil
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/dcutr"
+)
+
+func init() {
+	commands["holepunch"] = command{": show the hole punching statistics and the peers still relayed", runHolePunch}
+}
+
+func runHolePunch(ctx context.Context, c *api.Client, args []string) error {
+	var out dcutr.Stats
+	if err := c.Call(ctx, "stats/holepunch", nil, nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("%d hole punches, %d succeeded, %d failed (%.0f%%), %d attempts, %d protocol errors\n",
+		out.Successes+out.Failures, out.Successes, out.Failures, 100*out.SuccessRate(), out.Attempts, out.ProtocolErrors)
+	fmt.Printf("%d direct dials before hole punching, %d succeeded\n", out.DirectDials, out.DirectDialSuccesses)
+	d := out.TimeToDirect
+	fmt.Printf("time to direct: %d peers, min %s, avg %s, max %s\n",
+		d.Count, d.Min.Round(time.Millisecond), d.Avg.Round(time.Millisecond), d.Max.Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, t := range []struct {
+		title  string
+		counts map[string]dcutr.Counts
+	}{{"TRANSPORT", out.ByTransport}, {"SIDE", out.BySide}} {
+		fmt.Fprintf(tw, "\n%s\tSUCCESSES\tFAILURES\tRATE\n", t.title)
+		for _, k := range sortedKeys(t.counts) {
+			cnt := t.counts[k]
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f%%\n", k, cnt.Successes, cnt.Failures, 100*cnt.SuccessRate())
+		}
+	}
+	if len(out.FailureReasons) > 0 {
+		fmt.Fprintln(tw, "\nREASON\tCOUNT")
+		for _, k := range sortedKeys(out.FailureReasons) {
+			fmt.Fprintf(tw, "%s\t%d\n", k, out.FailureReasons[k])
+		}
+	}
+	if len(out.RelayedPeers) > 0 {
+		fmt.Fprintln(tw, "\nRELAYED PEER\tRELAY\tSINCE")
+		for _, p := range out.RelayedPeers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Peer, p.Relay, ago(p.Since))
+		}
+	}
+	return tw.Flush()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+// p2pctl talks to the HTTP API of a running node.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, c *api.Client, args []string) error
+}
+
+var commands = map[string]command{}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-api addr] <command> [arguments]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(flag.CommandLine.Output())
+	flag.PrintDefaults()
+}
+
+func main() {
+	apiAddr := flag.String("api", "127.0.0.1:5001", "address of the node's HTTP API")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if err := cmd.run(ctx, api.NewClient(*apiAddr), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
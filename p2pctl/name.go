@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/names"
+)
+
+func init() {
+	commands["name"] = command{"publish [-lifetime d] [-ttl d] <path> | resolve [-r=false] [name] | inspect: manage the node's IPNS name", runName}
+}
+
+func runName(ctx context.Context, c *api.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected publish, resolve or inspect")
+	}
+	var out names.NameResult
+	switch args[0] {
+	case "publish":
+		fs := flag.NewFlagSet("name publish", flag.ExitOnError)
+		lifetime := fs.Duration("lifetime", 0, "how long the record stays valid, the node's Ipns.RecordLifetime by default")
+		ttl := fs.Duration("ttl", 0, "how long resolvers may cache the record")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("expected a path such as /ipfs/<cid>")
+		}
+		v := url.Values{"arg": {fs.Arg(0)}}
+		if *lifetime > 0 {
+			v.Set("lifetime", lifetime.String())
+		}
+		if *ttl > 0 {
+			v.Set("ttl", ttl.String())
+		}
+		if err := c.Call(ctx, "name/publish", v, nil, &out); err != nil {
+			return err
+		}
+		fmt.Printf("published %s: %s (seq %d)\n", out.Name, out.Value, out.Sequence)
+	case "resolve":
+		fs := flag.NewFlagSet("name resolve", flag.ExitOnError)
+		recursive := fs.Bool("r", true, "resolve until the result is not an IPNS name")
+		fs.Parse(args[1:])
+		v := url.Values{"recursive": {strconv.FormatBool(*recursive)}}
+		if fs.NArg() > 0 {
+			v.Set("arg", fs.Arg(0))
+		}
+		if err := c.Call(ctx, "name/resolve", v, nil, &out); err != nil {
+			return err
+		}
+		fmt.Println(out.Value)
+	case "inspect":
+		if err := c.Call(ctx, "name/inspect", nil, nil, &out); err != nil {
+			return err
+		}
+		fmt.Printf("name:     %s\nvalue:    %s\nsequence: %d\nvalidity: %s\nttl:      %s\n",
+			out.Name, out.Value, out.Sequence, out.Validity.Local().Format(time.RFC3339), out.TTL)
+	default:
+		return fmt.Errorf("unknown name command %q", args[0])
+	}
+	return nil
+}
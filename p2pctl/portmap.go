@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/portmap"
+)
+
+func init() {
+	commands["portmap"] = command{": show the UPnP/NAT-PMP gateway, the mapped external addresses and the last mapping events", runPortmap}
+}
+
+func runPortmap(ctx context.Context, c *api.Client, args []string) error {
+	var out portmap.Status
+	if err := c.Call(ctx, "stats/portmap", nil, nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("gateway %s\n", out.Gateway)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if len(out.Mappings) > 0 {
+		fmt.Fprintln(tw, "INTERNAL\tEXTERNAL\tSINCE")
+		for _, m := range out.Mappings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.Internal, m.External, ago(m.Since))
+		}
+		fmt.Fprintln(tw)
+	}
+	if len(out.Events) > 0 {
+		fmt.Fprintln(tw, "EVENT\tINTERNAL\tEXTERNAL\tPREVIOUS\tTIME")
+		for _, e := range out.Events {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Type, e.Internal, e.External, e.Previous, ago(e.Time))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/content"
+)
+
+func init() {
+	commands["provide"] = command{"[-r] <cid>: announce that the node provides a CID", runProvide}
+	commands["reprovide"] = command{": announce every block selected by the reprovider strategy now", runReprovide}
+	commands["findprovs"] = command{"[-n num] <cid>: find peers providing a CID", runFindProvs}
+	commands["pin"] = command{"add|rm <cid> | ls: manage the pinned DAGs", runPin}
+}
+
+func runProvide(ctx context.Context, c *api.Client, args []string) error {
+	fs := flag.NewFlagSet("provide", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "also provide every block linked from the CID")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected a CID")
+	}
+
+	var out content.ProvideResult
+	err := c.Call(ctx, "dht/provide", url.Values{
+		"arg":       {fs.Arg(0)},
+		"recursive": {strconv.FormatBool(*recursive)},
+	}, nil, &out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("provided %d blocks of %s\n", out.Provided, out.ID)
+	return nil
+}
+
+func runReprovide(ctx context.Context, c *api.Client, args []string) error {
+	var out content.ProvideResult
+	if err := c.Call(ctx, "dht/reprovide", nil, nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("reprovided %d blocks\n", out.Provided)
+	return nil
+}
+
+func runFindProvs(ctx context.Context, c *api.Client, args []string) error {
+	fs := flag.NewFlagSet("findprovs", flag.ExitOnError)
+	num := fs.Int("n", 20, "maximum number of providers to find")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("expected a CID")
+	}
+
+	var out content.ProvidersResult
+	err := c.Call(ctx, "dht/findprovs", url.Values{
+		"arg":           {fs.Arg(0)},
+		"num-providers": {strconv.Itoa(*num)},
+	}, nil, &out)
+	if err != nil {
+		return err
+	}
+	for _, p := range out.Providers {
+		fmt.Println(p.ID, p.Addrs)
+	}
+	return nil
+}
+
+func runPin(ctx context.Context, c *api.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected add, rm or ls")
+	}
+	var out content.PinsResult
+	switch args[0] {
+	case "add", "rm":
+		if len(args) != 2 {
+			return errors.New("expected a CID")
+		}
+		if err := c.Call(ctx, "pin/"+args[0], url.Values{"arg": {args[1]}}, nil, &out); err != nil {
+			return err
+		}
+	case "ls":
+		if err := c.Call(ctx, "pin/ls", nil, nil, &out); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown pin command %q", args[0])
+	}
+	for _, p := range out.Pins {
+		fmt.Println(p)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/reachability"
+)
+
+func init() {
+	commands["reachability"] = command{": show the reachability and which public addresses could be dialed back", runReachability}
+}
+
+func runReachability(ctx context.Context, c *api.Client, args []string) error {
+	var out reachability.Report
+	if err := c.Call(ctx, "stats/reachability", nil, nil, &out); err != nil {
+		return err
+	}
+	fmt.Printf("mode %s, reachability %s\n", out.Mode, out.Reachability)
+	if out.Error != "" {
+		fmt.Printf("last check %s failed: %s\n", ago(out.Checked), out.Error)
+		return nil
+	}
+	if out.Checked.IsZero() {
+		fmt.Println("addresses not checked yet")
+		return nil
+	}
+	if len(out.Addrs) == 0 {
+		fmt.Printf("no public address to check, %s\n", ago(out.Checked))
+		return nil
+	}
+	fmt.Printf("checked by %s %s\n", out.Server, ago(out.Checked))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ADDRESS\tREACHABLE\tERROR")
+	for _, r := range out.Addrs {
+		fmt.Fprintf(tw, "%s\t%v\t%s\n", r.Addr, r.Reachable, r.Error)
+	}
+	return tw.Flush()
+}
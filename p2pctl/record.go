@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+)
+
+func init() {
+	commands["record"] = command{"put [-f file] [value] | get <peer ID>: publish or fetch a signed DHT record", runRecord}
+}
+
+func runRecord(ctx context.Context, c *api.Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected put or get")
+	}
+	var out records.RecordResult
+	switch args[0] {
+	case "put":
+		fs := flag.NewFlagSet("record put", flag.ExitOnError)
+		file := fs.String("f", "", "read the value from a file, - for stdin")
+		fs.Parse(args[1:])
+		var body io.Reader
+		switch {
+		case *file == "-":
+			body = os.Stdin
+		case *file != "":
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			body = f
+		case fs.NArg() == 1:
+			body = bytes.NewBufferString(fs.Arg(0))
+		default:
+			return errors.New("expected a value or -f file")
+		}
+		if err := c.Call(ctx, "record/put", nil, body, &out); err != nil {
+			return err
+		}
+		fmt.Printf("published %s seq %d\n", out.Key, out.Seq)
+	case "get":
+		if len(args) != 2 {
+			return errors.New("expected a peer ID")
+		}
+		if err := c.Call(ctx, "record/get", url.Values{"arg": {args[1]}}, nil, &out); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s seq %d published %s\n", out.Key, out.Seq, out.Time.Local().Format(time.RFC3339))
+		os.Stdout.Write(out.Value)
+	default:
+		return fmt.Errorf("unknown record command %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package api serves the HTTP RPC API of our nodes and provides the client
+// used by the command line tools.
+//
+// Like the Kubo RPC API every command is a POST to /api/v0/<command>, with
+// its arguments in the query string (the main one under "arg") and results
+// or errors returned as JSON.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+)
+
+// Prefix is prepended to every command path.
+const Prefix = "/api/v0/"
+
+var logger = log.Logger("api")
+
+// Error is the JSON body of a failed command.
+type Error struct {
+	Message string
+	Code    int
+	Type    string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ArgError reports a missing or malformed argument, it is answered with
+// 400 Bad Request.
+func ArgError(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...), Code: http.StatusBadRequest, Type: "error"}
+}
+
+// Server is an HTTP server exposing commands under Prefix.
+type Server struct {
+	mux *http.ServeMux
+	srv *http.Server
+}
+
+// NewServer creates a server without any command.
+func NewServer() *Server {
+	mux := http.NewServeMux()
+	return &Server{
+		mux: mux,
+		srv: &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+	}
+}
+
+// HandleFunc registers a command returning a value that is encoded as JSON.
+func (s *Server) HandleFunc(command string, fn func(r *http.Request) (interface{}, error)) {
+	s.Handle(command, func(w http.ResponseWriter, r *http.Request) {
+		out, err := fn(r)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		WriteJSON(w, out)
+	})
+}
+
+// Handle registers a command that writes its own response, for streaming
+// results.
+func (s *Server) Handle(command string, fn http.HandlerFunc) {
+	s.mux.HandleFunc(Prefix+command, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "405 - Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fn(w, r)
+	})
+}
+
+// Mount serves h for every path below prefix, outside of the command
+// namespace.
+func (s *Server) Mount(prefix string, h http.Handler) {
+	s.mux.Handle(prefix, h)
+}
+
+// Serve listens on addr in the background.
+func (s *Server) Serve(addr string) (net.Addr, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := s.srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("API server: %v", err)
+		}
+	}()
+	return l.Addr(), nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+// WriteJSON writes v as the JSON response.
+func WriteJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warnf("Write response: %v", err)
+	}
+}
+
+// WriteError writes err as an Error response.
+func WriteError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		apiErr = &Error{Message: err.Error(), Code: http.StatusInternalServerError, Type: "error"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code)
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+// Client calls the commands of a Server.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient returns a client for the API listening on addr, either host:port
+// or a URL.
+func NewClient(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{base: strings.TrimRight(addr, "/"), http: http.DefaultClient}
+}
+
+// Stream calls command and returns the response body on success. The caller
+// must close it.
+func (c *Client) Stream(ctx context.Context, command string, args url.Values, body io.Reader) (io.ReadCloser, error) {
+	u := c.base + Prefix + command
+	if len(args) > 0 {
+		u += "?" + args.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		apiErr := &Error{}
+		if json.Unmarshal(msg, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(msg))
+		}
+		apiErr.Code = resp.StatusCode
+		return nil, apiErr
+	}
+	return resp.Body, nil
+}
+
+// Call calls command and decodes its JSON result into out, if not nil.
+func (c *Client) Call(ctx context.Context, command string, args url.Values, body io.Reader, out interface{}) error {
+	rc, err := c.Stream(ctx, command, args, body)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, rc)
+		return err
+	}
+	return json.NewDecoder(rc).Decode(out)
+}
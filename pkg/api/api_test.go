@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCall(t *testing.T) {
+	srv := NewServer()
+	srv.HandleFunc("echo", func(r *http.Request) (interface{}, error) {
+		arg := r.URL.Query().Get("arg")
+		if arg == "" {
+			return nil, ArgError("missing arg")
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"Arg": arg, "Body": string(body)}, nil
+	})
+	addr, err := srv.Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	c := NewClient(addr.String())
+	var out map[string]string
+	if err := c.Call(context.Background(), "echo", url.Values{"arg": {"hello"}}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["Arg"] != "hello" {
+		t.Fatalf("unexpected result %v", out)
+	}
+
+	var apiErr *Error
+	err = c.Call(context.Background(), "echo", nil, nil, &out)
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest || apiErr.Message != "missing arg" {
+		t.Fatalf("expected a bad request error, got %v", err)
+	}
+	err = c.Call(context.Background(), "missing", nil, nil, nil)
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	resp, err := http.Get("http://" + addr.String() + Prefix + "echo?arg=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("GET should not be allowed, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,430 @@
+// Package chat implements the chat protocols spoken between the rendezvous
+// peers and echoed by the bootstrap nodes.
+//
+// /chat/2.0.0 carries length prefixed frames with a message type and ID, so
+// it can transport multi-line or binary text, typing notifications, file
+// offers and delivery acknowledgements. /chat/1.0.0 is the original newline
+// delimited text protocol; streams fall back to it when the remote peer does
+// not support the framed protocol.
+package chat
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+const (
+	// ProtocolV1 is the newline delimited text protocol.
+	ProtocolV1 = protocol.ID("/chat/1.0.0")
+	// ProtocolV2 is the framed protocol with message types and acks.
+	ProtocolV2 = protocol.ID("/chat/2.0.0")
+)
+
+var (
+	// ErrUnsupported is returned when a message type can't be sent to a
+	// peer that only speaks /chat/1.0.0.
+	ErrUnsupported = errors.New("message type not supported by peer")
+	// ErrNotConnected is returned when there is no chat stream to the peer.
+	ErrNotConnected = errors.New("no chat stream with peer")
+	// ErrSessionClosed is returned when the stream ends before a message
+	// was written or acknowledged.
+	ErrSessionClosed = errors.New("chat stream closed")
+)
+
+var logger = log.Logger("chat")
+
+// newCodec picks the wire format of a stream from its negotiated protocol.
+func newCodec(stream network.Stream) codec {
+	r, w := bufio.NewReader(stream), bufio.NewWriter(stream)
+	if stream.Protocol() == ProtocolV1 {
+		return &lineCodec{r: r, w: w}
+	}
+	return &frameCodec{r: r, w: w}
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithReconnect makes the service re-open streams it dialed itself when they
+// end unexpectedly, trying up to attempts times and waiting backoff between
+// attempts.
+func WithReconnect(attempts int, backoff time.Duration) Option {
+	return func(s *Service) {
+		s.reconnectAttempts = attempts
+		s.reconnectBackoff = backoff
+	}
+}
+
+// Service keeps one session per chat stream. A failing stream only tears
+// down its own session, the other peers keep chatting.
+type Service struct {
+	host    host.Host
+	handler func(Message)
+	lastID  atomic.Uint64
+
+	reconnectAttempts int
+	reconnectBackoff  time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	wg       sync.WaitGroup
+}
+
+// New registers the chat stream handlers on h. handler is called from the
+// session goroutines for every message received, acknowledgements included.
+func New(h host.Host, handler func(Message), opts ...Option) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		host:     h,
+		handler:  handler,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: make(map[*session]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	h.SetStreamHandler(ProtocolV2, s.handleStream)
+	h.SetStreamHandler(ProtocolV1, s.handleStream)
+	return s
+}
+
+func (s *Service) handleStream(stream network.Stream) {
+	logger.Infof("Got a new %s stream from %s", stream.Protocol(), stream.Conn().RemotePeer())
+	if !s.start(stream, false) {
+		stream.Reset()
+	}
+}
+
+// Connect opens a chat stream to p, preferring /chat/2.0.0.
+func (s *Service) Connect(ctx context.Context, p peer.ID) error {
+	stream, err := s.host.NewStream(ctx, p, ProtocolV2, ProtocolV1)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("Negotiated %s with %s", stream.Protocol(), p)
+	if !s.start(stream, true) {
+		stream.Reset()
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+func (s *Service) start(stream network.Stream, dialed bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return false
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sess := &session{
+		svc:     s,
+		peer:    stream.Conn().RemotePeer(),
+		stream:  stream,
+		codec:   newCodec(stream),
+		dialed:  dialed,
+		send:    make(chan outgoing, 16),
+		pending: make(map[uint64]chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	s.sessions[sess] = struct{}{}
+	s.wg.Add(1)
+	go sess.run()
+	return true
+}
+
+func (s *Service) remove(sess *session) {
+	s.mu.Lock()
+	delete(s.sessions, sess)
+	s.mu.Unlock()
+}
+
+func (s *Service) snapshot() []*session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	return sessions
+}
+
+// Send writes text to every connected peer. Peers on /chat/2.0.0 acknowledge
+// it, the acks are passed to the message handler.
+func (s *Service) Send(text string) {
+	msg := Message{Type: TypeText, Body: []byte(strings.TrimRight(text, "\r\n"))}
+	for _, sess := range s.snapshot() {
+		msg.ID = s.lastID.Add(1)
+		select {
+		case sess.send <- outgoing{msg: msg}:
+		case <-sess.ctx.Done():
+		}
+	}
+}
+
+// SendMessage writes m to p and, for text and file offers sent over
+// /chat/2.0.0, waits until p acknowledged it. Typing notifications and file
+// offers can't be sent to /chat/1.0.0 peers.
+func (s *Service) SendMessage(ctx context.Context, p peer.ID, m Message) error {
+	var sess *session
+	for _, candidate := range s.snapshot() {
+		if candidate.peer != p {
+			continue
+		}
+		if sess == nil || candidate.codec.Acks() {
+			sess = candidate
+		}
+	}
+	if sess == nil {
+		return ErrNotConnected
+	}
+	if m.Type == 0 {
+		m.Type = TypeText
+	}
+	if m.ID == 0 {
+		m.ID = s.lastID.Add(1)
+	}
+	return sess.deliver(ctx, m)
+}
+
+// Peers returns the peers we currently have a chat stream with.
+func (s *Service) Peers() []peer.ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[peer.ID]struct{}, len(s.sessions))
+	peers := make([]peer.ID, 0, len(s.sessions))
+	for sess := range s.sessions {
+		if _, ok := seen[sess.peer]; ok {
+			continue
+		}
+		seen[sess.peer] = struct{}{}
+		peers = append(peers, sess.peer)
+	}
+	return peers
+}
+
+// Close removes the stream handlers and closes every session.
+func (s *Service) Close() error {
+	s.host.RemoveStreamHandler(ProtocolV2)
+	s.host.RemoveStreamHandler(ProtocolV1)
+	s.mu.Lock()
+	s.cancel()
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Service) reconnect(p peer.ID) {
+	defer s.wg.Done()
+	for i := 1; i <= s.reconnectAttempts; i++ {
+		select {
+		case <-time.After(s.reconnectBackoff):
+		case <-s.ctx.Done():
+			return
+		}
+		ctx, cancel := context.WithTimeout(s.ctx, 30*time.Second)
+		err := s.Connect(ctx, p)
+		cancel()
+		if err == nil {
+			logger.Infof("Reconnected to %s", p)
+			return
+		}
+		logger.Warnf("Reconnect to %s (attempt %d/%d): %v", p, i, s.reconnectAttempts, err)
+	}
+}
+
+type outgoing struct {
+	msg Message
+	// written receives the result of the write, if not nil.
+	written chan error
+}
+
+type session struct {
+	svc    *Service
+	peer   peer.ID
+	stream network.Stream
+	codec  codec
+	dialed bool
+	send   chan outgoing
+
+	mu      sync.Mutex
+	pending map[uint64]chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (sess *session) run() {
+	defer sess.svc.wg.Done()
+
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- sess.writeLoop()
+	}()
+	readerDone := make(chan error, 1)
+	go func() {
+		readerDone <- sess.readLoop()
+	}()
+
+	var err error
+	select {
+	case err = <-readerDone:
+		readerDone = nil
+	case err = <-writerDone:
+		writerDone = nil
+	case <-sess.ctx.Done():
+	}
+	sess.cancel()
+	if err == nil || errors.Is(err, io.EOF) {
+		sess.stream.Close()
+	} else {
+		sess.stream.Reset()
+	}
+	// Closing the stream unblocks whichever loop is still running.
+	if readerDone != nil {
+		<-readerDone
+	}
+	if writerDone != nil {
+		<-writerDone
+	}
+	sess.svc.remove(sess)
+
+	switch {
+	case sess.svc.ctx.Err() != nil:
+		return
+	case err == nil || errors.Is(err, io.EOF):
+		// The peer hung up on purpose, don't dial it again.
+		logger.Infof("Peer %s closed the chat stream", sess.peer)
+		return
+	default:
+		logger.Warnf("Chat stream with %s failed: %v", sess.peer, err)
+	}
+	if sess.dialed && sess.svc.reconnectAttempts > 0 {
+		sess.svc.mu.Lock()
+		if sess.svc.ctx.Err() == nil {
+			sess.svc.wg.Add(1)
+			go sess.svc.reconnect(sess.peer)
+		}
+		sess.svc.mu.Unlock()
+	}
+}
+
+func (sess *session) deliver(ctx context.Context, m Message) error {
+	needAck := sess.codec.Acks() && (m.Type == TypeText || m.Type == TypeFileOffer)
+	var acked chan struct{}
+	if needAck {
+		acked = make(chan struct{})
+		sess.mu.Lock()
+		sess.pending[m.ID] = acked
+		sess.mu.Unlock()
+		defer func() {
+			sess.mu.Lock()
+			delete(sess.pending, m.ID)
+			sess.mu.Unlock()
+		}()
+	}
+
+	written := make(chan error, 1)
+	select {
+	case sess.send <- outgoing{msg: m, written: written}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sess.ctx.Done():
+		return ErrSessionClosed
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sess.ctx.Done():
+		return ErrSessionClosed
+	}
+	if !needAck {
+		return nil
+	}
+
+	select {
+	case <-acked:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-sess.ctx.Done():
+		return ErrSessionClosed
+	}
+}
+
+func (sess *session) readLoop() error {
+	for {
+		m, err := sess.codec.ReadMessage()
+		if err != nil {
+			return err
+		}
+		m.From = sess.peer
+
+		switch m.Type {
+		case TypeAck:
+			sess.mu.Lock()
+			if acked, ok := sess.pending[m.ID]; ok {
+				close(acked)
+				delete(sess.pending, m.ID)
+			}
+			sess.mu.Unlock()
+		case TypeText, TypeFileOffer:
+			if sess.codec.Acks() {
+				select {
+				case sess.send <- outgoing{msg: Message{Type: TypeAck, ID: m.ID}}:
+				case <-sess.ctx.Done():
+					return nil
+				}
+			}
+		case TypeTyping:
+		default:
+			logger.Debugf("Ignoring %s message from %s", m.Type, sess.peer)
+			continue
+		}
+
+		if sess.svc.handler != nil {
+			sess.svc.handler(m)
+		}
+	}
+}
+
+func (sess *session) writeLoop() error {
+	for {
+		select {
+		case <-sess.ctx.Done():
+			return nil
+		case out := <-sess.send:
+			err := sess.codec.WriteMessage(out.msg)
+			if out.written != nil {
+				out.written <- err
+			}
+			if errors.Is(err, ErrUnsupported) {
+				logger.Debugf("Dropping %s message to %s", out.msg.Type, sess.peer)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
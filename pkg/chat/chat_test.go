@@ -0,0 +1,292 @@
+package chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func newService(t *testing.T, mn mocknet.Mocknet, i int) (*Service, chan Message) {
+	msgs := make(chan Message, 16)
+	s := New(mn.Hosts()[i], func(m Message) { msgs <- m })
+	t.Cleanup(func() { s.Close() })
+	return s, msgs
+}
+
+func waitPeers(t *testing.T, s *Service, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Peers()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d chat peers, have %v", n, s.Peers())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func expectMessage(t *testing.T, msgs chan Message, from peer.ID, text string) {
+	for {
+		select {
+		case m := <-msgs:
+			if m.Type == TypeAck {
+				continue
+			}
+			if m.From != from || m.Text() != text {
+				t.Fatalf("unexpected message %+v, want %q from %s", m, text, from)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", text)
+		}
+	}
+}
+
+func TestPeerLeavingDoesNotAffectOthers(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	a, aMsgs := newService(t, mn, 0)
+	b, bMsgs := newService(t, mn, 1)
+	c, _ := newService(t, mn, 2)
+
+	ctx := context.Background()
+	if err := a.Connect(ctx, hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Connect(ctx, hosts[2].ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, b, 1)
+	waitPeers(t, c, 1)
+
+	// C goes away without saying goodbye.
+	if err := hosts[2].Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, a, 1)
+
+	a.Send("hello b\n")
+	expectMessage(t, bMsgs, hosts[0].ID(), "hello b")
+	b.Send("hello a")
+	expectMessage(t, aMsgs, hosts[1].ID(), "hello a")
+}
+
+func TestRemoteCloseEndsSession(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	a, _ := newService(t, mn, 0)
+	b, _ := newService(t, mn, 1)
+	if err := a.Connect(context.Background(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, b, 1)
+
+	b.Close()
+	waitPeers(t, a, 0)
+	// Sending with no peers left must not block.
+	a.Send("anyone?")
+}
+
+func TestReconnect(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	a := New(hosts[0], nil, WithReconnect(3, 10*time.Millisecond))
+	defer a.Close()
+	b, bMsgs := newService(t, mn, 1)
+	if err := a.Connect(context.Background(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, b, 1)
+
+	// Reset B's side of the stream, A should dial again.
+	b.mu.Lock()
+	for sess := range b.sessions {
+		sess.stream.Reset()
+	}
+	b.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.Send("still there?")
+		select {
+		case m := <-bMsgs:
+			if m.Text() != "still there?" {
+				t.Fatalf("unexpected message %+v", m)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("A did not reconnect to B")
+		}
+	}
+}
+
+func TestNoReconnectAfterClose(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	a := New(hosts[0], nil, WithReconnect(3, 10*time.Millisecond))
+	defer a.Close()
+	b, _ := newService(t, mn, 1)
+	if err := a.Connect(context.Background(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	waitPeers(t, b, 1)
+
+	// B hangs up cleanly but keeps accepting streams, A must not dial again.
+	b.mu.Lock()
+	for sess := range b.sessions {
+		sess.cancel()
+	}
+	b.mu.Unlock()
+	waitPeers(t, a, 0)
+	time.Sleep(200 * time.Millisecond)
+	if peers := b.Peers(); len(peers) != 0 {
+		t.Fatalf("A dialed B again: %v", peers)
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	msgs := []Message{
+		{Type: TypeText, ID: 1, Body: []byte("first line\nsecond line")},
+		{Type: TypeText, ID: 300, Body: []byte{0, 1, 2, '\n', 0xff}},
+		{Type: TypeTyping},
+		{Type: TypeAck, ID: 300},
+	}
+	var buf bytes.Buffer
+	for _, m := range msgs {
+		if err := WriteFrame(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bufio.NewReader(&buf)
+	for _, want := range msgs {
+		got, err := ReadFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != want.Type || got.ID != want.ID || !bytes.Equal(got.Body, want.Body) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if err := WriteFrame(&buf, Message{Type: TypeText, Body: make([]byte, MaxFrameSize)}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("expected ErrFrameTooLarge, got %v", err)
+	}
+}
+
+func TestSendMessageAcknowledged(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	a, aMsgs := newService(t, mn, 0)
+	_, bMsgs := newService(t, mn, 1)
+	if err := a.Connect(context.Background(), hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.SendMessage(ctx, hosts[1].ID(), Message{Type: TypeTyping}); err != nil {
+		t.Fatal(err)
+	}
+	text := "line one\nline two"
+	if err := a.SendMessage(ctx, hosts[1].ID(), Message{Body: []byte(text)}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := NewFileOffer(FileOffer{Name: "model.bin", Size: 42, SHA256: "00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SendMessage(ctx, hosts[1].ID(), offer); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := <-bMsgs; m.Type != TypeTyping {
+		t.Fatalf("expected typing notification, got %+v", m)
+	}
+	expectMessage(t, bMsgs, hosts[0].ID(), text)
+	m := <-bMsgs
+	got, err := m.FileOffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "model.bin" || got.Size != 42 {
+		t.Fatalf("unexpected file offer %+v", got)
+	}
+	for i := 0; i < 2; i++ {
+		if m := <-aMsgs; m.Type != TypeAck {
+			t.Fatalf("expected ack, got %+v", m)
+		}
+	}
+}
+
+func TestFallbackToV1(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	// B is an old peer that only knows the line protocol.
+	lines := make(chan string, 4)
+	hosts[1].SetStreamHandler(ProtocolV1, func(s network.Stream) {
+		r := bufio.NewReader(s)
+		for {
+			str, err := r.ReadString('\n')
+			if err != nil {
+				s.Close()
+				return
+			}
+			lines <- str
+		}
+	})
+
+	a, _ := newService(t, mn, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := a.Connect(ctx, hosts[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SendMessage(ctx, hosts[1].ID(), Message{Type: TypeTyping}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+	if err := a.SendMessage(ctx, hosts[1].ID(), Message{Body: []byte("hello\nold friend")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"hello\n", "old friend\n"} {
+		if got := <-lines; got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
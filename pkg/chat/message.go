@@ -0,0 +1,202 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxFrameSize bounds the payload of a single /chat/2.0.0 frame.
+const MaxFrameSize = 1 << 20
+
+// MessageType tells the receiver how to interpret the body of a message.
+type MessageType uint8
+
+const (
+	// TypeText carries UTF-8 text, possibly spanning several lines.
+	TypeText MessageType = iota + 1
+	// TypeTyping tells the peer we are composing a message. It has no body.
+	TypeTyping
+	// TypeAck acknowledges the delivery of the message with the same ID.
+	TypeAck
+	// TypeFileOffer carries a JSON encoded FileOffer.
+	TypeFileOffer
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case TypeText:
+		return "text"
+	case TypeTyping:
+		return "typing"
+	case TypeAck:
+		return "ack"
+	case TypeFileOffer:
+		return "file-offer"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+// Message is a chat message received from or sent to a remote peer. ID is
+// chosen by the sender and echoed back in the acknowledgement.
+type Message struct {
+	From peer.ID
+	Type MessageType
+	ID   uint64
+	Body []byte
+}
+
+// Text returns the body as a string.
+func (m Message) Text() string {
+	return string(m.Body)
+}
+
+// FileOffer announces a file the sender is willing to transfer.
+type FileOffer struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// NewFileOffer builds a TypeFileOffer message for offer.
+func NewFileOffer(offer FileOffer) (Message, error) {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return Message{}, err
+	}
+	return Message{Type: TypeFileOffer, Body: body}, nil
+}
+
+// FileOffer decodes the body of a TypeFileOffer message.
+func (m Message) FileOffer() (FileOffer, error) {
+	var offer FileOffer
+	if m.Type != TypeFileOffer {
+		return offer, fmt.Errorf("not a file offer: %s", m.Type)
+	}
+	err := json.Unmarshal(m.Body, &offer)
+	return offer, err
+}
+
+// ErrFrameTooLarge is returned for frames bigger than MaxFrameSize.
+var ErrFrameTooLarge = errors.New("chat frame too large")
+
+// WriteFrame writes m as a length prefixed /chat/2.0.0 frame: a uvarint
+// payload length followed by the type byte, the uvarint message ID and the
+// body.
+func WriteFrame(w io.Writer, m Message) error {
+	payload := make([]byte, 1, 1+binary.MaxVarintLen64+len(m.Body))
+	payload[0] = byte(m.Type)
+	payload = binary.AppendUvarint(payload, m.ID)
+	payload = append(payload, m.Body...)
+	if len(payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	frame := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(payload)), uint64(len(payload)))
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a single /chat/2.0.0 frame from r.
+func ReadFrame(r *bufio.Reader) (Message, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return Message{}, err
+	}
+	if size > MaxFrameSize {
+		return Message{}, ErrFrameTooLarge
+	}
+	if size == 0 {
+		return Message{}, errors.New("empty chat frame")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Message{}, err
+	}
+	id, n := binary.Uvarint(payload[1:])
+	if n <= 0 {
+		return Message{}, errors.New("malformed chat frame id")
+	}
+	return Message{
+		Type: MessageType(payload[0]),
+		ID:   id,
+		Body: payload[1+n:],
+	}, nil
+}
+
+// codec reads and writes messages in the wire format of one protocol
+// version.
+type codec interface {
+	ReadMessage() (Message, error)
+	WriteMessage(Message) error
+	// Acks reports whether the remote side acknowledges messages.
+	Acks() bool
+}
+
+// frameCodec speaks /chat/2.0.0.
+type frameCodec struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *frameCodec) ReadMessage() (Message, error) {
+	return ReadFrame(c.r)
+}
+
+func (c *frameCodec) WriteMessage(m Message) error {
+	if err := WriteFrame(c.w, m); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *frameCodec) Acks() bool {
+	return true
+}
+
+// lineCodec speaks /chat/1.0.0, which only knows about lines of text.
+type lineCodec struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *lineCodec) ReadMessage() (Message, error) {
+	for {
+		str, err := c.r.ReadString('\n')
+		if err != nil {
+			return Message{}, err
+		}
+		str = strings.TrimRight(str, "\r\n")
+		if str == "" {
+			continue
+		}
+		return Message{Type: TypeText, Body: []byte(str)}, nil
+	}
+}
+
+func (c *lineCodec) WriteMessage(m Message) error {
+	if m.Type != TypeText {
+		return ErrUnsupported
+	}
+	for _, line := range strings.Split(strings.TrimRight(m.Text(), "\r\n"), "\n") {
+		if _, err := c.w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
+
+func (c *lineCodec) Acks() bool {
+	return false
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 )
@@ -13,6 +14,88 @@ import (
 type Config struct {
 	TestField     string `json:"test_field"`
 	ListenAddress string `json:"listen_address"`
+
+	// The sections below follow the Kubo config file layout.
+	Identity   Identity   `json:"Identity"`
+	Addresses  Addresses  `json:"Addresses"`
+	Bootstrap  []string   `json:"Bootstrap"`
+	Reprovider Reprovider `json:"Reprovider"`
+	Ipns       Ipns       `json:"Ipns"`
+	Routing    Routing    `json:"Routing"`
+	Pnet       Pnet       `json:"Pnet"`
+}
+
+// Addresses configures the addresses the node advertises to its peers, as
+// Kubo does.
+type Addresses struct {
+	// Announce replaces the listen and observed addresses when not empty.
+	Announce []string `json:"Announce"`
+	// AppendAnnounce is advertised in addition.
+	AppendAnnounce []string `json:"AppendAnnounce"`
+	// NoAnnounce removes addresses from the advertised ones, either exact
+	// multiaddrs or networks such as /ip4/10.0.0.0/ipcidr/8.
+	NoAnnounce []string `json:"NoAnnounce"`
+}
+
+// Pnet configures the private network. It isn't part of the Kubo config.
+type Pnet struct {
+	// SwarmKey is the swarm.key file used when -swarm-key isn't given.
+	SwarmKey string `json:"SwarmKey"`
+	// NextSwarmKey is set while rotating the pre-shared key: the node joins
+	// the network of this key too, listening on NextAddresses.
+	NextSwarmKey  string   `json:"NextSwarmKey"`
+	NextAddresses []string `json:"NextAddresses"`
+	// NextBootstrap are the addresses of the other bootstrap nodes on the
+	// network of NextSwarmKey.
+	NextBootstrap []string `json:"NextBootstrap"`
+}
+
+// Routing configures the DHT.
+type Routing struct {
+	// Type is the DHT mode: "auto", "autoserver", "client" or "server",
+	// Kubo's "dht", "dhtclient" and "dhtserver" are accepted too.
+	Type string `json:"Type"`
+}
+
+// Reprovider configures how often and which of the node's blocks are
+// announced to the DHT.
+type Reprovider struct {
+	// Interval is a duration such as "12h", "0" disables reproviding.
+	Interval string `json:"Interval"`
+	// Strategy is one of "all", "pinned" or "roots".
+	Strategy string `json:"Strategy"`
+}
+
+// IntervalDuration parses Interval, returning def when it is not set.
+func (r Reprovider) IntervalDuration(def time.Duration) (time.Duration, error) {
+	return parseDuration(r.Interval, def)
+}
+
+// Ipns configures the name published by the node.
+type Ipns struct {
+	// RepublishPeriod is a duration such as "4h", "0" disables republishing.
+	RepublishPeriod string `json:"RepublishPeriod"`
+	// RecordLifetime is how long published records stay valid, e.g. "48h".
+	RecordLifetime string `json:"RecordLifetime"`
+}
+
+// RepublishPeriodDuration parses RepublishPeriod, returning def when it is
+// not set.
+func (i Ipns) RepublishPeriodDuration(def time.Duration) (time.Duration, error) {
+	return parseDuration(i.RepublishPeriod, def)
+}
+
+// RecordLifetimeDuration parses RecordLifetime, returning def when it is not
+// set.
+func (i Ipns) RecordLifetimeDuration(def time.Duration) (time.Duration, error) {
+	return parseDuration(i.RecordLifetime, def)
+}
+
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	return time.ParseDuration(s)
 }
 
 func (config Config) String() string {
@@ -50,8 +133,23 @@ func LoadPeerKey(filePath string) (crypto.PrivKey, crypto.PubKey, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	priv, err := crypto.UnmarshalPrivateKey(privBytes)
-	return priv, priv.GetPublic(), err
+	var priv crypto.PrivKey
+	if IsEncryptedKey(privBytes) {
+		passphrase, err := ReadPassphrase(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		priv, err = DecryptPrivateKey(privBytes, passphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		priv, err = DecodePrivateKey(privBytes)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return priv, priv.GetPublic(), nil
 }
 
 func SavePeerKey(filePath string, priv crypto.PrivKey) error {
@@ -67,15 +165,35 @@ func SavePeerKey(filePath string, priv crypto.PrivKey) error {
 }
 
 func GeneratePeerKey(filePath string) (crypto.PrivKey, crypto.PubKey, error) {
-	priv, pub, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	return GeneratePeerKeyType(filePath, crypto.Ed25519, -1)
+}
+
+// GeneratePeerKeyType generates a key of type typ (and size bits for RSA)
+// and saves it to filePath, encrypted when LIBP2P_KEY_PASSPHRASE or
+// LIBP2P_KEY_PASSPHRASE_FILE is set.
+func GeneratePeerKeyType(filePath string, typ, bits int) (crypto.PrivKey, crypto.PubKey, error) {
+	priv, err := GenerateKey(typ, bits)
 	if err != nil {
 		return nil, nil, err
 	} else {
-		err := SavePeerKey(filePath, priv)
+		err := StorePeerKey(filePath, priv)
 		if err != nil {
 			return nil, nil, err
 		} else {
-			return priv, pub, err
+			return priv, priv.GetPublic(), err
 		}
 	}
 }
+
+// StorePeerKey is SavePeerKey, encrypting the key when a passphrase is set
+// in the environment.
+func StorePeerKey(filePath string, priv crypto.PrivKey) error {
+	if os.Getenv(EnvKeyPassphrase) == "" && os.Getenv(EnvKeyPassphraseFile) == "" {
+		return SavePeerKey(filePath, priv)
+	}
+	passphrase, err := ReadPassphrase(filePath)
+	if err != nil {
+		return err
+	}
+	return SaveEncryptedPeerKey(filePath, priv, passphrase)
+}
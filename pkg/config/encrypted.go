@@ -0,0 +1,211 @@
+package config
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// Environment variables holding the passphrase of encrypted key files.
+const (
+	EnvKeyPassphrase     = "LIBP2P_KEY_PASSPHRASE"
+	EnvKeyPassphraseFile = "LIBP2P_KEY_PASSPHRASE_FILE"
+)
+
+// encryptedKeyType is the PEM block type of encrypted key files.
+const encryptedKeyType = "LIBP2P ENCRYPTED PRIVATE KEY"
+
+// scrypt parameters of new key files, the ones used when reading a file are
+// stored in its headers.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Largest scrypt parameters accepted from the headers of a key file, a
+// crafted file could otherwise make us allocate gigabytes or spin for hours.
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 32
+	maxScryptP = 16
+)
+
+var (
+	// ErrWrongPassphrase is returned when an encrypted key file can't be
+	// decrypted with the passphrase given.
+	ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+	// ErrNoPassphrase is returned when a key file is encrypted but no
+	// passphrase is set and there is no terminal to ask for it.
+	ErrNoPassphrase = errors.New("key file is encrypted, set " + EnvKeyPassphrase + " or " + EnvKeyPassphraseFile)
+)
+
+// IsEncryptedKey reports whether data is a key file written by
+// EncryptPrivateKey.
+func IsEncryptedKey(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == encryptedKeyType
+}
+
+// EncryptPrivateKey encrypts priv with a key derived from passphrase by
+// scrypt, using XChaCha20-Poly1305. The result is a PEM block carrying the
+// KDF parameters in its headers.
+func EncryptPrivateKey(priv crypto.PrivKey, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	plain, err := crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keyAEAD(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type: encryptedKeyType,
+		Headers: map[string]string{
+			"KDF":  "scrypt",
+			"N":    strconv.Itoa(scryptN),
+			"R":    strconv.Itoa(scryptR),
+			"P":    strconv.Itoa(scryptP),
+			"Salt": base64.StdEncoding.EncodeToString(salt),
+		},
+		Bytes: aead.Seal(nonce, nonce, plain, []byte(encryptedKeyType)),
+	}), nil
+}
+
+// DecryptPrivateKey decrypts a key file written by EncryptPrivateKey.
+func DecryptPrivateKey(data, passphrase []byte) (crypto.PrivKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != encryptedKeyType {
+		return nil, errors.New("not an encrypted key file")
+	}
+	if kdf := block.Headers["KDF"]; kdf != "scrypt" {
+		return nil, fmt.Errorf("unsupported KDF %q", kdf)
+	}
+	var params [3]int
+	for i, name := range []string{"N", "R", "P"} {
+		v, err := strconv.Atoi(block.Headers[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %w", name, err)
+		}
+		params[i] = v
+	}
+	if n := params[0]; n < 2 || n > maxScryptN || n&(n-1) != 0 {
+		return nil, fmt.Errorf("invalid N header %d, must be a power of two up to %d", n, maxScryptN)
+	}
+	if r := params[1]; r < 1 || r > maxScryptR {
+		return nil, fmt.Errorf("invalid R header %d, must be between 1 and %d", r, maxScryptR)
+	}
+	if p := params[2]; p < 1 || p > maxScryptP {
+		return nil, fmt.Errorf("invalid P header %d, must be between 1 and %d", p, maxScryptP)
+	}
+	salt, err := base64.StdEncoding.DecodeString(block.Headers["Salt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid Salt header: %w", err)
+	}
+	aead, err := keyAEAD(passphrase, salt, params[0], params[1], params[2])
+	if err != nil {
+		return nil, err
+	}
+	if len(block.Bytes) < aead.NonceSize() {
+		return nil, ErrWrongPassphrase
+	}
+	nonce, sealed := block.Bytes[:aead.NonceSize()], block.Bytes[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(encryptedKeyType))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return crypto.UnmarshalPrivateKey(plain)
+}
+
+func keyAEAD(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// SaveEncryptedPeerKey is SavePeerKey for an encrypted key file.
+func SaveEncryptedPeerKey(filePath string, priv crypto.PrivKey, passphrase []byte) error {
+	data, err := EncryptPrivateKey(priv, passphrase)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0600)
+}
+
+// ReadPassphrase returns the passphrase of the key file at filePath from
+// LIBP2P_KEY_PASSPHRASE, the file named by LIBP2P_KEY_PASSPHRASE_FILE, or by
+// asking on the terminal, in that order.
+func ReadPassphrase(filePath string) ([]byte, error) {
+	if p := os.Getenv(EnvKeyPassphrase); p != "" {
+		return []byte(p), nil
+	}
+	if f := os.Getenv(EnvKeyPassphraseFile); f != "" {
+		p, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimRight(p, "\r\n"), nil
+	}
+	return promptPassphrase(fmt.Sprintf("Passphrase for %s: ", filePath))
+}
+
+// NewPassphrase is ReadPassphrase for a new key file: when asking on the
+// terminal, the passphrase is asked twice.
+func NewPassphrase(filePath string) ([]byte, error) {
+	if os.Getenv(EnvKeyPassphrase) != "" || os.Getenv(EnvKeyPassphraseFile) != "" {
+		return ReadPassphrase(filePath)
+	}
+	p, err := promptPassphrase(fmt.Sprintf("New passphrase for %s: ", filePath))
+	if err != nil {
+		return nil, err
+	}
+	again, err := promptPassphrase("Repeat passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(p, again) {
+		return nil, errors.New("passphrases don't match")
+	}
+	return p, nil
+}
+
+func promptPassphrase(prompt string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, ErrNoPassphrase
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return p, nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	data, err := EncryptPrivateKey(priv, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedKey(data) {
+		t.Fatal("not detected as encrypted")
+	}
+	if _, err := DecodePrivateKey(data); err == nil {
+		t.Error("DecodePrivateKey accepted an encrypted key")
+	}
+	if _, err := DecryptPrivateKey(data, []byte("battery staple")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+	got, err := DecryptPrivateKey(data, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(priv) {
+		t.Error("decrypted key differs")
+	}
+}
+
+func TestLoadEncryptedPeerKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "peer.key")
+	passFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(passFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvKeyPassphrase, "")
+	t.Setenv(EnvKeyPassphraseFile, passFile)
+
+	priv, _, err := GeneratePeerKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !IsEncryptedKey(data) {
+		t.Fatal("generated key isn't encrypted")
+	}
+	got, _, err := LoadPeerKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(priv) {
+		t.Error("loaded key differs")
+	}
+
+	t.Setenv(EnvKeyPassphraseFile, "")
+	t.Setenv(EnvKeyPassphrase, "wrong")
+	if _, _, err := LoadPeerKey(path); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+}
+
+func TestDecryptScryptParams(t *testing.T) {
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	data, err := EncryptPrivateKey(priv, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ name, value string }{
+		{"N", "0"},
+		{"N", "1"},
+		{"N", "3000"},
+		{"N", "2097152"},
+		{"R", "0"},
+		{"R", "33"},
+		{"P", "0"},
+		{"P", "17"},
+	} {
+		block, _ := pem.Decode(data)
+		block.Headers[tc.name] = tc.value
+		if _, err := DecryptPrivateKey(pem.EncodeToMemory(block), []byte("correct horse")); err == nil || errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("%s=%s: got %v", tc.name, tc.value, err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Key file formats.
+const (
+	// FormatRaw is the protobuf encoding of crypto.MarshalPrivateKey, used
+	// by the peer key files.
+	FormatRaw = "raw"
+	// FormatBase64 is the protobuf encoding in base64, as stored in Kubo's
+	// Identity.PrivKey.
+	FormatBase64 = "base64"
+	// FormatPEM is a PKCS #8 "PRIVATE KEY" PEM block. secp256k1 keys can't
+	// be stored in it.
+	FormatPEM = "pem"
+)
+
+var keyTypes = map[string]int{
+	"ed25519":   crypto.Ed25519,
+	"secp256k1": crypto.Secp256k1,
+	"ecdsa":     crypto.ECDSA,
+	"rsa":       crypto.RSA,
+}
+
+// ParseKeyType returns the crypto key type named s: ed25519, secp256k1,
+// ecdsa or rsa.
+func ParseKeyType(s string) (int, error) {
+	typ, ok := keyTypes[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown key type %q", s)
+	}
+	return typ, nil
+}
+
+// KeyTypeString is the inverse of ParseKeyType.
+func KeyTypeString(typ int) string {
+	for name, t := range keyTypes {
+		if t == typ {
+			return name
+		}
+	}
+	return fmt.Sprintf("type(%d)", typ)
+}
+
+// GenerateKey generates a key of the given type, bits is only used for RSA.
+func GenerateKey(typ, bits int) (crypto.PrivKey, error) {
+	if typ == crypto.RSA && bits <= 0 {
+		bits = 2048
+	}
+	priv, _, err := crypto.GenerateKeyPair(typ, bits)
+	return priv, err
+}
+
+// EncodePrivateKey encodes priv in format.
+func EncodePrivateKey(priv crypto.PrivKey, format string) ([]byte, error) {
+	switch format {
+	case FormatRaw, FormatBase64:
+		b, err := crypto.MarshalPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		if format == FormatBase64 {
+			return []byte(crypto.ConfigEncodeKey(b)), nil
+		}
+		return b, nil
+	case FormatPEM:
+		std, err := crypto.PrivKeyToStdKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		// x509 wants ed25519 keys by value.
+		if k, ok := std.(*ed25519.PrivateKey); ok {
+			std = *k
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(std)
+		if err != nil {
+			return nil, fmt.Errorf("%s keys can't be stored as PEM: %w", KeyTypeString(int(priv.Type())), err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	return nil, fmt.Errorf("unknown key format %q", format)
+}
+
+// DecodePrivateKey decodes a key in any of the formats of EncodePrivateKey.
+func DecodePrivateKey(data []byte) (crypto.PrivKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type == encryptedKeyType {
+			return nil, errors.New("key is encrypted, use DecryptPrivateKey")
+		}
+		if block.Type != "PRIVATE KEY" {
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		std, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := std.(ed25519.PrivateKey); ok {
+			std = &k
+		}
+		priv, _, err := crypto.KeyPairFromStdKey(std)
+		return priv, err
+	}
+	if priv, err := crypto.UnmarshalPrivateKey(data); err == nil {
+		return priv, nil
+	}
+	b, err := crypto.ConfigDecodeKey(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, errors.New("not a raw, base64 or PEM private key")
+	}
+	return crypto.UnmarshalPrivateKey(b)
+}
+
+// Identity is the Identity section of the config file, as in Kubo.
+type Identity struct {
+	PeerID  string `json:"PeerID"`
+	PrivKey string `json:"PrivKey"`
+}
+
+// NewIdentity returns the Identity section holding priv.
+func NewIdentity(priv crypto.PrivKey) (Identity, error) {
+	id, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return Identity{}, err
+	}
+	b, err := EncodePrivateKey(priv, FormatBase64)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{PeerID: id.String(), PrivKey: string(b)}, nil
+}
+
+// PrivateKey decodes PrivKey and checks that it matches PeerID.
+func (i Identity) PrivateKey() (crypto.PrivKey, error) {
+	b, err := crypto.ConfigDecodeKey(i.PrivKey)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := crypto.UnmarshalPrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	if i.PeerID != "" {
+		id, err := peer.Decode(i.PeerID)
+		if err != nil {
+			return nil, err
+		}
+		if !id.MatchesPrivateKey(priv) {
+			return nil, fmt.Errorf("Identity.PrivKey doesn't match Identity.PeerID %s", id)
+		}
+	}
+	return priv, nil
+}
+
+// SaveIdentity writes id into the Identity section of the config file at
+// configPath, creating the file if needed. The other sections are kept as
+// they are.
+func SaveIdentity(configPath string, id Identity) error {
+	return saveSection(configPath, "Identity", id)
+}
+
+// ErrIdentityExists is returned by ImportIdentity when the config file
+// already holds a private key.
+var ErrIdentityExists = errors.New("config file already has an Identity.PrivKey")
+
+// ImportIdentity is SaveIdentity refusing to replace the private key of an
+// existing Identity section, the node's peer ID would be lost for good,
+// unless force is set.
+func ImportIdentity(configPath string, id Identity, force bool) error {
+	if !force {
+		cfg, err := LoadConfig(configPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil && cfg.Identity.PrivKey != "" {
+			return ErrIdentityExists
+		}
+	}
+	return SaveIdentity(configPath, id)
+}
+
+// SaveBootstrap replaces the Bootstrap section of the config file at
+// configPath like SaveIdentity.
+func SaveBootstrap(configPath string, addrs []string) error {
+	return saveSection(configPath, "Bootstrap", addrs)
+}
+
+// ReplaceBootstrapPeer returns addrs with the /p2p/ component of the
+// addresses of from replaced by to, and whether any address changed.
+func ReplaceBootstrapPeer(addrs []string, from, to peer.ID) ([]string, bool) {
+	out := make([]string, len(addrs))
+	changed := false
+	for i, addr := range addrs {
+		out[i] = addr
+		if base, ok := strings.CutSuffix(addr, "/p2p/"+from.String()); ok {
+			out[i] = base + "/p2p/" + to.String()
+			changed = true
+		}
+	}
+	return out, changed
+}
+
+// saveSection replaces the section called name of the config file at
+// configPath with v, or adds it at the end.
+func saveSection(configPath, name string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var sections []section
+	mode := os.FileMode(0600)
+	data, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		if sections, err = readSections(data); err != nil {
+			return err
+		}
+		if fi, err := os.Stat(configPath); err == nil {
+			mode = fi.Mode().Perm()
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	found := false
+	for i := range sections {
+		if sections[i].name == name {
+			sections[i].value = value
+			found = true
+		}
+	}
+	if !found {
+		sections = append(sections, section{name, value})
+	}
+
+	// The sections keep the order of the file, json.MarshalIndent of a map
+	// would sort them.
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, sec := range sections {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(sec.name)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(sec.value)
+	}
+	buf.WriteByte('}')
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	return writeFileAtomic(configPath, out.Bytes(), mode)
+}
+
+// section is a top-level entry of a config file.
+type section struct {
+	name  string
+	value json.RawMessage
+}
+
+// readSections returns the top-level entries of the JSON object in data, in
+// order.
+func readSections(data []byte) ([]section, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("config file isn't a JSON object")
+	}
+	var sections []section
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var sec section
+		sec.name = t.(string)
+		if err := dec.Decode(&sec.value); err != nil {
+			return nil, err
+		}
+		sections = append(sections, sec)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, a crash leaves either the old or the new file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
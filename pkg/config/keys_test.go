@@ -0,0 +1,180 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestEncodeDecodePrivateKey(t *testing.T) {
+	for _, name := range []string{"ed25519", "secp256k1", "ecdsa", "rsa"} {
+		typ, err := ParseKeyType(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		priv, err := GenerateKey(typ, 2048)
+		if err != nil {
+			t.Fatalf("generate %s: %v", name, err)
+		}
+		for _, format := range []string{FormatRaw, FormatBase64, FormatPEM} {
+			data, err := EncodePrivateKey(priv, format)
+			if typ == crypto.Secp256k1 && format == FormatPEM {
+				if err == nil {
+					t.Errorf("%s %s: expected an error", name, format)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, format, err)
+			}
+			got, err := DecodePrivateKey(data)
+			if err != nil {
+				t.Fatalf("%s %s: decode: %v", name, format, err)
+			}
+			if !got.Equals(priv) {
+				t.Errorf("%s %s: decoded key differs", name, format)
+			}
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	id, err := NewIdentity(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := id.PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(priv) {
+		t.Error("identity key differs")
+	}
+
+	other, _ := GenerateKey(crypto.Ed25519, 0)
+	otherID, _ := NewIdentity(other)
+	id.PeerID = otherID.PeerID
+	if _, err := id.PrivateKey(); err == nil {
+		t.Error("expected a mismatch error")
+	}
+}
+
+func TestSaveIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"Routing": {"Type": "dhtclient"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	id, _ := NewIdentity(priv)
+	if err := SaveIdentity(path, id); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Identity != id {
+		t.Errorf("got identity %+v, want %+v", cfg.Identity, id)
+	}
+	if cfg.Routing.Type != "dhtclient" {
+		t.Errorf("Routing section lost: %+v", cfg.Routing)
+	}
+}
+
+func TestSaveSectionKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	orig := `{"Routing": {"Type": "dhtclient"}, "Identity": {}, "Bootstrap": []}`
+	if err := os.WriteFile(path, []byte(orig), 0640); err != nil {
+		t.Fatal(err)
+	}
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	id, _ := NewIdentity(priv)
+	if err := SaveIdentity(path, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveSection(path, "Swarm", map[string]bool{"DisableNatPortMap": true}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections, err := readSections(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, sec := range sections {
+		names = append(names, sec.name)
+	}
+	if got := strings.Join(names, ","); got != "Routing,Identity,Bootstrap,Swarm" {
+		t.Errorf("sections in order %s", got)
+	}
+	if cfg, err := LoadConfig(path); err != nil || cfg.Identity != id {
+		t.Errorf("identity not saved: %+v, %v", cfg, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("file mode changed: %v, %v", fi.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestImportIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	priv, _ := GenerateKey(crypto.Ed25519, 0)
+	id, _ := NewIdentity(priv)
+	if err := ImportIdentity(path, id, false); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := GenerateKey(crypto.Ed25519, 0)
+	otherID, _ := NewIdentity(other)
+	if err := ImportIdentity(path, otherID, false); !errors.Is(err, ErrIdentityExists) {
+		t.Errorf("expected %v, got %v", ErrIdentityExists, err)
+	}
+	if cfg, err := LoadConfig(path); err != nil || cfg.Identity != id {
+		t.Errorf("identity replaced without force: %+v, %v", cfg, err)
+	}
+
+	if err := ImportIdentity(path, otherID, true); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := LoadConfig(path); err != nil || cfg.Identity != otherID {
+		t.Errorf("identity not replaced with force: %+v, %v", cfg, err)
+	}
+}
+
+func TestReplaceBootstrapPeer(t *testing.T) {
+	oldKey, _ := GenerateKey(crypto.Ed25519, 0)
+	newKey, _ := GenerateKey(crypto.Ed25519, 0)
+	oldID, _ := peer.IDFromPrivateKey(oldKey)
+	newID, _ := peer.IDFromPrivateKey(newKey)
+
+	addrs := []string{
+		"/ip4/1.2.3.4/tcp/7001/p2p/" + oldID.String(),
+		"/ip4/5.6.7.8/tcp/7001/p2p/12D3KooWSpgWzEXE5GNjY6hgdAhuuBLe4d3ocqWDnVLdCa8U3cig",
+	}
+	got, changed := ReplaceBootstrapPeer(addrs, oldID, newID)
+	if !changed || got[0] != "/ip4/1.2.3.4/tcp/7001/p2p/"+newID.String() || got[1] != addrs[1] {
+		t.Errorf("got %v, %v", got, changed)
+	}
+	if _, changed := ReplaceBootstrapPeer(got, oldID, newID); changed {
+		t.Error("replaced an address twice")
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// EnvSwarmKey names the swarm.key file to use when neither -psk nor
+// -swarm-key is given.
+const EnvSwarmKey = "LIBP2P_SWARM_KEY"
+
+// swarm.key encodings.
+const (
+	SwarmKeyBase16 = "base16"
+	SwarmKeyBase64 = "base64"
+)
+
+const swarmKeyHeader = "/key/swarm/psk/1.0.0/\n"
+
+// GenerateSwarmKey generates a random 256 bit pre-shared key.
+func GenerateSwarmKey() (pnet.PSK, error) {
+	psk := make([]byte, 32)
+	if _, err := rand.Read(psk); err != nil {
+		return nil, err
+	}
+	return psk, nil
+}
+
+// EncodeSwarmKey encodes psk in the swarm.key format used by Kubo, with the
+// base16 or base64 encoding.
+func EncodeSwarmKey(psk pnet.PSK, encoding string) ([]byte, error) {
+	if len(psk) != 32 {
+		return nil, fmt.Errorf("pre-shared key must be 32 bytes, got %d", len(psk))
+	}
+	var body string
+	switch encoding {
+	case SwarmKeyBase16, "":
+		encoding, body = SwarmKeyBase16, hex.EncodeToString(psk)
+	case SwarmKeyBase64:
+		body = base64.StdEncoding.EncodeToString(psk)
+	default:
+		return nil, fmt.Errorf("unknown swarm key encoding %q", encoding)
+	}
+	return []byte(swarmKeyHeader + "/" + encoding + "/\n" + body + "\n"), nil
+}
+
+// DecodeSwarmKey decodes a swarm.key file.
+func DecodeSwarmKey(data []byte) (pnet.PSK, error) {
+	return pnet.DecodeV1PSK(bytes.NewReader(data))
+}
+
+// ReadSwarmKey reads the swarm.key file at path.
+func ReadSwarmKey(path string) (pnet.PSK, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	psk, err := DecodeSwarmKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return psk, nil
+}
+
+// WriteSwarmKey writes psk to path in the swarm.key format.
+func WriteSwarmKey(path string, psk pnet.PSK, encoding string) error {
+	data, err := EncodeSwarmKey(psk, encoding)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// LoadPSK returns the pre-shared key given by the hex encoded -psk flag, the
+// -swarm-key file or the file named by LIBP2P_SWARM_KEY, in that order, nil
+// when none is set. It fails when LIBP2P_FORCE_PNET=1 and there is no key,
+// as libp2p would.
+func LoadPSK(hexPSK, swarmKeyPath string) (pnet.PSK, error) {
+	if hexPSK != "" && swarmKeyPath != "" {
+		return nil, errors.New("-psk and -swarm-key are mutually exclusive")
+	}
+	if hexPSK != "" {
+		psk, err := hex.DecodeString(hexPSK)
+		if err != nil {
+			return nil, fmt.Errorf("decoding PSK: %w", err)
+		}
+		return psk, nil
+	}
+	if swarmKeyPath == "" {
+		swarmKeyPath = os.Getenv(EnvSwarmKey)
+	}
+	if swarmKeyPath != "" {
+		return ReadSwarmKey(swarmKeyPath)
+	}
+	if pnet.ForcePrivateNetwork {
+		return nil, fmt.Errorf("%s=1 but no pre-shared key, use -swarm-key or %s", pnet.EnvKey, EnvSwarmKey)
+	}
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+func TestSwarmKey(t *testing.T) {
+	psk, err := GenerateSwarmKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, encoding := range []string{SwarmKeyBase16, SwarmKeyBase64} {
+		data, err := EncodeSwarmKey(psk, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeSwarmKey(data)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if !bytes.Equal(got, psk) {
+			t.Errorf("%s: decoded key differs", encoding)
+		}
+	}
+
+	// As written by ipfs-swarm-key-gen.
+	kubo := "/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(psk) + "\n"
+	if got, err := DecodeSwarmKey([]byte(kubo)); err != nil || !bytes.Equal(got, psk) {
+		t.Errorf("Kubo swarm.key: %v", err)
+	}
+}
+
+func TestLoadPSK(t *testing.T) {
+	psk, _ := GenerateSwarmKey()
+	path := filepath.Join(t.TempDir(), "swarm.key")
+	if err := WriteSwarmKey(path, psk, SwarmKeyBase64); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvSwarmKey, "")
+
+	if got, err := LoadPSK("", ""); err != nil || got != nil {
+		t.Errorf("no key: got %v, %v", got, err)
+	}
+	if got, err := LoadPSK(hex.EncodeToString(psk), ""); err != nil || !bytes.Equal(got, psk) {
+		t.Errorf("hex: %v", err)
+	}
+	if got, err := LoadPSK("", path); err != nil || !bytes.Equal(got, psk) {
+		t.Errorf("swarm key: %v", err)
+	}
+	if _, err := LoadPSK(hex.EncodeToString(psk), path); err == nil {
+		t.Error("accepted both -psk and -swarm-key")
+	}
+
+	t.Setenv(EnvSwarmKey, path)
+	if got, err := LoadPSK("", ""); err != nil || !bytes.Equal(got, psk) {
+		t.Errorf("env: %v", err)
+	}
+
+	t.Setenv(EnvSwarmKey, "")
+	pnet.ForcePrivateNetwork = true
+	defer func() { pnet.ForcePrivateNetwork = false }()
+	if _, err := LoadPSK("", ""); err == nil {
+		t.Error("forced private network without a key")
+	}
+}
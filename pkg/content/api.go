@@ -0,0 +1,152 @@
+package content
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// AddResult is returned by the add command.
+type AddResult struct {
+	Hash string
+	Size int64
+}
+
+// ProvideResult is returned by the dht/provide and dht/reprovide commands.
+type ProvideResult struct {
+	ID       string `json:",omitempty"`
+	Provided int
+}
+
+// ProvidersResult is returned by the dht/findprovs command.
+type ProvidersResult struct {
+	Providers []peer.AddrInfo
+}
+
+// PinsResult is returned by the pin commands.
+type PinsResult struct {
+	Pins []string
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func cidArg(r *http.Request) (cid.Cid, error) {
+	c, err := cid.Decode(r.URL.Query().Get("arg"))
+	if err != nil {
+		return cid.Undef, api.ArgError("invalid CID: %v", err)
+	}
+	return c, nil
+}
+
+// RegisterAPI adds the content commands to srv: add stores the request body,
+// cat streams the file named by arg, the dht commands announce and look up
+// provider records and the pin commands manage what the pinned and roots
+// strategies announce.
+func (s *Store) RegisterAPI(srv *api.Server) {
+	srv.HandleFunc("add", func(r *http.Request) (interface{}, error) {
+		body := &countingReader{r: r.Body}
+		c, err := s.Add(r.Context(), body)
+		if err != nil {
+			return nil, err
+		}
+		return AddResult{Hash: c.String(), Size: body.n}, nil
+	})
+	srv.Handle("cat", func(w http.ResponseWriter, r *http.Request) {
+		c, err := cidArg(r)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		dr, err := s.Get(r.Context(), c)
+		if err != nil {
+			api.WriteError(w, err)
+			return
+		}
+		defer dr.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if _, err := io.Copy(w, dr); err != nil {
+			logger.Warnf("cat %s: %v", c, err)
+		}
+	})
+
+	srv.HandleFunc("dht/provide", func(r *http.Request) (interface{}, error) {
+		c, err := cidArg(r)
+		if err != nil {
+			return nil, err
+		}
+		n, err := s.Provide(r.Context(), c, r.URL.Query().Get("recursive") == "true")
+		if err != nil {
+			return nil, err
+		}
+		return ProvideResult{ID: c.String(), Provided: n}, nil
+	})
+	srv.HandleFunc("dht/reprovide", func(r *http.Request) (interface{}, error) {
+		n, err := s.Reprovide(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		return ProvideResult{Provided: n}, nil
+	})
+	srv.HandleFunc("dht/findprovs", func(r *http.Request) (interface{}, error) {
+		c, err := cidArg(r)
+		if err != nil {
+			return nil, err
+		}
+		max := 20
+		if n := r.URL.Query().Get("num-providers"); n != "" {
+			if max, err = strconv.Atoi(n); err != nil || max < 1 {
+				return nil, api.ArgError("invalid num-providers %q", n)
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+		defer cancel()
+		return ProvidersResult{Providers: s.FindProviders(ctx, c, max)}, nil
+	})
+	srv.HandleFunc("pin/add", func(r *http.Request) (interface{}, error) {
+		c, err := cidArg(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Pin(r.Context(), c); err != nil {
+			return nil, err
+		}
+		return PinsResult{Pins: []string{c.String()}}, nil
+	})
+	srv.HandleFunc("pin/rm", func(r *http.Request) (interface{}, error) {
+		c, err := cidArg(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Unpin(r.Context(), c); err != nil {
+			return nil, err
+		}
+		return PinsResult{Pins: []string{c.String()}}, nil
+	})
+	srv.HandleFunc("pin/ls", func(r *http.Request) (interface{}, error) {
+		pins, err := s.Pins(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		out := PinsResult{Pins: make([]string, len(pins))}
+		for i, c := range pins {
+			out.Pins[i] = c.String()
+		}
+		return out, nil
+	})
+}
@@ -0,0 +1,171 @@
+// Package content stores content addressed blocks and exchanges them with
+// the other peers of the network over Bitswap.
+//
+// Files are chunked into a UnixFS DAG and pinned when added. Blocks are
+// looked up in the local blockstore first; missing blocks are fetched from
+// the peers that provide them in the DHT. Which of our blocks we announce as
+// provider is chosen by the reprovider Strategy.
+package content
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/bitswap"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/boxo/blockservice"
+	"github.com/ipfs/boxo/blockstore"
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/exchange/offline"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs/importer"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+var logger = log.Logger("content")
+
+// ErrClosed is returned by Add after the Store is closed.
+var ErrClosed = errors.New("content store closed")
+
+// Store is a blockstore with Bitswap on top of a libp2p host.
+type Store struct {
+	Blockstore blockstore.Blockstore
+	Bitswap    *bitswap.Bitswap
+	Blocks     blockservice.BlockService
+	DAG        ipld.DAGService
+
+	ds       datastore.Batching
+	router   routing.ContentRouting
+	offline  ipld.DAGService
+	strategy Strategy
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// mu guards closed, so that no goroutine is added to wg once Close
+	// waits for it.
+	mu     sync.Mutex
+	closed bool
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithStrategy selects which blocks are announced, StrategyAll by default.
+func WithStrategy(strategy Strategy) Option {
+	return func(s *Store) {
+		s.strategy = strategy
+	}
+}
+
+// WithReprovideInterval sets how often the provider records are renewed,
+// DefaultReprovideInterval by default. Zero disables reproviding.
+func WithReprovideInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.interval = interval
+	}
+}
+
+// New creates the blockstore in ds and starts Bitswap on h. Bitswap looks
+// for providers through r, our own blocks are announced to r according to
+// the reprovider strategy.
+func New(ctx context.Context, h host.Host, ds datastore.Batching, r routing.ContentRouting, opts ...Option) *Store {
+	bstore := blockstore.NewBlockstore(ds)
+	// Bitswap would announce every block it stores, the reprovider
+	// takes care of that instead.
+	bs := bitswap.New(ctx, bsnet.NewFromIpfsHost(h, r), bstore, bitswap.ProvideEnabled(false))
+	blocks := blockservice.New(bstore, bs)
+	s := &Store{
+		Blockstore: bstore,
+		Bitswap:    bs,
+		Blocks:     blocks,
+		DAG:        merkledag.NewDAGService(blocks),
+		ds:         ds,
+		router:     r,
+		offline:    merkledag.NewDAGService(blockservice.New(bstore, offline.Exchange(bstore))),
+		strategy:   StrategyAll,
+		interval:   DefaultReprovideInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.interval > 0 {
+		s.wg.Add(1)
+		go s.reprovideLoop()
+	}
+	return s
+}
+
+// Add chunks r into a UnixFS file DAG, stores its blocks and returns the
+// root CID.
+func (s *Store) Add(ctx context.Context, r io.Reader) (cid.Cid, error) {
+	nd, err := importer.BuildDagFromReader(s.DAG, chunker.DefaultSplitter(r))
+	if err != nil {
+		return cid.Undef, err
+	}
+	if err := s.Pin(ctx, nd.Cid()); err != nil {
+		return cid.Undef, err
+	}
+	logger.Infof("Added %s", nd.Cid())
+
+	// Announcing a large DAG takes a while, don't make the caller wait.
+	recursive := s.strategy != StrategyRoots
+	if !s.track() {
+		return cid.Undef, ErrClosed
+	}
+	go func() {
+		defer s.wg.Done()
+		n, err := s.Provide(s.ctx, nd.Cid(), recursive)
+		if err != nil {
+			logger.Warnf("Provide %s: %v", nd.Cid(), err)
+			return
+		}
+		logger.Debugf("Provided %d blocks of %s", n, nd.Cid())
+	}()
+	return nd.Cid(), nil
+}
+
+// Get returns a reader over the UnixFS file with root c, fetching missing
+// blocks from the network as they are read.
+func (s *Store) Get(ctx context.Context, c cid.Cid) (uio.DagReader, error) {
+	ng := merkledag.NewSession(ctx, s.DAG)
+	nd, err := ng.Get(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return uio.NewDagReader(ctx, nd, ng)
+}
+
+// track adds a goroutine to wait for on Close, it returns false once the
+// Store is closed.
+func (s *Store) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// Close stops the reprovider and Bitswap, and waits for the announcements
+// started by Add.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+	return s.Blocks.Close()
+}
@@ -0,0 +1,158 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestFetchFromPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	var stores []*Store
+	for _, h := range hosts {
+		s := New(ctx, h, dssync.MutexWrap(datastore.NewMapDatastore()), routinghelpers.Null{})
+		defer s.Close()
+		stores = append(stores, s)
+	}
+
+	// Bitswap only learns about connections made after it started.
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 1<<20+123)
+	rand.Read(data)
+	c, err := stores[0].Add(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := stores[1].Blockstore.Has(ctx, c); has {
+		t.Fatal("second node should not have the root block yet")
+	}
+
+	r, err := stores[1].Get(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("fetched content differs from the added file")
+	}
+}
+
+// countingRouter accepts every provider record.
+type countingRouter struct {
+	routinghelpers.Null
+}
+
+func (countingRouter) Provide(context.Context, cid.Cid, bool) error {
+	return nil
+}
+
+func TestReprovideStrategies(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.WithNPeers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	// The stores of every strategy share the blocks and the pins of ds.
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	open := func(strategy Strategy) *Store {
+		return New(ctx, mn.Hosts()[0], ds, countingRouter{},
+			WithReprovideInterval(0), WithStrategy(strategy))
+	}
+	reprovide := func(strategy Strategy) int {
+		t.Helper()
+		s := open(strategy)
+		defer s.Close()
+		n, err := s.Reprovide(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	s := open(StrategyAll)
+	// 1 MiB is split into four 256 KiB leaves under one root.
+	data := make([]byte, 1<<20)
+	rand.Read(data)
+	root, err := s.Add(ctx, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Blockstore.Put(ctx, blocks.NewBlock([]byte("not pinned"))); err != nil {
+		t.Fatal(err)
+	}
+
+	pins, err := s.Pins(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 1 || !pins[0].Equals(root) {
+		t.Fatalf("expected %s to be pinned, got %v", root, pins)
+	}
+	s.Close()
+	if _, err := s.Add(ctx, bytes.NewReader(data)); err != ErrClosed {
+		t.Errorf("Add after Close returned %v, want ErrClosed", err)
+	}
+
+	for _, tc := range []struct {
+		strategy Strategy
+		want     int
+	}{
+		{StrategyAll, 6},
+		{StrategyPinned, 5},
+		{StrategyRoots, 1},
+	} {
+		if n := reprovide(tc.strategy); n != tc.want {
+			t.Errorf("strategy %s provided %d blocks, want %d", tc.strategy, n, tc.want)
+		}
+	}
+
+	s = open(StrategyRoots)
+	defer s.Close()
+	if err := s.Unpin(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Reprovide(ctx); n != 0 {
+		t.Errorf("unpinned root still provided")
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for in, want := range map[string]Strategy{"": StrategyAll, "all": StrategyAll, "pinned": StrategyPinned, "roots": StrategyRoots} {
+		got, err := ParseStrategy(in)
+		if err != nil || got != want {
+			t.Errorf("ParseStrategy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseStrategy("flat"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,53 @@
+package content
+
+import (
+	"context"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+// pinPrefix is where the pinned roots are recorded in the datastore.
+var pinPrefix = datastore.NewKey("/local/pins")
+
+func pinKey(c cid.Cid) datastore.Key {
+	return pinPrefix.ChildString(c.String())
+}
+
+// Pin fetches the whole DAG at c and records it as pinned.
+func (s *Store) Pin(ctx context.Context, c cid.Cid) error {
+	if err := merkledag.FetchGraph(ctx, c, s.DAG); err != nil {
+		return err
+	}
+	return s.ds.Put(ctx, pinKey(c), nil)
+}
+
+// Unpin forgets the pin on c. The blocks stay in the blockstore.
+func (s *Store) Unpin(ctx context.Context, c cid.Cid) error {
+	return s.ds.Delete(ctx, pinKey(c))
+}
+
+// Pins lists the pinned roots.
+func (s *Store) Pins(ctx context.Context) ([]cid.Cid, error) {
+	res, err := s.ds.Query(ctx, query.Query{Prefix: pinPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var pins []cid.Cid
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		c, err := cid.Decode(datastore.RawKey(r.Key).BaseNamespace())
+		if err != nil {
+			logger.Warnf("Bad pin %s: %v", r.Key, err)
+			continue
+		}
+		pins = append(pins, c)
+	}
+	return pins, nil
+}
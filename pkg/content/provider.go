@@ -0,0 +1,151 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Strategy selects the blocks the reprovider announces, like Kubo's
+// Reprovider.Strategy.
+type Strategy string
+
+const (
+	// StrategyAll announces every block in the blockstore.
+	StrategyAll Strategy = "all"
+	// StrategyPinned announces every block of the pinned DAGs.
+	StrategyPinned Strategy = "pinned"
+	// StrategyRoots only announces the roots of the pinned DAGs.
+	StrategyRoots Strategy = "roots"
+)
+
+// DefaultReprovideInterval is Kubo's default Reprovider.Interval.
+const DefaultReprovideInterval = 12 * time.Hour
+
+// reprovideDelay is how long after startup the first reprovide runs.
+const reprovideDelay = time.Minute
+
+// ParseStrategy parses a Reprovider.Strategy value, empty meaning
+// StrategyAll.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", StrategyAll:
+		return StrategyAll, nil
+	case StrategyPinned, StrategyRoots:
+		return Strategy(s), nil
+	default:
+		return "", fmt.Errorf("unknown reprovider strategy %q, expected all, pinned or roots", s)
+	}
+}
+
+// Provide announces that we hold c, and with recursive every block linked
+// from it that is available locally. It returns the number of records
+// published.
+func (s *Store) Provide(ctx context.Context, c cid.Cid, recursive bool) (int, error) {
+	if !recursive {
+		if err := s.router.Provide(ctx, c, true); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	keys := cid.NewSet()
+	if err := s.walk(ctx, c, keys); err != nil {
+		return 0, err
+	}
+	return s.provideKeys(ctx, keys)
+}
+
+// walk adds every locally available block of the DAG at root to keys.
+func (s *Store) walk(ctx context.Context, root cid.Cid, keys *cid.Set) error {
+	return merkledag.Walk(ctx, merkledag.GetLinksDirect(s.offline), root, keys.Visit, merkledag.IgnoreErrors())
+}
+
+func (s *Store) provideKeys(ctx context.Context, keys *cid.Set) (int, error) {
+	provided := 0
+	err := keys.ForEach(func(c cid.Cid) error {
+		if err := s.router.Provide(ctx, c, true); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logger.Warnf("Provide %s: %v", c, err)
+			return nil
+		}
+		provided++
+		return nil
+	})
+	return provided, err
+}
+
+// keysToProvide lists the blocks announced by the strategy.
+func (s *Store) keysToProvide(ctx context.Context) (*cid.Set, error) {
+	keys := cid.NewSet()
+	if s.strategy == StrategyAll {
+		ch, err := s.Blockstore.AllKeysChan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for c := range ch {
+			keys.Add(c)
+		}
+		return keys, ctx.Err()
+	}
+
+	pins, err := s.Pins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range pins {
+		if s.strategy == StrategyRoots {
+			keys.Add(root)
+			continue
+		}
+		if err := s.walk(ctx, root, keys); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Reprovide announces every block selected by the strategy once and returns
+// the number of records published.
+func (s *Store) Reprovide(ctx context.Context) (int, error) {
+	keys, err := s.keysToProvide(ctx)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	n, err := s.provideKeys(ctx, keys)
+	logger.Infof("Reprovided %d of %d blocks (strategy %s) in %s", n, keys.Len(), s.strategy, time.Since(start))
+	return n, err
+}
+
+func (s *Store) reprovideLoop() {
+	defer s.wg.Done()
+	timer := time.NewTimer(reprovideDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			return
+		}
+		if _, err := s.Reprovide(s.ctx); err != nil && s.ctx.Err() == nil {
+			logger.Warnf("Reprovide: %v", err)
+		}
+		timer.Reset(s.interval)
+	}
+}
+
+// FindProviders looks up at most max peers providing c.
+func (s *Store) FindProviders(ctx context.Context, c cid.Cid, max int) []peer.AddrInfo {
+	var providers []peer.AddrInfo
+	for p := range s.router.FindProvidersAsync(ctx, c, max) {
+		providers = append(providers, p)
+	}
+	return providers
+}
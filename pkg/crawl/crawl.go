@@ -0,0 +1,190 @@
+// Package crawl maps the peers of a DHT by walking their routing tables.
+//
+// Starting from the bootstrap peers, every peer is asked for the closest
+// peers of random keys (FIND_NODE) until its whole routing table has been
+// listed, and every new peer found that way is crawled in turn. For each peer
+// the crawl records whether it answered, its addresses and what identify
+// told us about it.
+package crawl
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var logger = log.Logger("crawl")
+
+// Peer is what the crawl learned about one peer.
+type Peer struct {
+	ID           peer.ID
+	Reachable    bool
+	Error        string `json:",omitempty"`
+	AgentVersion string `json:",omitempty"`
+	Protocols    []protocol.ID
+	Addrs        []multiaddr.Multiaddr
+	// Neighbors is the number of peers in its routing table.
+	Neighbors int
+}
+
+// Result is the outcome of a crawl.
+type Result struct {
+	Started  time.Time
+	Duration time.Duration
+	Peers    []Peer
+}
+
+// Summary counts the crawled peers.
+type Summary struct {
+	Total       int
+	Reachable   int
+	Unreachable int
+	// Agents counts the reachable peers by agent version.
+	Agents map[string]int
+}
+
+type options struct {
+	protocolPrefix protocol.ID
+	parallelism    int
+	timeout        time.Duration
+}
+
+// Option configures a crawl.
+type Option func(*options)
+
+// WithProtocolPrefix crawls the DHT running under prefix, as set by the
+// -protocol flag of the nodes. The default is the public /ipfs DHT.
+func WithProtocolPrefix(prefix string) Option {
+	return func(o *options) {
+		if prefix != "" {
+			o.protocolPrefix = protocol.ID(prefix)
+		}
+	}
+}
+
+// WithParallelism sets how many peers are crawled at once, 100 by default.
+func WithParallelism(n int) Option {
+	return func(o *options) {
+		o.parallelism = n
+	}
+}
+
+// WithTimeout sets the connect and message timeout, 5s by default.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// Crawl walks the DHT from the bootstrap peers using h, which must be able
+// to connect to the network (same PSK).
+func Crawl(ctx context.Context, h host.Host, bootstrap []peer.AddrInfo, opts ...Option) (*Result, error) {
+	o := options{protocolPrefix: "/ipfs", parallelism: 100, timeout: 5 * time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c, err := crawler.NewDefaultCrawler(h,
+		crawler.WithProtocols([]protocol.ID{o.protocolPrefix + "/kad/1.0.0"}),
+		crawler.WithParallelism(o.parallelism),
+		crawler.WithConnectTimeout(o.timeout),
+		crawler.WithMsgTimeout(o.timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	start := make([]*peer.AddrInfo, len(bootstrap))
+	for i := range bootstrap {
+		start[i] = &bootstrap[i]
+	}
+	res := &Result{Started: time.Now()}
+	// Both callbacks are called from the crawler's main loop, one at a time.
+	c.Run(ctx, start, func(p peer.ID, rtPeers []*peer.AddrInfo) {
+		logger.Debugf("%s has %d peers", p, len(rtPeers))
+		res.Peers = append(res.Peers, describe(h, p, true, len(rtPeers), nil))
+	}, func(p peer.ID, err error) {
+		logger.Debugf("%s failed: %v", p, err)
+		res.Peers = append(res.Peers, describe(h, p, false, 0, err))
+	})
+	res.Duration = time.Since(res.Started)
+	sort.Slice(res.Peers, func(i, j int) bool { return res.Peers[i].ID < res.Peers[j].ID })
+	return res, ctx.Err()
+}
+
+func describe(h host.Host, p peer.ID, reachable bool, neighbors int, err error) Peer {
+	info := Peer{ID: p, Reachable: reachable, Neighbors: neighbors, Addrs: h.Peerstore().Addrs(p)}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	if v, err := h.Peerstore().Get(p, "AgentVersion"); err == nil {
+		info.AgentVersion, _ = v.(string)
+	}
+	if protos, err := h.Peerstore().GetProtocols(p); err == nil {
+		sort.Slice(protos, func(i, j int) bool { return protos[i] < protos[j] })
+		info.Protocols = protos
+	}
+	return info
+}
+
+// Summary counts the reachable and unreachable peers.
+func (r *Result) Summary() Summary {
+	s := Summary{Total: len(r.Peers), Agents: make(map[string]int)}
+	for _, p := range r.Peers {
+		if !p.Reachable {
+			s.Unreachable++
+			continue
+		}
+		s.Reachable++
+		agent := p.AgentVersion
+		if agent == "" {
+			agent = "unknown"
+		}
+		s.Agents[agent]++
+	}
+	return s
+}
+
+// WriteJSON writes the result as indented JSON.
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one line per peer, lists are separated by spaces.
+func (r *Result) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"peer_id", "reachable", "agent_version", "neighbors", "protocols", "addrs", "error"})
+	for _, p := range r.Peers {
+		protos := make([]string, len(p.Protocols))
+		for i, proto := range p.Protocols {
+			protos[i] = string(proto)
+		}
+		addrs := make([]string, len(p.Addrs))
+		for i, addr := range p.Addrs {
+			addrs[i] = addr.String()
+		}
+		cw.Write([]string{
+			p.ID.String(),
+			strconv.FormatBool(p.Reachable),
+			p.AgentVersion,
+			strconv.Itoa(p.Neighbors),
+			strings.Join(protos, " "),
+			strings.Join(addrs, " "),
+			p.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,92 @@
+package crawl
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestCrawl(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const n = 6
+	mn, err := mocknet.FullMeshLinked(n + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	crawlerHost, nodes := hosts[0], hosts[1:]
+
+	dhts := make([]*dht.IpfsDHT, n)
+	for i, h := range nodes {
+		d, err := dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		dhts[i] = d
+	}
+	// A chain, so that the crawler can only learn about most peers from
+	// the routing tables of others.
+	for i := 0; i < n-1; i++ {
+		if _, err := mn.ConnectPeers(nodes[i].ID(), nodes[i+1].ID()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, d := range dhts {
+		want := 2
+		if i == 0 || i == n-1 {
+			want = 1
+		}
+		for d.RoutingTable().Size() < want {
+			if ctx.Err() != nil {
+				t.Fatalf("routing table of node %d not filled", i)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// The last node is out of reach of the crawler.
+	unreachable := nodes[n-1].ID()
+	if err := mn.UnlinkPeers(crawlerHost.ID(), unreachable); err != nil {
+		t.Fatal(err)
+	}
+
+	start := peer.AddrInfo{ID: nodes[0].ID(), Addrs: nodes[0].Addrs()}
+	res, err := Crawl(ctx, crawlerHost, []peer.AddrInfo{start}, WithProtocolPrefix("/test"), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := res.Summary()
+	if s.Total != n || s.Reachable != n-1 || s.Unreachable != 1 {
+		t.Fatalf("unexpected summary %+v", s)
+	}
+	for _, p := range res.Peers {
+		if p.Reachable == (p.ID == unreachable) {
+			t.Errorf("peer %s reachable: %v", p.ID, p.Reachable)
+		}
+		if p.Reachable && len(p.Protocols) == 0 {
+			t.Errorf("no protocols recorded for %s", p.ID)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := res.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != n+1 {
+		t.Fatalf("CSV has %d rows, want %d", len(rows), n+1)
+	}
+}
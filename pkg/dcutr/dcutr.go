@@ -0,0 +1,317 @@
+// Package dcutr keeps statistics of the DCUtR hole punches of a host: how
+// many are attempted, how many end in a direct connection, by transport and
+// failure reason, how long relayed peers wait for a direct connection and
+// which peers are still only reachable through a relay.
+package dcutr
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var logger = log.Logger("dcutr")
+
+// Counts are the outcomes of finished hole punches.
+type Counts struct {
+	Successes int
+	Failures  int
+}
+
+// SuccessRate is the share of successes, 0 without hole punch.
+func (c Counts) SuccessRate() float64 {
+	if c.Successes+c.Failures == 0 {
+		return 0
+	}
+	return float64(c.Successes) / float64(c.Successes+c.Failures)
+}
+
+// Durations summarizes the time it took relayed peers to get a direct
+// connection.
+type Durations struct {
+	Count int
+	Min   time.Duration
+	Avg   time.Duration
+	Max   time.Duration
+	total time.Duration
+}
+
+func (d *Durations) add(v time.Duration) {
+	if d.Count == 0 || v < d.Min {
+		d.Min = v
+	}
+	if v > d.Max {
+		d.Max = v
+	}
+	d.Count++
+	d.total += v
+	d.Avg = d.total / time.Duration(d.Count)
+}
+
+// RelayedPeer is a peer connected through relays only.
+type RelayedPeer struct {
+	Peer  peer.ID
+	Relay peer.ID
+	Since time.Time
+}
+
+// Stats is returned by the stats/holepunch command.
+type Stats struct {
+	// Attempts counts the hole punch attempts, an initiator tries up to
+	// three times before giving up.
+	Attempts int
+	Counts
+	// DirectDials counts the direct dials tried before hole punching a peer
+	// with a public address, DirectDialSuccesses those that made hole
+	// punching unnecessary.
+	DirectDials         int
+	DirectDialSuccesses int
+	// ProtocolErrors counts the hole punches that failed before punching,
+	// while exchanging addresses.
+	ProtocolErrors int
+	// BySide splits the outcomes between initiator and receiver.
+	BySide map[string]Counts
+	// ByTransport splits the outcomes by the transport of the direct
+	// connection, or of the addresses tried on failure.
+	ByTransport map[string]Counts
+	// FailureReasons counts the failed attempts and protocol errors by
+	// reason.
+	FailureReasons map[string]int
+	// TimeToDirect goes from the first relayed connection to a peer to the
+	// first direct one, whether or not it came from a hole punch.
+	TimeToDirect Durations
+	// RelayedPeers are the peers connected through relays only, their
+	// traffic goes through the relay.
+	RelayedPeers []RelayedPeer
+}
+
+// Tracker collects the statistics of a host. Pass Option to libp2p.New and
+// call Start with the host.
+type Tracker struct {
+	mu    sync.Mutex
+	stats Stats
+	// relayedSince is when the peers connected through relays only got
+	// their first relayed connection.
+	relayedSince map[peer.ID]time.Time
+	host         host.Host
+}
+
+// New returns an empty Tracker.
+func New() *Tracker {
+	return &Tracker{
+		stats: Stats{
+			BySide:         make(map[string]Counts),
+			ByTransport:    make(map[string]Counts),
+			FailureReasons: make(map[string]int),
+		},
+		relayedSince: make(map[peer.ID]time.Time),
+	}
+}
+
+// Option enables hole punching traced by t.
+func (t *Tracker) Option() libp2p.Option {
+	return libp2p.EnableHolePunching(holepunch.WithMetricsAndEventTracer(t, t))
+}
+
+// Start follows the connections of h, to time how long peers stay relayed.
+func (t *Tracker) Start(h host.Host) {
+	t.host = h
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF:    func(_ network.Network, c network.Conn) { t.connected(c) },
+		DisconnectedF: func(n network.Network, c network.Conn) { t.disconnected(n, c.RemotePeer()) },
+	})
+	// Peers connected before Start.
+	for _, c := range h.Network().Conns() {
+		t.connected(c)
+	}
+}
+
+func (t *Tracker) connected(c network.Conn) {
+	p := c.RemotePeer()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since, relayed := t.relayedSince[p]
+	if netutil.IsRelayed(c.RemoteMultiaddr()) {
+		if !relayed && !t.hasDirectConn(p, c) {
+			t.relayedSince[p] = time.Now()
+		}
+		return
+	}
+	if relayed {
+		t.stats.TimeToDirect.add(time.Since(since))
+		delete(t.relayedSince, p)
+	}
+}
+
+// hasDirectConn reports whether there is a direct connection to p other than
+// c.
+func (t *Tracker) hasDirectConn(p peer.ID, c network.Conn) bool {
+	for _, conn := range t.host.Network().ConnsToPeer(p) {
+		if conn != c && !netutil.IsRelayed(conn.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracker) disconnected(n network.Network, p peer.ID) {
+	if n.Connectedness(p) == network.Connected {
+		return
+	}
+	t.mu.Lock()
+	delete(t.relayedSince, p)
+	t.mu.Unlock()
+}
+
+// Trace implements holepunch.EventTracer.
+func (t *Tracker) Trace(evt *holepunch.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e := evt.Evt.(type) {
+	case *holepunch.HolePunchAttemptEvt:
+		t.stats.Attempts++
+	case *holepunch.EndHolePunchEvt:
+		if !e.Success {
+			t.stats.FailureReasons[Reason(e.Error)]++
+			logger.Debugf("Hole punch attempt with %s failed: %s", evt.Remote, e.Error)
+		}
+	case *holepunch.ProtocolErrorEvt:
+		// The hole punch ends without HolePunchFinished.
+		t.stats.ProtocolErrors++
+		t.stats.Failures++
+		t.stats.FailureReasons[Reason(e.Error)]++
+		logger.Debugf("Hole punch with %s failed: %s", evt.Remote, e.Error)
+	}
+}
+
+// HolePunchFinished implements holepunch.MetricsTracer.
+func (t *Tracker) HolePunchFinished(side string, attempts int, theirAddrs []multiaddr.Multiaddr, ourAddrs []multiaddr.Multiaddr, directConn network.ConnMultiaddrs) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bySide := t.stats.BySide[side]
+	if directConn != nil {
+		tr := netutil.Transport(directConn.RemoteMultiaddr())
+		t.stats.Successes++
+		bySide.Successes++
+		byTransport := t.stats.ByTransport[tr]
+		byTransport.Successes++
+		t.stats.ByTransport[tr] = byTransport
+		t.stats.BySide[side] = bySide
+		logger.Infof("Hole punch as %s succeeded over %s after %d attempt(s)", side, tr, attempts)
+		return
+	}
+
+	t.stats.Failures++
+	bySide.Failures++
+	t.stats.BySide[side] = bySide
+	tried := make(map[string]bool)
+	for _, a := range theirAddrs {
+		tried[netutil.Transport(a)] = true
+	}
+	if len(tried) == 0 {
+		// Nothing to punch through, e.g. no public address.
+		tried["none"] = true
+	}
+	for tr := range tried {
+		byTransport := t.stats.ByTransport[tr]
+		byTransport.Failures++
+		t.stats.ByTransport[tr] = byTransport
+	}
+	logger.Infof("Hole punch as %s failed after %d attempt(s)", side, attempts)
+}
+
+// DirectDialFinished implements holepunch.MetricsTracer.
+func (t *Tracker) DirectDialFinished(success bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.DirectDials++
+	if success {
+		t.stats.DirectDialSuccesses++
+	}
+}
+
+// Reason reduces a hole punch error to a short reason to count failures by.
+func Reason(err string) string {
+	lower := strings.ToLower(err)
+	for _, r := range []struct{ match, reason string }{
+		{"deadline exceeded", "timeout"},
+		{"timeout", "timeout"},
+		{"timed out", "timeout"},
+		{"connection refused", "connection refused"},
+		{"stream reset", "stream reset"},
+		{"protocols not supported", "protocol not supported"},
+		{"protocol not supported", "protocol not supported"},
+		{"no addresses", "no addresses"},
+		{"no good addresses", "no addresses"},
+		{"context canceled", "canceled"},
+	} {
+		if strings.Contains(lower, r.match) {
+			return r.reason
+		}
+	}
+	if err == "" {
+		return "unknown"
+	}
+	// Keep the message without the peer and address specific details.
+	if i := strings.Index(err, ":"); i > 0 {
+		err = err[:i]
+	}
+	if len(err) > 60 {
+		err = err[:60]
+	}
+	return err
+}
+
+// Stats returns a snapshot of the statistics.
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	s.BySide = make(map[string]Counts, len(t.stats.BySide))
+	for k, v := range t.stats.BySide {
+		s.BySide[k] = v
+	}
+	s.ByTransport = make(map[string]Counts, len(t.stats.ByTransport))
+	for k, v := range t.stats.ByTransport {
+		s.ByTransport[k] = v
+	}
+	s.FailureReasons = make(map[string]int, len(t.stats.FailureReasons))
+	for k, v := range t.stats.FailureReasons {
+		s.FailureReasons[k] = v
+	}
+	s.RelayedPeers = nil
+	for p, since := range t.relayedSince {
+		rp := RelayedPeer{Peer: p, Since: since}
+		if t.host != nil {
+			for _, c := range t.host.Network().ConnsToPeer(p) {
+				if relay, err := c.RemoteMultiaddr().ValueForProtocol(multiaddr.P_P2P); err == nil {
+					rp.Relay, _ = peer.Decode(relay)
+					break
+				}
+			}
+		}
+		s.RelayedPeers = append(s.RelayedPeers, rp)
+	}
+	sort.Slice(s.RelayedPeers, func(i, j int) bool { return s.RelayedPeers[i].Since.Before(s.RelayedPeers[j].Since) })
+	return s
+}
+
+// RegisterAPI adds stats/holepunch, returning Stats, to srv.
+func (t *Tracker) RegisterAPI(srv *api.Server) {
+	srv.HandleFunc("stats/holepunch", func(r *http.Request) (interface{}, error) {
+		return t.Stats(), nil
+	})
+}
@@ -0,0 +1,123 @@
+package dcutr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/nettest"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/multiformats/go-multiaddr"
+)
+
+type connAddrs struct{ local, remote multiaddr.Multiaddr }
+
+func (c connAddrs) LocalMultiaddr() multiaddr.Multiaddr  { return c.local }
+func (c connAddrs) RemoteMultiaddr() multiaddr.Multiaddr { return c.remote }
+
+func TestTrackerCounts(t *testing.T) {
+	tr := New()
+	p := peer.ID("remote")
+	tcpAddr := multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001")
+	quicAddr := multiaddr.StringCast("/ip4/1.2.3.4/udp/4001/quic-v1")
+
+	// An initiator succeeding on its second attempt.
+	tr.DirectDialFinished(false)
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.HolePunchAttemptEvt{Attempt: 1}})
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.EndHolePunchEvt{Error: "context deadline exceeded"}})
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.HolePunchAttemptEvt{Attempt: 2}})
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.EndHolePunchEvt{Success: true}})
+	tr.HolePunchFinished("initiator", 2, []multiaddr.Multiaddr{tcpAddr, quicAddr}, nil, connAddrs{remote: tcpAddr})
+	// A receiver failing on both transports.
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.HolePunchAttemptEvt{Attempt: 1}})
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.EndHolePunchEvt{Error: "failed to dial: connection refused"}})
+	tr.HolePunchFinished("receiver", 1, []multiaddr.Multiaddr{tcpAddr, quicAddr}, nil, nil)
+	// A protocol error before punching.
+	tr.Trace(&holepunch.Event{Remote: p, Evt: &holepunch.ProtocolErrorEvt{Error: "stream reset"}})
+
+	s := tr.Stats()
+	if s.Attempts != 3 || s.Successes != 1 || s.Failures != 2 || s.ProtocolErrors != 1 || s.DirectDials != 1 || s.DirectDialSuccesses != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+	if s.BySide["initiator"] != (Counts{Successes: 1}) || s.BySide["receiver"] != (Counts{Failures: 1}) {
+		t.Errorf("unexpected sides %+v", s.BySide)
+	}
+	if s.ByTransport["tcp"] != (Counts{Successes: 1, Failures: 1}) || s.ByTransport["quic-v1"] != (Counts{Failures: 1}) {
+		t.Errorf("unexpected transports %+v", s.ByTransport)
+	}
+	if s.FailureReasons["timeout"] != 1 || s.FailureReasons["connection refused"] != 1 || s.FailureReasons["stream reset"] != 1 {
+		t.Errorf("unexpected reasons %+v", s.FailureReasons)
+	}
+	if rate := s.ByTransport["tcp"].SuccessRate(); rate != 0.5 {
+		t.Errorf("tcp success rate %v", rate)
+	}
+}
+
+func TestReason(t *testing.T) {
+	for err, want := range map[string]string{
+		"":                               "unknown",
+		"i/o timeout":                    "timeout",
+		"protocols not supported: [...]": "protocol not supported",
+		"failed to open hole-punching stream: something new": "failed to open hole-punching stream",
+	} {
+		if got := Reason(err); got != want {
+			t.Errorf("Reason(%q) = %q, want %q", err, got, want)
+		}
+	}
+}
+
+func TestTimeToDirect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	r := nettest.NewHost(t, libp2p.EnableRelayService(relay.WithResources(relay.DefaultResources())), libp2p.ForceReachabilityPublic())
+	b := nettest.NewHost(t)
+	tr := New()
+	a := nettest.NewHost(t, tr.Option())
+	tr.Start(a)
+
+	nettest.Connect(t, b, r)
+	if _, err := client.Reserve(ctx, b, nettest.Info(r)); err != nil {
+		t.Fatal(err)
+	}
+	circuit := multiaddr.StringCast(fmt.Sprintf("%s/p2p/%s/p2p-circuit", r.Addrs()[0], r.ID()))
+	if err := a.Connect(ctx, peer.AddrInfo{ID: b.ID(), Addrs: []multiaddr.Multiaddr{circuit}}); err != nil {
+		t.Fatal(err)
+	}
+	s := tr.Stats()
+	if len(s.RelayedPeers) != 1 || s.RelayedPeers[0].Peer != b.ID() || s.RelayedPeers[0].Relay != r.ID() {
+		t.Fatalf("unexpected relayed peers %+v", s.RelayedPeers)
+	}
+
+	// Loopback addresses are never hole punched, dial directly instead.
+	a.Peerstore().AddAddrs(b.ID(), b.Addrs(), time.Minute)
+	if _, err := a.Network().DialPeer(network.WithForceDirectDial(ctx, "test"), b.ID()); err != nil {
+		t.Fatal(err)
+	}
+	s = tr.Stats()
+	if len(s.RelayedPeers) != 0 || s.TimeToDirect.Count != 1 || s.TimeToDirect.Max <= 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	srv := api.NewServer()
+	tr.RegisterAPI(srv)
+	addr, err := srv.Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	var out Stats
+	if err := api.NewClient(addr.String()).Call(ctx, "stats/holepunch", nil, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.TimeToDirect.Count != 1 {
+		t.Errorf("unexpected API stats %+v", out)
+	}
+}
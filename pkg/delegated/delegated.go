@@ -0,0 +1,155 @@
+// Package delegated serves the Delegated Routing V1 HTTP API (/routing/v1)
+// from a node's DHT and lets nodes that can't run a DHT use such an
+// endpoint as their router.
+//
+// The server answers provider, peer and IPNS lookups with the DHT; it
+// doesn't accept provider announcements, which would be published under
+// the server's peer ID instead of the client's.
+package delegated
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/routing/http/client"
+	"github.com/ipfs/boxo/routing/http/contentrouter"
+	"github.com/ipfs/boxo/routing/http/server"
+	"github.com/ipfs/boxo/routing/http/types"
+	"github.com/ipfs/boxo/routing/http/types/iter"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-log/v2"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// Prefix is the path under which the API is served.
+const Prefix = "/routing/v1/"
+
+var logger = log.Logger("delegated")
+
+// router implements server.ContentRouter on top of a routing.Routing.
+type router struct {
+	h host.Host
+	r routing.Routing
+}
+
+// Handler serves the Delegated Routing V1 API for the lookups of r. h is
+// used to fill in the protocols of the peers found.
+func Handler(h host.Host, r routing.Routing) http.Handler {
+	return cors(server.Handler(&router{h: h, r: r}))
+}
+
+// cors lets browsers call the API from any origin.
+func cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (rt *router) peerRecord(ai peer.AddrInfo) *types.PeerRecord {
+	id := ai.ID
+	rec := &types.PeerRecord{Schema: types.SchemaPeer, ID: &id}
+	for _, addr := range ai.Addrs {
+		rec.Addrs = append(rec.Addrs, types.Multiaddr{Multiaddr: addr})
+	}
+	if protos, err := rt.h.Peerstore().GetProtocols(ai.ID); err == nil {
+		for _, p := range protos {
+			rec.Protocols = append(rec.Protocols, string(p))
+		}
+	}
+	return rec
+}
+
+func (rt *router) FindProviders(ctx context.Context, c cid.Cid, limit int) (iter.ResultIter[types.Record], error) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &chanIter{
+		ch:     rt.r.FindProvidersAsync(ctx, c, limit),
+		cancel: cancel,
+		conv:   func(ai peer.AddrInfo) types.Record { return rt.peerRecord(ai) },
+	}, nil
+}
+
+func (rt *router) ProvideBitswap(ctx context.Context, req *server.BitswapWriteProvideRequest) (time.Duration, error) {
+	return 0, routing.ErrNotSupported
+}
+
+func (rt *router) FindPeers(ctx context.Context, p peer.ID, limit int) (iter.ResultIter[*types.PeerRecord], error) {
+	ai, err := rt.r.FindPeer(ctx, p)
+	if err != nil {
+		if errors.Is(err, routing.ErrNotFound) {
+			return iter.FromSlice[iter.Result[*types.PeerRecord]](nil), nil
+		}
+		return nil, err
+	}
+	return iter.FromSlice([]iter.Result[*types.PeerRecord]{{Val: rt.peerRecord(ai)}}), nil
+}
+
+func (rt *router) GetIPNS(ctx context.Context, name ipns.Name) (*ipns.Record, error) {
+	raw, err := rt.r.GetValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		return nil, err
+	}
+	return ipns.UnmarshalRecord(raw)
+}
+
+func (rt *router) PutIPNS(ctx context.Context, name ipns.Name, rec *ipns.Record) error {
+	raw, err := ipns.MarshalRecord(rec)
+	if err != nil {
+		return err
+	}
+	return rt.r.PutValue(ctx, string(name.RoutingKey()), raw)
+}
+
+// chanIter iterates over the providers found by FindProvidersAsync.
+type chanIter struct {
+	ch     <-chan peer.AddrInfo
+	cancel context.CancelFunc
+	conv   func(peer.AddrInfo) types.Record
+	val    iter.Result[types.Record]
+}
+
+func (it *chanIter) Next() bool {
+	ai, ok := <-it.ch
+	if !ok {
+		return false
+	}
+	it.val = iter.Result[types.Record]{Val: it.conv(ai)}
+	return true
+}
+
+func (it *chanIter) Val() iter.Result[types.Record] {
+	return it.val
+}
+
+func (it *chanIter) Close() error {
+	it.cancel()
+	return nil
+}
+
+// NewRouting returns a router sending every lookup to the Delegated Routing
+// V1 endpoint at url, e.g. http://bootstrap:8080.
+func NewRouting(url string) (routing.Routing, error) {
+	c, err := client.New(url, client.WithUserAgent("libp2p-node"))
+	if err != nil {
+		return nil, err
+	}
+	cr := contentrouter.NewContentRoutingClient(c)
+	logger.Infof("Delegating routing to %s", url)
+	return &routinghelpers.Compose{
+		ValueStore:     cr,
+		PeerRouting:    cr,
+		ContentRouting: cr,
+	}, nil
+}
@@ -0,0 +1,102 @@
+package delegated
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+)
+
+func TestDelegatedRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	dhts := make([]*dht.IpfsDHT, len(hosts))
+	for i, h := range hosts {
+		if dhts[i], err = dht.New(ctx, h, dht.Mode(dht.ModeServer), dht.ProtocolPrefix("/test")); err != nil {
+			t.Fatal(err)
+		}
+		defer dhts[i].Close()
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+	for dhts[1].RoutingTable().Size() < 2 {
+		if ctx.Err() != nil {
+			t.Fatal("routing table not filled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	srv := httptest.NewServer(Handler(hosts[1], dhts[1]))
+	defer srv.Close()
+	r, err := NewRouting(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Providers announced by another peer.
+	h, _ := multihash.Sum([]byte("model"), multihash.SHA2_256, -1)
+	c := cid.NewCidV1(cid.Raw, h)
+	if err := dhts[0].Provide(ctx, c, true); err != nil {
+		t.Fatal(err)
+	}
+	var found []peer.ID
+	for ai := range r.FindProvidersAsync(ctx, c, 10) {
+		found = append(found, ai.ID)
+	}
+	if len(found) != 1 || found[0] != hosts[0].ID() {
+		t.Fatalf("found providers %v, want %s", found, hosts[0].ID())
+	}
+
+	ai, err := r.FindPeer(ctx, hosts[2].ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ai.ID != hosts[2].ID() || len(ai.Addrs) == 0 {
+		t.Fatalf("unexpected peer %v", ai)
+	}
+
+	// IPNS records go through the DHT of the server.
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := peer.IDFromPrivateKey(priv)
+	name := ipns.NameFromPeer(p)
+	rec, err := ipns.NewRecord(priv, path.FromCid(c), 1, time.Now().Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ipns.MarshalRecord(rec)
+	if err := r.PutValue(ctx, string(name.RoutingKey()), raw); err != nil {
+		t.Fatal(err)
+	}
+	got, err := dhts[2].GetValue(ctx, string(name.RoutingKey()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotRec, err := ipns.UnmarshalRecord(got); err != nil {
+		t.Fatal(err)
+	} else if seq, _ := gotRec.Sequence(); seq != 1 {
+		t.Fatalf("sequence %d, want 1", seq)
+	}
+	if _, err := r.GetValue(ctx, string(name.RoutingKey())); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,382 @@
+// Package diagnose collects what a host knows about its own connectivity,
+// its observed addresses, AutoNAT reachability, NAT types and relay
+// reservations, and what happens when it connects to a target peer: the
+// addresses dialed, the connections made and the DCUtR hole punch.
+package diagnose
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// maxHolePunchAttempts is the number of hole punches an initiator tries.
+const maxHolePunchAttempts = 3
+
+// Dial is an address the host dialed.
+type Dial struct {
+	Addr      string
+	Transport string
+	Error     string `json:",omitempty"`
+}
+
+// Conn is an open connection.
+type Conn struct {
+	Addr      string
+	Transport string
+	Relayed   bool
+	Direction string
+	Opened    time.Time
+}
+
+// HolePunch is the DCUtR hole punch with a peer, as traced by the holepunch
+// service. Either side may initiate it.
+type HolePunch struct {
+	// DirectDial is the direct dial tried before hole punching, "ok" or
+	// the error, empty if none was tried.
+	DirectDial  string   `json:",omitempty"`
+	RemoteAddrs []string `json:",omitempty"`
+	RTT         time.Duration
+	Attempts    int
+	Success     bool
+	Elapsed     time.Duration
+	Error       string `json:",omitempty"`
+}
+
+func (hp *HolePunch) done() bool {
+	return hp.Success || hp.Attempts >= maxHolePunchAttempts && hp.Error != ""
+}
+
+// Collector records the connectivity events of a host. Pass Options to
+// libp2p.New, then call Start with the host.
+type Collector struct {
+	mu           sync.Mutex
+	reachability network.Reachability
+	natTypes     map[network.NATTransportProtocol]network.NATDeviceType
+	dials        map[peer.ID][]Dial
+	holePunches  map[peer.ID]*HolePunch
+	// changed is closed and replaced on every update.
+	changed chan struct{}
+	sub     event.Subscription
+}
+
+// New returns an empty Collector.
+func New() *Collector {
+	return &Collector{
+		natTypes:    make(map[network.NATTransportProtocol]network.NATDeviceType),
+		dials:       make(map[peer.ID][]Dial),
+		holePunches: make(map[peer.ID]*HolePunch),
+		changed:     make(chan struct{}),
+	}
+}
+
+// Options records the dials and traces hole punching. They enable hole
+// punching and set the connection gater.
+func (c *Collector) Options() []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.ConnectionGater(c),
+		libp2p.EnableHolePunching(holepunch.WithTracer(c)),
+	}
+}
+
+// Start follows the reachability and NAT type events of h.
+func (c *Collector) Start(h host.Host) error {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtNATDeviceTypeChanged),
+	})
+	if err != nil {
+		return err
+	}
+	c.sub = sub
+	go func() {
+		for e := range sub.Out() {
+			c.update(func() {
+				switch e := e.(type) {
+				case event.EvtLocalReachabilityChanged:
+					c.reachability = e.Reachability
+				case event.EvtNATDeviceTypeChanged:
+					c.natTypes[e.TransportProtocol] = e.NatDeviceType
+				}
+			})
+		}
+	}()
+	return nil
+}
+
+// Close stops following the events.
+func (c *Collector) Close() error {
+	if c.sub == nil {
+		return nil
+	}
+	return c.sub.Close()
+}
+
+func (c *Collector) update(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait waits for an update or the end of ctx.
+func (c *Collector) wait(ctx context.Context) {
+	c.mu.Lock()
+	changed := c.changed
+	c.mu.Unlock()
+	select {
+	case <-changed:
+	case <-ctx.Done():
+	}
+}
+
+// Trace implements holepunch.EventTracer.
+func (c *Collector) Trace(evt *holepunch.Event) {
+	c.update(func() {
+		hp := c.holePunches[evt.Remote]
+		if hp == nil {
+			hp = &HolePunch{}
+			c.holePunches[evt.Remote] = hp
+		}
+		switch e := evt.Evt.(type) {
+		case *holepunch.DirectDialEvt:
+			hp.DirectDial = "ok"
+			if !e.Success {
+				hp.DirectDial = e.Error
+			}
+		case *holepunch.ProtocolErrorEvt:
+			hp.Error = e.Error
+			hp.Attempts = maxHolePunchAttempts
+		case *holepunch.StartHolePunchEvt:
+			hp.RemoteAddrs = e.RemoteAddrs
+			hp.RTT = e.RTT
+		case *holepunch.HolePunchAttemptEvt:
+			hp.Attempts = e.Attempt
+		case *holepunch.EndHolePunchEvt:
+			hp.Success = e.Success
+			hp.Elapsed = e.EllapsedTime
+			hp.Error = e.Error
+			if hp.Attempts == 0 {
+				hp.Attempts = 1
+			}
+		}
+	})
+}
+
+// InterceptPeerDial implements connmgr.ConnectionGater.
+func (c *Collector) InterceptPeerDial(p peer.ID) bool { return true }
+
+// InterceptAddrDial records the dial of a and lets it through.
+func (c *Collector) InterceptAddrDial(p peer.ID, a multiaddr.Multiaddr) bool {
+	c.update(func() {
+		c.dials[p] = append(c.dials[p], Dial{Addr: a.String(), Transport: netutil.Transport(a)})
+	})
+	return true
+}
+
+// InterceptAccept implements connmgr.ConnectionGater.
+func (c *Collector) InterceptAccept(network.ConnMultiaddrs) bool { return true }
+
+// InterceptSecured implements connmgr.ConnectionGater.
+func (c *Collector) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	return true
+}
+
+// InterceptUpgraded implements connmgr.ConnectionGater.
+func (c *Collector) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// recordDialError adds the per address errors of err to the dials of p.
+func (c *Collector) recordDialError(p peer.ID, err error) {
+	var de *swarm.DialError
+	if !errors.As(err, &de) {
+		return
+	}
+	c.update(func() {
+		dials := c.dials[p]
+	next:
+		for _, te := range de.DialErrors {
+			addr := te.Address.String()
+			for i := range dials {
+				if dials[i].Addr == addr && dials[i].Error == "" {
+					dials[i].Error = te.Cause.Error()
+					continue next
+				}
+			}
+			dials = append(dials, Dial{Addr: addr, Transport: netutil.Transport(te.Address), Error: te.Cause.Error()})
+		}
+		c.dials[p] = dials
+	})
+}
+
+func hasDirectConn(h host.Host, p peer.ID) bool {
+	for _, conn := range h.Network().ConnsToPeer(p) {
+		if !netutil.IsRelayed(conn.RemoteMultiaddr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// autorelayTag protects the connections to the relays autorelay holds a
+// reservation on.
+const autorelayTag = "autorelay"
+
+// relays returns the relays h holds a reservation on, with the circuit
+// addresses h advertises through them. Autorelay doesn't advertise the
+// private addresses of a relay, so a relay on the local network may have
+// none.
+func relays(h host.Host) []Relay {
+	byID := make(map[peer.ID]*Relay)
+	var ids []peer.ID
+	add := func(id peer.ID) *Relay {
+		r := byID[id]
+		if r == nil {
+			r = &Relay{ID: id}
+			byID[id] = r
+			ids = append(ids, id)
+		}
+		return r
+	}
+	for _, p := range h.Network().Peers() {
+		if h.ConnManager().IsProtected(p, autorelayTag) {
+			add(p)
+		}
+	}
+	for _, a := range h.Addrs() {
+		if !netutil.IsRelayed(a) {
+			continue
+		}
+		relayAddr, _ := multiaddr.SplitFunc(a, func(c multiaddr.Component) bool {
+			return c.Protocol().Code == multiaddr.P_CIRCUIT
+		})
+		ai, err := peer.AddrInfoFromP2pAddr(relayAddr)
+		if err != nil {
+			continue
+		}
+		r := add(ai.ID)
+		r.Addrs = append(r.Addrs, a.String())
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	rs := make([]Relay, len(ids))
+	for i, id := range ids {
+		rs[i] = *byID[id]
+	}
+	return rs
+}
+
+// WaitReady waits until AutoNAT settled the reachability of h and, when it
+// is private, h holds a relay reservation, or until ctx is done.
+func (c *Collector) WaitReady(ctx context.Context, h host.Host) {
+	for {
+		c.mu.Lock()
+		r := c.reachability
+		c.mu.Unlock()
+		if r == network.ReachabilityPublic || r == network.ReachabilityPrivate && len(relays(h)) > 0 {
+			return
+		}
+		// Relay addresses don't raise an event of their own, poll them.
+		tctx, cancel := context.WithTimeout(ctx, time.Second)
+		c.wait(tctx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Target connects h to ai and, when the connection is relayed, waits for a
+// DCUtR hole punch to end or a direct connection until ctx is done.
+func (c *Collector) Target(ctx context.Context, h host.Host, ai peer.AddrInfo) *TargetReport {
+	tr := &TargetReport{Peer: ai.ID}
+	if err := h.Connect(ctx, ai); err != nil {
+		c.recordDialError(ai.ID, err)
+		tr.Error = err.Error()
+	} else {
+		for !hasDirectConn(h, ai.ID) {
+			c.mu.Lock()
+			hp := c.holePunches[ai.ID]
+			done := hp != nil && hp.done()
+			c.mu.Unlock()
+			if done {
+				break
+			}
+			tctx, cancel := context.WithTimeout(ctx, time.Second)
+			c.wait(tctx)
+			cancel()
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	for _, a := range h.Peerstore().Addrs(ai.ID) {
+		tr.Addrs = append(tr.Addrs, a.String())
+	}
+	for _, conn := range h.Network().ConnsToPeer(ai.ID) {
+		a := conn.RemoteMultiaddr()
+		tr.Connections = append(tr.Connections, Conn{
+			Addr:      a.String(),
+			Transport: netutil.Transport(a),
+			Relayed:   netutil.IsRelayed(a),
+			Direction: conn.Stat().Direction.String(),
+			Opened:    conn.Stat().Opened,
+		})
+	}
+	tr.Connected = len(tr.Connections) > 0
+	c.mu.Lock()
+	tr.Dials = append([]Dial(nil), c.dials[ai.ID]...)
+	if hp := c.holePunches[ai.ID]; hp != nil {
+		cp := *hp
+		tr.HolePunch = &cp
+	}
+	c.mu.Unlock()
+	return tr
+}
+
+// Report describes the connectivity of h. Target is left for the caller.
+func (c *Collector) Report(h host.Host) *Report {
+	r := &Report{
+		PeerID: h.ID(),
+		Relays: relays(h),
+		Peers:  len(h.Network().Peers()),
+	}
+	for _, a := range h.Network().ListenAddresses() {
+		r.ListenAddrs = append(r.ListenAddrs, a.String())
+	}
+	for _, a := range h.Addrs() {
+		r.Addrs = append(r.Addrs, a.String())
+	}
+	if ids, ok := h.(interface{ IDService() identify.IDService }); ok {
+		for _, a := range ids.IDService().OwnObservedAddrs() {
+			r.ObservedAddrs = append(r.ObservedAddrs, a.String())
+		}
+	}
+	c.mu.Lock()
+	r.Reachability = c.reachability.String()
+	if len(c.natTypes) > 0 {
+		r.NATDeviceTypes = make(map[string]string)
+		for proto, typ := range c.natTypes {
+			r.NATDeviceTypes[proto.String()] = typ.String()
+		}
+	}
+	c.mu.Unlock()
+	return r
+}
@@ -0,0 +1,138 @@
+package diagnose
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/nettest"
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/holepunch"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func newCollectorHost(t *testing.T, opts ...libp2p.Option) (*Collector, host.Host) {
+	t.Helper()
+	c := New()
+	h := nettest.NewHost(t, append(c.Options(), opts...)...)
+	if err := c.Start(h); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, h
+}
+
+func TestTargetDirect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, h := newCollectorHost(t)
+	target := nettest.NewHost(t)
+
+	tr := c.Target(ctx, h, nettest.Info(target))
+	if !tr.Connected || !tr.Direct() || tr.Error != "" {
+		t.Fatalf("unexpected report %+v", tr)
+	}
+	if len(tr.Dials) == 0 || tr.Dials[0].Transport != "tcp" {
+		t.Errorf("unexpected dials %+v", tr.Dials)
+	}
+	if tr.Connections[0].Transport != "tcp" || tr.Connections[0].Direction != network.DirOutbound.String() {
+		t.Errorf("unexpected connections %+v", tr.Connections)
+	}
+
+	r := c.Report(h)
+	r.Target = tr
+	r.AddHints()
+	if r.PeerID != h.ID() || r.Peers != 1 || len(r.ListenAddrs) == 0 || len(r.Relays) != 0 {
+		t.Errorf("unexpected report %+v", r)
+	}
+	var b strings.Builder
+	r.WriteText(&b)
+	if !strings.Contains(b.String(), "Target "+target.ID().String()) {
+		t.Errorf("target missing from\n%s", b.String())
+	}
+}
+
+func TestTargetDialError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, h := newCollectorHost(t)
+	target := nettest.NewHost(t)
+	addrs := target.Addrs()
+	target.Close()
+
+	tr := c.Target(ctx, h, peer.AddrInfo{ID: target.ID(), Addrs: addrs})
+	if tr.Connected || tr.Error == "" {
+		t.Fatalf("unexpected report %+v", tr)
+	}
+	if len(tr.Dials) != 1 || tr.Dials[0].Error == "" {
+		t.Errorf("unexpected dials %+v", tr.Dials)
+	}
+	r := &Report{Peers: 1, Reachability: "Public", ObservedAddrs: []string{"x"}, Target: tr}
+	r.AddHints()
+	if len(r.Hints) != 2 || !strings.Contains(r.Hints[1], "No relay address") {
+		t.Errorf("unexpected hints %q", r.Hints)
+	}
+}
+
+func TestRelayReservation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	r := nettest.NewHost(t, libp2p.EnableRelayService(relay.WithResources(relay.DefaultResources())), libp2p.ForceReachabilityPublic())
+	c, h := newCollectorHost(t,
+		libp2p.ForceReachabilityPrivate(),
+		libp2p.EnableAutoRelayWithStaticRelays([]peer.AddrInfo{nettest.Info(r)}, autorelay.WithNumRelays(1), autorelay.WithMinCandidates(1)))
+	nettest.Connect(t, h, r)
+
+	c.WaitReady(ctx, h)
+	rep := c.Report(h)
+	if rep.Reachability != network.ReachabilityPrivate.String() {
+		t.Errorf("reachability %s", rep.Reachability)
+	}
+	if len(rep.Relays) != 1 || rep.Relays[0].ID != r.ID() {
+		t.Fatalf("unexpected relays %+v", rep.Relays)
+	}
+
+	// Another peer reaches h through the relay only.
+	other := nettest.NewHost(t)
+	circuit, _ := multiaddr.NewMultiaddr(fmt.Sprintf("%s/p2p/%s/p2p-circuit", r.Addrs()[0], r.ID()))
+	if err := other.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: []multiaddr.Multiaddr{circuit}}); err != nil {
+		t.Fatal(err)
+	}
+	conns := other.Network().ConnsToPeer(h.ID())
+	if len(conns) != 1 || !netutil.IsRelayed(conns[0].RemoteMultiaddr()) {
+		t.Errorf("unexpected connections %v", conns)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	c := New()
+	p := peer.ID("remote")
+	for _, evt := range []interface{}{
+		&holepunch.DirectDialEvt{Success: false, Error: "no good addresses"},
+		&holepunch.StartHolePunchEvt{RemoteAddrs: []string{"/ip4/1.2.3.4/tcp/4001"}, RTT: time.Millisecond},
+		&holepunch.HolePunchAttemptEvt{Attempt: 1},
+		&holepunch.EndHolePunchEvt{Success: false, Error: "timeout"},
+		&holepunch.HolePunchAttemptEvt{Attempt: 2},
+		&holepunch.EndHolePunchEvt{Success: true, EllapsedTime: 2 * time.Millisecond},
+	} {
+		c.Trace(&holepunch.Event{Remote: p, Evt: evt})
+	}
+	hp := c.holePunches[p]
+	if !hp.done() || !hp.Success || hp.Attempts != 2 || hp.Error != "" || hp.DirectDial != "no good addresses" || hp.RTT != time.Millisecond {
+		t.Errorf("unexpected hole punch %+v", hp)
+	}
+
+	c.Trace(&holepunch.Event{Remote: "other", Evt: &holepunch.ProtocolErrorEvt{Error: "stream reset"}})
+	if hp := c.holePunches["other"]; !hp.done() || hp.Success {
+		t.Errorf("unexpected hole punch %+v", hp)
+	}
+}
@@ -0,0 +1,195 @@
+package diagnose
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+)
+
+// Report is the connectivity of a host.
+type Report struct {
+	PeerID      peer.ID
+	ListenAddrs []string
+	// Addrs are the addresses the host advertises, including its relay
+	// addresses.
+	Addrs []string
+	// ObservedAddrs are the addresses other peers reported seeing the host
+	// on, its addresses outside of the NAT, once enough peers agree.
+	ObservedAddrs []string
+	Reachability  string
+	// NATDeviceTypes maps TCP and UDP to Cone or Symmetric, known once
+	// AutoNAT found the host private.
+	NATDeviceTypes map[string]string `json:",omitempty"`
+	// Relays are the relays the host holds a reservation on.
+	Relays []Relay
+	// Peers is the number of connected peers.
+	Peers  int
+	Target *TargetReport `json:",omitempty"`
+	Hints  []string      `json:",omitempty"`
+}
+
+// Relay is a relay reservation.
+type Relay struct {
+	ID    peer.ID
+	Addrs []string
+}
+
+// TargetReport is how the host connected to a target peer.
+type TargetReport struct {
+	Peer peer.ID
+	// Addrs are the addresses of the peer known to the host.
+	Addrs []string
+	// Dials are the addresses dialed, in order, with their errors. Dials
+	// canceled once another address connected have no error.
+	Dials       []Dial
+	Connected   bool
+	Connections []Conn
+	HolePunch   *HolePunch `json:",omitempty"`
+	Error       string     `json:",omitempty"`
+}
+
+// Direct reports whether one of the connections to the target is direct.
+func (t *TargetReport) Direct() bool {
+	for _, c := range t.Connections {
+		if !c.Relayed {
+			return true
+		}
+	}
+	return false
+}
+
+// AddHints explains the likely causes of connectivity problems in r.
+func (r *Report) AddHints() {
+	hint := func(format string, args ...interface{}) {
+		r.Hints = append(r.Hints, fmt.Sprintf(format, args...))
+	}
+	if r.Peers == 0 {
+		hint("Not connected to any peer: check the bootstrap addresses, the network access and that -swarm-key and -protocol match the network.")
+	}
+	if len(r.ObservedAddrs) == 0 && r.Peers > 0 {
+		hint("No observed address: identify only trusts an address reported by %d peers, without one hole punching has nothing to punch through.", identify.ActivationThresh)
+	}
+	switch r.Reachability {
+	case network.ReachabilityUnknown.String():
+		hint("AutoNAT couldn't determine the reachability, it needs connected peers running the AutoNAT service (bootstrap-node does).")
+	case network.ReachabilityPrivate.String():
+		if len(r.Relays) == 0 {
+			hint("Private node without relay reservation: peers can't reach it. The relays must run the relay service (bootstrap-node does) and be reachable.")
+		}
+		for _, proto := range []string{network.NATTransportTCP.String(), network.NATTransportUDP.String()} {
+			if r.NATDeviceTypes[proto] == network.NATDeviceTypeSymmetric.String() {
+				hint("The NAT is symmetric for %s, hole punching over %s will most likely fail and connections stay relayed.", proto, proto)
+			}
+		}
+	}
+	t := r.Target
+	if t == nil {
+		return
+	}
+	switch {
+	case !t.Connected:
+		hint("Couldn't connect to the target, see the errors of the dials.")
+		relayed := false
+		for _, d := range t.Dials {
+			relayed = relayed || d.Transport == "p2p-circuit"
+		}
+		if !relayed {
+			hint("No relay address of the target was dialed: the target needs a relay reservation when it is behind a NAT.")
+		}
+	case t.Direct():
+		if t.HolePunch != nil && t.HolePunch.Success {
+			hint("Hole punching succeeded, the connection to the target is direct.")
+		}
+	case t.HolePunch == nil:
+		hint("The connection to the target is relayed and no hole punch was attempted: hole punching needs a public address on each side, e.g. an observed address.")
+	default:
+		hint("The connection to the target is relayed, hole punching failed: %s", t.HolePunch.Error)
+	}
+}
+
+// WriteText writes r for humans.
+func (r *Report) WriteText(w io.Writer) {
+	list := func(title string, items []string) {
+		fmt.Fprintf(w, "%s:\n", title)
+		if len(items) == 0 {
+			fmt.Fprintln(w, "  (none)")
+		}
+		for _, item := range items {
+			fmt.Fprintf(w, "  %s\n", item)
+		}
+	}
+
+	fmt.Fprintf(w, "Peer ID: %s\n", r.PeerID)
+	fmt.Fprintf(w, "Connected peers: %d\n", r.Peers)
+	list("Listen addresses", r.ListenAddrs)
+	list("Advertised addresses", r.Addrs)
+	list("Observed addresses", r.ObservedAddrs)
+	fmt.Fprintf(w, "Reachability (AutoNAT): %s\n", r.Reachability)
+	if len(r.NATDeviceTypes) > 0 {
+		var types []string
+		for proto, typ := range r.NATDeviceTypes {
+			types = append(types, proto+" "+typ)
+		}
+		sort.Strings(types)
+		fmt.Fprintf(w, "NAT types: %s\n", strings.Join(types, ", "))
+	}
+	var relays []string
+	for _, relay := range r.Relays {
+		relays = append(relays, relay.ID.String())
+	}
+	list("Relay reservations", relays)
+
+	if t := r.Target; t != nil {
+		fmt.Fprintf(w, "\nTarget %s:\n", t.Peer)
+		if t.Error != "" {
+			fmt.Fprintf(w, "  Connect: %s\n", t.Error)
+		}
+		fmt.Fprintln(w, "  Dials:")
+		if len(t.Dials) == 0 {
+			fmt.Fprintln(w, "    (none)")
+		}
+		for _, d := range t.Dials {
+			if d.Error != "" {
+				fmt.Fprintf(w, "    %-12s %s: %s\n", d.Transport, d.Addr, d.Error)
+			} else {
+				fmt.Fprintf(w, "    %-12s %s\n", d.Transport, d.Addr)
+			}
+		}
+		fmt.Fprintln(w, "  Connections:")
+		if len(t.Connections) == 0 {
+			fmt.Fprintln(w, "    (none)")
+		}
+		for _, c := range t.Connections {
+			kind := "direct"
+			if c.Relayed {
+				kind = "relayed"
+			}
+			fmt.Fprintf(w, "    %-12s %s %s %s, opened %s ago\n", c.Transport, c.Addr, c.Direction, kind, time.Since(c.Opened).Round(time.Millisecond))
+		}
+		if hp := t.HolePunch; hp != nil {
+			fmt.Fprintf(w, "  Hole punch: success %t after %d attempt(s) in %s, RTT %s\n", hp.Success, hp.Attempts, hp.Elapsed, hp.RTT)
+			if hp.DirectDial != "" {
+				fmt.Fprintf(w, "    Direct dial first: %s\n", hp.DirectDial)
+			}
+			if len(hp.RemoteAddrs) > 0 {
+				fmt.Fprintf(w, "    Remote addresses: %s\n", strings.Join(hp.RemoteAddrs, " "))
+			}
+			if hp.Error != "" {
+				fmt.Fprintf(w, "    Error: %s\n", hp.Error)
+			}
+		} else {
+			fmt.Fprintln(w, "  Hole punch: not attempted")
+		}
+	}
+
+	if len(r.Hints) > 0 {
+		fmt.Fprintln(w)
+		list("Hints", r.Hints)
+	}
+}
@@ -0,0 +1,201 @@
+// Package keystore keeps named private keys in a directory: the node
+// identity, IPNS names and other signing keys.
+//
+// Each key is stored in <dir>/<name>.key in the peer key file format of
+// pkg/config, encrypted when LIBP2P_KEY_PASSPHRASE or
+// LIBP2P_KEY_PASSPHRASE_FILE is set. The identity of the node is the key
+// named Self.
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Self is the name of the node identity, as in Kubo.
+const Self = "self"
+
+const keyExt = ".key"
+
+var (
+	ErrNoSuchKey   = errors.New("no such key")
+	ErrKeyExists   = errors.New("key already exists")
+	ErrInvalidName = errors.New("key names may only contain letters, digits, '.', '_' and '-' and can't start with '.'")
+	validName      = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+)
+
+// Keystore is a directory of named keys.
+type Keystore struct {
+	dir string
+	// rename is Rename, tests replace it to make a step of Rotate fail.
+	rename func(from, to string) error
+}
+
+// KeyInfo describes a stored key.
+type KeyInfo struct {
+	Name string
+	ID   peer.ID
+	Type string
+}
+
+// Open opens the keystore in dir, creating the directory if needed.
+func Open(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	ks := &Keystore{dir: dir}
+	ks.rename = ks.Rename
+	return ks, nil
+}
+
+// Dir returns the directory of the keystore.
+func (ks *Keystore) Dir() string {
+	return ks.dir
+}
+
+func (ks *Keystore) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return filepath.Join(ks.dir, name+keyExt), nil
+}
+
+// Has reports whether a key called name exists.
+func (ks *Keystore) Has(name string) (bool, error) {
+	path, err := ks.path(name)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Get loads the key called name.
+func (ks *Keystore) Get(name string) (crypto.PrivKey, error) {
+	path, err := ks.path(name)
+	if err != nil {
+		return nil, err
+	}
+	priv, _, err := config.LoadPeerKey(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNoSuchKey, name)
+	}
+	return priv, err
+}
+
+// Put stores priv as name, failing if the name is taken.
+func (ks *Keystore) Put(name string, priv crypto.PrivKey) error {
+	path, err := ks.path(name)
+	if err != nil {
+		return err
+	}
+	if ok, err := ks.Has(name); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%w: %s", ErrKeyExists, name)
+	}
+	return config.StorePeerKey(path, priv)
+}
+
+// Generate creates and stores a new key called name, bits is only used for
+// RSA keys.
+func (ks *Keystore) Generate(name string, typ, bits int) (crypto.PrivKey, error) {
+	if ok, err := ks.Has(name); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, name)
+	}
+	priv, err := config.GenerateKey(typ, bits)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.Put(name, priv); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// Remove deletes the key called name along with its transition record.
+func (ks *Keystore) Remove(name string) error {
+	path, err := ks.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrNoSuchKey, name)
+		}
+		return err
+	}
+	if err := os.Remove(ks.transitionPath(name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rename renames the key called from to to, failing if to is taken.
+func (ks *Keystore) Rename(from, to string) error {
+	src, err := ks.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := ks.path(to)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNoSuchKey, from)
+	}
+	if ok, err := ks.Has(to); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%w: %s", ErrKeyExists, to)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	err = os.Rename(ks.transitionPath(from), ks.transitionPath(to))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns the stored keys sorted by name.
+func (ks *Keystore) List() ([]KeyInfo, error) {
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []KeyInfo
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), keyExt)
+		if !ok || e.IsDir() || !validName.MatchString(name) {
+			continue
+		}
+		priv, err := ks.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", name, err)
+		}
+		id, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, KeyInfo{Name: name, ID: id, Type: config.KeyTypeString(int(priv.Type()))})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
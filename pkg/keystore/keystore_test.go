@@ -0,0 +1,148 @@
+package keystore
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+
+	offlinert "github.com/ipfs/boxo/routing/offline"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func openKeystore(t *testing.T) *Keystore {
+	t.Setenv("LIBP2P_KEY_PASSPHRASE", "")
+	t.Setenv("LIBP2P_KEY_PASSPHRASE_FILE", "")
+	ks, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestKeystore(t *testing.T) {
+	ks := openKeystore(t)
+	if _, err := ks.Generate("../evil", crypto.Ed25519, 0); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("invalid name: got %v", err)
+	}
+	self, err := ks.Generate(Self, crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Generate("ipns", crypto.Secp256k1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Generate(Self, crypto.Ed25519, 0); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("duplicate: got %v", err)
+	}
+
+	if err := ks.Rename("ipns", "blog"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Rename("blog", Self); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("rename onto existing key: got %v", err)
+	}
+	keys, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfID, _ := peer.IDFromPrivateKey(self)
+	if len(keys) != 2 || keys[0].Name != "blog" || keys[0].Type != "secp256k1" || keys[1].Name != Self || keys[1].ID != selfID {
+		t.Errorf("unexpected keys %+v", keys)
+	}
+
+	if err := ks.Remove("blog"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("blog"); !errors.Is(err, ErrNoSuchKey) {
+		t.Errorf("removed key: got %v", err)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ks := openKeystore(t)
+	old, err := ks.Generate(Self, crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := ks.Rotate("retired", crypto.RSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID, _ := peer.IDFromPrivateKey(old)
+	self, err := ks.Get(Self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID, _ := peer.IDFromPrivateKey(self)
+	if tr.Old != oldID || tr.New != newID {
+		t.Errorf("transition %s -> %s, want %s -> %s", tr.Old, tr.New, oldID, newID)
+	}
+	if retired, err := ks.Get("retired"); err != nil || !retired.Equals(old) {
+		t.Errorf("old key not kept: %v", err)
+	}
+
+	ts, err := ks.Transitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ts["retired"]; got == nil || got.New != newID {
+		t.Fatalf("unexpected transitions %v", ts)
+	}
+
+	forged := *tr
+	other, _, _ := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	forged.New, _ = peer.IDFromPrivateKey(other)
+	forged.NewPubKey = nil
+	if err := forged.Verify(); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("forged transition: got %v", err)
+	}
+
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	r := offlinert.NewOfflineRouter(ds, record.NamespacedValidator{records.Namespace: records.Validator{}})
+	ctx := context.Background()
+	if err := ks.PublishTransitions(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+	found, err := LookupTransition(ctx, r, oldID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.New != newID {
+		t.Errorf("looked up %s, want %s", found.New, newID)
+	}
+}
+
+func TestRotateRestoresSelf(t *testing.T) {
+	ks := openKeystore(t)
+	old, err := ks.Generate(Self, crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failure := errors.New("disk full")
+	ks.rename = func(from, to string) error {
+		if from == Self+".pending" {
+			return failure
+		}
+		return ks.Rename(from, to)
+	}
+
+	if _, err := ks.Rotate("retired", crypto.Ed25519, 0); !errors.Is(err, failure) {
+		t.Fatalf("expected %v, got %v", failure, err)
+	}
+	if self, err := ks.Get(Self); err != nil || !self.Equals(old) {
+		t.Fatalf("old key not restored as %s: %v", Self, err)
+	}
+	keys, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Errorf("keys left behind: %+v", keys)
+	}
+}
@@ -0,0 +1,251 @@
+package keystore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const transitionExt = ".transition.json"
+
+// transitionPrefix separates transition signatures from any other use of
+// the keys.
+const transitionPrefix = "libp2p-node-rotation:"
+
+var logger = log.Logger("keystore")
+
+var ErrInvalidTransition = errors.New("invalid transition record")
+
+// Transition records that the node identity moved from Old to New. It is
+// signed by both keys, so it proves the owner of Old handed over to New and
+// that New accepted.
+type Transition struct {
+	Old  peer.ID   `json:"old"`
+	New  peer.ID   `json:"new"`
+	Time time.Time `json:"time"`
+	// The public keys are only set when they can't be extracted from the
+	// peer IDs.
+	OldPubKey    []byte `json:"old_pubkey,omitempty"`
+	NewPubKey    []byte `json:"new_pubkey,omitempty"`
+	OldSignature []byte `json:"old_sig"`
+	NewSignature []byte `json:"new_sig"`
+}
+
+func (t *Transition) signedBytes() []byte {
+	return []byte(transitionPrefix + t.Old.String() + "\n" + t.New.String() + "\n" + t.Time.UTC().Format(time.RFC3339Nano))
+}
+
+// NewTransition signs the move from oldKey to newKey.
+func NewTransition(oldKey, newKey crypto.PrivKey) (*Transition, error) {
+	oldID, err := peer.IDFromPrivateKey(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newID, err := peer.IDFromPrivateKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transition{Old: oldID, New: newID, Time: time.Now().UTC()}
+	if t.OldPubKey, err = inlinePubKey(oldID, oldKey); err != nil {
+		return nil, err
+	}
+	if t.NewPubKey, err = inlinePubKey(newID, newKey); err != nil {
+		return nil, err
+	}
+	if t.OldSignature, err = oldKey.Sign(t.signedBytes()); err != nil {
+		return nil, err
+	}
+	if t.NewSignature, err = newKey.Sign(t.signedBytes()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// inlinePubKey returns the public key of priv when it isn't embedded in id.
+func inlinePubKey(id peer.ID, priv crypto.PrivKey) ([]byte, error) {
+	if _, err := id.ExtractPublicKey(); err == nil {
+		return nil, nil
+	}
+	return crypto.MarshalPublicKey(priv.GetPublic())
+}
+
+func pubKey(id peer.ID, raw []byte) (crypto.PubKey, error) {
+	if len(raw) == 0 {
+		return id.ExtractPublicKey()
+	}
+	pk, err := crypto.UnmarshalPublicKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !id.MatchesPublicKey(pk) {
+		return nil, fmt.Errorf("%w: public key doesn't match %s", ErrInvalidTransition, id)
+	}
+	return pk, nil
+}
+
+// Verify checks both signatures of t.
+func (t *Transition) Verify() error {
+	for _, s := range []struct {
+		id  peer.ID
+		key []byte
+		sig []byte
+	}{{t.Old, t.OldPubKey, t.OldSignature}, {t.New, t.NewPubKey, t.NewSignature}} {
+		pk, err := pubKey(s.id, s.key)
+		if err != nil {
+			return err
+		}
+		ok, err := pk.Verify(t.signedBytes(), s.sig)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: bad signature of %s", ErrInvalidTransition, s.id)
+		}
+	}
+	return nil
+}
+
+func (ks *Keystore) transitionPath(name string) string {
+	return filepath.Join(ks.dir, name+transitionExt)
+}
+
+// Rotate replaces the Self key with a new key of type typ. The old identity
+// is kept under oldName, "self-<time>" if empty, with the transition record
+// signed by both keys.
+func (ks *Keystore) Rotate(oldName string, typ, bits int) (*Transition, error) {
+	oldKey, err := ks.Get(Self)
+	if err != nil {
+		return nil, err
+	}
+	if oldName == "" {
+		oldName = Self + "-" + time.Now().UTC().Format("20060102T150405Z")
+	}
+	if ok, err := ks.Has(oldName); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExists, oldName)
+	}
+	newKey, err := config.GenerateKey(typ, bits)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewTransition(oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the new key aside first so that a failure leaves Self in place.
+	pending := Self + ".pending"
+	if err := ks.Put(pending, newKey); err != nil {
+		return nil, err
+	}
+	if err := ks.rename(Self, oldName); err != nil {
+		ks.Remove(pending)
+		return nil, err
+	}
+	if err := ks.rename(pending, Self); err != nil {
+		// Without Self the node can't start, put the old key back.
+		if rerr := ks.rename(oldName, Self); rerr != nil {
+			return nil, errors.Join(err, fmt.Errorf("restore %s from %s: %w", Self, oldName, rerr))
+		}
+		ks.Remove(pending)
+		return nil, err
+	}
+	if err := os.WriteFile(ks.transitionPath(oldName), append(data, '\n'), 0600); err != nil {
+		return nil, err
+	}
+	logger.Infof("Rotated identity %s to %s, the old key is kept as %s", t.Old, t.New, oldName)
+	return t, nil
+}
+
+// Transitions returns the transition records of the retired identities,
+// keyed by the name of their key.
+func (ks *Keystore) Transitions() (map[string]*Transition, error) {
+	paths, err := filepath.Glob(filepath.Join(ks.dir, "*"+transitionExt))
+	if err != nil {
+		return nil, err
+	}
+	ts := make(map[string]*Transition, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t, err := ParseTransition(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ts[strings.TrimSuffix(filepath.Base(path), transitionExt)] = t
+	}
+	return ts, nil
+}
+
+// ParseTransition decodes and verifies a transition record.
+func ParseTransition(data []byte) (*Transition, error) {
+	var t Transition
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTransition, err)
+	}
+	if err := t.Verify(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// PublishTransitions publishes the transition record of every retired
+// identity as the DHT record of that identity, signed with the old key, so
+// that peers still knowing the old peer ID can find the new one.
+func (ks *Keystore) PublishTransitions(ctx context.Context, r routing.ValueStore) error {
+	ts, err := ks.Transitions()
+	if err != nil {
+		return err
+	}
+	for name, t := range ts {
+		oldKey, err := ks.Get(name)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if _, err := records.Put(ctx, r, oldKey, data); err != nil {
+			return fmt.Errorf("publish transition of %s: %w", t.Old, err)
+		}
+		logger.Infof("Published transition %s -> %s", t.Old, t.New)
+	}
+	return nil
+}
+
+// LookupTransition fetches the transition record published by old.
+func LookupTransition(ctx context.Context, r routing.ValueStore, old peer.ID) (*Transition, error) {
+	rec, err := records.Get(ctx, r, old)
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseTransition(rec.Value)
+	if err != nil {
+		return nil, err
+	}
+	if t.Old != old {
+		return nil, fmt.Errorf("%w: published by %s for %s", ErrInvalidTransition, old, t.Old)
+	}
+	return t, nil
+}
@@ -0,0 +1,90 @@
+package names
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/ipfs/boxo/path"
+)
+
+// NameResult is returned by the name commands.
+type NameResult struct {
+	Name     string
+	Value    string
+	Sequence uint64    `json:",omitempty"`
+	Validity time.Time `json:",omitempty"`
+	TTL      string    `json:",omitempty"`
+}
+
+func result(e *Entry) NameResult {
+	res := NameResult{Name: e.Name.String(), Value: e.Value.String(), Sequence: e.Sequence, Validity: e.Validity}
+	if e.TTL > 0 {
+		res.TTL = e.TTL.String()
+	}
+	return res
+}
+
+func durationArg(r *http.Request, name string) (time.Duration, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, api.ArgError("invalid %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// RegisterAPI adds the name commands to srv: name/publish points our name at
+// the path in arg, with optional lifetime and ttl durations, name/resolve
+// resolves the name in arg (our own by default) and name/inspect shows our
+// last published record.
+func (s *Service) RegisterAPI(srv *api.Server) {
+	srv.HandleFunc("name/publish", func(r *http.Request) (interface{}, error) {
+		value, err := path.NewPath(r.URL.Query().Get("arg"))
+		if err != nil {
+			return nil, api.ArgError("invalid path: %v", err)
+		}
+		lifetime, err := durationArg(r, "lifetime")
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := durationArg(r, "ttl")
+		if err != nil {
+			return nil, err
+		}
+		e, err := s.Publish(r.Context(), value, lifetime, ttl)
+		if err != nil {
+			return nil, err
+		}
+		return result(e), nil
+	})
+	srv.HandleFunc("name/resolve", func(r *http.Request) (interface{}, error) {
+		name := r.URL.Query().Get("arg")
+		if name == "" {
+			name = s.name.String()
+		}
+		recursive := true
+		if v := r.URL.Query().Get("recursive"); v != "" {
+			var err error
+			if recursive, err = strconv.ParseBool(v); err != nil {
+				return nil, api.ArgError("invalid recursive: %v", err)
+			}
+		}
+		e, err := s.Resolve(r.Context(), name, recursive)
+		if err != nil {
+			return nil, err
+		}
+		return result(e), nil
+	})
+	srv.HandleFunc("name/inspect", func(r *http.Request) (interface{}, error) {
+		e, err := s.Published(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		return result(e), nil
+	})
+}
@@ -0,0 +1,227 @@
+// Package names publishes and resolves IPNS names over the private DHT.
+//
+// A node publishes a single name, derived from its peer ID and signed with its
+// peer key, pointing at an /ipfs/ or /ipns/ path. Records carry a sequence
+// number that increases whenever the value changes, a lifetime after which
+// they stop being valid and a TTL telling resolvers how long to cache them.
+// The last published record is kept in the datastore and republished
+// periodically so that it doesn't expire from the DHT.
+package names
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/namesys"
+	"github.com/ipfs/boxo/path"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+const (
+	// DefaultRepublishInterval is how often the published record is
+	// renewed, like Kubo's Ipns.RepublishPeriod.
+	DefaultRepublishInterval = 4 * time.Hour
+	// DefaultRecordLifetime is how long a record stays valid.
+	DefaultRecordLifetime = ipns.DefaultRecordLifetime
+	// DefaultTTL is how long resolvers may cache a record.
+	DefaultTTL = ipns.DefaultRecordTTL
+
+	initialRepublishDelay = time.Minute
+	republishRetry        = 5 * time.Minute
+)
+
+var logger = log.Logger("names")
+
+// ErrNotPublished is returned when the node hasn't published its name yet.
+var ErrNotPublished = errors.New("no name published")
+
+// Entry describes a published or resolved record.
+type Entry struct {
+	Name     ipns.Name
+	Value    path.Path
+	Sequence uint64
+	Validity time.Time
+	TTL      time.Duration
+}
+
+func entryOf(name ipns.Name, rec *ipns.Record) (*Entry, error) {
+	e := &Entry{Name: name}
+	var err error
+	if e.Value, err = rec.Value(); err != nil {
+		return nil, err
+	}
+	if e.Sequence, err = rec.Sequence(); err != nil {
+		return nil, err
+	}
+	if e.Validity, err = rec.Validity(); err != nil {
+		return nil, err
+	}
+	if e.TTL, err = rec.TTL(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Service publishes the name of one key and resolves the names of others.
+type Service struct {
+	priv      crypto.PrivKey
+	name      ipns.Name
+	publisher *namesys.IPNSPublisher
+	resolver  *namesys.IPNSResolver
+
+	lifetime time.Duration
+	interval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithRecordLifetime sets how long published records stay valid,
+// DefaultRecordLifetime by default.
+func WithRecordLifetime(lifetime time.Duration) Option {
+	return func(s *Service) {
+		s.lifetime = lifetime
+	}
+}
+
+// WithRepublishInterval sets how often the published record is renewed,
+// DefaultRepublishInterval by default. Zero disables republishing.
+func WithRepublishInterval(interval time.Duration) Option {
+	return func(s *Service) {
+		s.interval = interval
+	}
+}
+
+// New creates a Service publishing the name of priv to r. Published records
+// are stored in ds.
+func New(r routing.ValueStore, ds datastore.Datastore, priv crypto.PrivKey, opts ...Option) (*Service, error) {
+	p, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	s := &Service{
+		priv:      priv,
+		name:      ipns.NameFromPeer(p),
+		publisher: namesys.NewIPNSPublisher(r, ds),
+		resolver:  namesys.NewIPNSResolver(r),
+		lifetime:  DefaultRecordLifetime,
+		interval:  DefaultRepublishInterval,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	if s.interval > 0 {
+		s.wg.Add(1)
+		go s.republishLoop()
+	}
+	return s, nil
+}
+
+// Name returns the name published by the service.
+func (s *Service) Name() ipns.Name {
+	return s.name
+}
+
+// Publish points our name at value. The record expires after lifetime and
+// may be cached for ttl, zero values select the defaults.
+func (s *Service) Publish(ctx context.Context, value path.Path, lifetime, ttl time.Duration) (*Entry, error) {
+	if lifetime <= 0 {
+		lifetime = s.lifetime
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	err := s.publisher.Publish(ctx, s.priv, value,
+		namesys.PublishWithEOL(time.Now().Add(lifetime)),
+		namesys.PublishWithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return s.Published(ctx)
+}
+
+// Published returns the record we published last.
+func (s *Service) Published(ctx context.Context) (*Entry, error) {
+	rec, err := s.publisher.GetPublished(ctx, s.name, false)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, ErrNotPublished
+	}
+	return entryOf(s.name, rec)
+}
+
+// Resolve returns the path name currently points at. name is a peer ID,
+// optionally prefixed with /ipns/. Unless recursive, a value that is itself
+// an /ipns/ path is returned as is.
+func (s *Service) Resolve(ctx context.Context, name string, recursive bool) (*Entry, error) {
+	n, err := ipns.NameFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	var opts []namesys.ResolveOption
+	if !recursive {
+		opts = append(opts, namesys.ResolveWithDepth(1))
+	}
+	res, err := s.resolver.Resolve(ctx, n.AsPath(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{Name: n, Value: res.Path, TTL: res.TTL}, nil
+}
+
+// Republish renews the published record with a new lifetime, keeping its
+// value and sequence number.
+func (s *Service) Republish(ctx context.Context) error {
+	e, err := s.Published(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.Publish(ctx, e.Value, s.lifetime, e.TTL)
+	return err
+}
+
+func (s *Service) republishLoop() {
+	defer s.wg.Done()
+	timer := time.NewTimer(initialRepublishDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		next := s.interval
+		switch err := s.Republish(s.ctx); {
+		case err == nil:
+			logger.Infof("Republished %s", s.name)
+		case errors.Is(err, ErrNotPublished):
+		default:
+			logger.Warnf("Republish %s: %v", s.name, err)
+			if republishRetry < next {
+				next = republishRetry
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// Close stops republishing.
+func (s *Service) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,112 @@
+package names
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/ipns"
+	"github.com/ipfs/boxo/path"
+	offlinert "github.com/ipfs/boxo/routing/offline"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	record "github.com/libp2p/go-libp2p-record"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
+	"github.com/multiformats/go-multihash"
+)
+
+func testPath(t *testing.T, data string) path.Path {
+	h, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path.FromCid(cid.NewCidV1(cid.Raw, h))
+}
+
+func newService(t *testing.T) (*Service, peer.ID) {
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := pstoremem.NewPeerstore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	r := offlinert.NewOfflineRouter(ds, record.NamespacedValidator{"ipns": ipns.Validator{KeyBook: ps}})
+	s, err := New(r, ds, priv, WithRepublishInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	p, _ := peer.IDFromPrivateKey(priv)
+	return s, p
+}
+
+func TestPublishResolve(t *testing.T) {
+	ctx := context.Background()
+	s, p := newService(t)
+
+	if _, err := s.Published(ctx); err != ErrNotPublished {
+		t.Fatalf("expected ErrNotPublished, got %v", err)
+	}
+
+	v1 := testPath(t, "config v1")
+	e, err := s.Publish(ctx, v1, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Sequence != 0 || e.TTL != time.Minute || time.Until(e.Validity) > time.Hour {
+		t.Fatalf("unexpected record %+v", e)
+	}
+
+	got, err := s.Resolve(ctx, p.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value.String() != v1.String() {
+		t.Fatalf("resolved %s, want %s", got.Value, v1)
+	}
+
+	v2 := testPath(t, "config v2")
+	if e, err = s.Publish(ctx, v2, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if e.Sequence != 1 {
+		t.Fatalf("sequence %d after changing the value, want 1", e.Sequence)
+	}
+	got, err = s.Resolve(ctx, "/ipns/"+p.String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value.String() != v2.String() {
+		t.Fatalf("resolved %s, want %s", got.Value, v2)
+	}
+}
+
+func TestRepublish(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newService(t)
+
+	v := testPath(t, "model")
+	first, err := s.Publish(ctx, v, time.Minute, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Republish(ctx); err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.Published(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Sequence != first.Sequence || e.Value.String() != v.String() {
+		t.Fatalf("republish changed the record: %+v", e)
+	}
+	if !e.Validity.After(first.Validity.Add(time.Hour)) {
+		t.Fatalf("republish did not extend validity: %s", e.Validity)
+	}
+}
@@ -0,0 +1,38 @@
+// Package nettest provides the libp2p hosts of the tests.
+package nettest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// NewHost returns a host listening on a random TCP port of 127.0.0.1,
+// configured by opts and closed at the end of the test.
+func NewHost(t testing.TB, opts ...libp2p.Option) host.Host {
+	t.Helper()
+	h, err := libp2p.New(append([]libp2p.Option{
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// Info returns the ID and the listen addresses of h.
+func Info(h host.Host) peer.AddrInfo {
+	return peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}
+}
+
+// Connect connects a to b, failing the test when it can't.
+func Connect(t testing.TB, a, b host.Host) {
+	t.Helper()
+	if err := a.Connect(context.Background(), Info(b)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package netutil holds the connection and stream helpers shared by the
+// protocol packages.
+package netutil
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Transport names the transport of a as a multiaddr protocol: tcp,
+// quic-v1, webtransport, ws, or p2p-circuit when relayed.
+func Transport(a multiaddr.Multiaddr) string {
+	name := "unknown"
+	multiaddr.ForEach(a, func(comp multiaddr.Component) bool {
+		switch comp.Protocol().Code {
+		case multiaddr.P_TCP, multiaddr.P_QUIC_V1, multiaddr.P_QUIC, multiaddr.P_WEBTRANSPORT, multiaddr.P_WS, multiaddr.P_WSS:
+			name = comp.Protocol().Name
+		case multiaddr.P_CIRCUIT:
+			name = comp.Protocol().Name
+			return false
+		}
+		return true
+	})
+	return name
+}
+
+// IsRelayed reports whether a goes through a circuit relay.
+func IsRelayed(a multiaddr.Multiaddr) bool {
+	_, err := a.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+// ResetOnDone resets s once ctx is done, interrupting its blocked reads and
+// writes: not every stream honours deadlines. Call the returned function
+// when done with s.
+func ResetOnDone(ctx context.Context, s network.Stream) (stop func() bool) {
+	return context.AfterFunc(ctx, func() { s.Reset() })
+}
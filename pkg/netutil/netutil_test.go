@@ -0,0 +1,26 @@
+package netutil
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestTransport(t *testing.T) {
+	for addr, want := range map[string]string{
+		"/ip4/1.2.3.4/tcp/4001":                      "tcp",
+		"/ip4/1.2.3.4/udp/4001/quic-v1":              "quic-v1",
+		"/ip4/1.2.3.4/udp/4001/quic-v1/webtransport": "webtransport",
+		"/dns4/example.com/tcp/443/wss":              "wss",
+		"/ip4/1.2.3.4/tcp/4001/p2p-circuit":          "p2p-circuit",
+		"/ip4/1.2.3.4":                               "unknown",
+	} {
+		a := multiaddr.StringCast(addr)
+		if got := Transport(a); got != want {
+			t.Errorf("Transport(%s) = %s, want %s", a, got, want)
+		}
+		if relayed := want == "p2p-circuit"; IsRelayed(a) != relayed {
+			t.Errorf("IsRelayed(%s) = %v", a, !relayed)
+		}
+	}
+}
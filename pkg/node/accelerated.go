@@ -0,0 +1,136 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/crawler"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// DefaultCrawlInterval is how often the accelerated DHT client crawls the
+// network to refresh its view of it.
+const DefaultCrawlInterval = time.Hour
+
+// acceleratedRouting is a routing.Routing that is only usable once Ready.
+type acceleratedRouting interface {
+	routing.Routing
+	Ready() bool
+}
+
+// fallbackRouting sends lookups to the accelerated client once it has
+// completed its first crawl, to the regular DHT until then.
+type fallbackRouting struct {
+	accelerated acceleratedRouting
+	dht         routing.Routing
+}
+
+func (r *fallbackRouting) current() routing.Routing {
+	if r.accelerated.Ready() {
+		return r.accelerated
+	}
+	return r.dht
+}
+
+func (r *fallbackRouting) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	return r.current().PutValue(ctx, key, value, opts...)
+}
+
+func (r *fallbackRouting) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	return r.current().GetValue(ctx, key, opts...)
+}
+
+func (r *fallbackRouting) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	return r.current().SearchValue(ctx, key, opts...)
+}
+
+func (r *fallbackRouting) Provide(ctx context.Context, c cid.Cid, announce bool) error {
+	return r.current().Provide(ctx, c, announce)
+}
+
+func (r *fallbackRouting) FindProvidersAsync(ctx context.Context, c cid.Cid, count int) <-chan peer.AddrInfo {
+	return r.current().FindProvidersAsync(ctx, c, count)
+}
+
+func (r *fallbackRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	return r.current().FindPeer(ctx, p)
+}
+
+func (r *fallbackRouting) Bootstrap(ctx context.Context) error {
+	if err := r.dht.Bootstrap(ctx); err != nil {
+		return err
+	}
+	return r.accelerated.Bootstrap(ctx)
+}
+
+func newAccelerated(h host.Host, cfg Config, dhtOpts []dht.Option) (*fullrt.FullRT, error) {
+	prefix := protocol.ID("/ipfs")
+	if cfg.ProtocolPrefix != "" {
+		prefix = protocol.ID(cfg.ProtocolPrefix)
+	}
+	interval := cfg.CrawlInterval
+	if interval <= 0 {
+		interval = DefaultCrawlInterval
+	}
+	// The default crawler only speaks the public DHT protocol.
+	c, err := crawler.NewDefaultCrawler(h,
+		crawler.WithProtocols([]protocol.ID{prefix + "/kad/1.0.0"}),
+		crawler.WithParallelism(200))
+	if err != nil {
+		return nil, err
+	}
+	return fullrt.NewFullRT(h, prefix,
+		fullrt.DHTOption(dhtOpts...),
+		fullrt.WithCrawler(c),
+		fullrt.WithCrawlInterval(interval))
+}
+
+// RoutingStatus reports which DHT client answers the lookups of a node.
+type RoutingStatus struct {
+	Accelerated bool
+	// Ready is set once the accelerated client completed its first crawl
+	// and lookups no longer go through the regular DHT.
+	Ready bool
+	// Peers is the number of peers known to the accelerated client.
+	Peers int
+}
+
+// RoutingStatus returns the state of the accelerated DHT client.
+func (n *Node) RoutingStatus() RoutingStatus {
+	if n.FullRT == nil {
+		return RoutingStatus{}
+	}
+	return RoutingStatus{Accelerated: true, Ready: n.FullRT.Ready(), Peers: len(n.FullRT.Stat())}
+}
+
+// WaitAccelerated blocks until the accelerated DHT client is ready or ctx is
+// done. It returns immediately when the node doesn't use it.
+func (n *Node) WaitAccelerated(ctx context.Context) error {
+	if n.FullRT == nil {
+		return nil
+	}
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for !n.FullRT.Ready() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+func (n *Node) reportReady(ctx context.Context) {
+	start := time.Now()
+	if err := n.WaitAccelerated(ctx); err != nil {
+		return
+	}
+	logger.Infof("Accelerated DHT client ready after %s, %d peers", time.Since(start).Round(time.Second), len(n.FullRT.Stat()))
+}
@@ -0,0 +1,67 @@
+package node
+
+import (
+	"context"
+	"testing"
+
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// fakeRouting answers FindPeer with its own ID, telling who was asked.
+type fakeRouting struct {
+	routinghelpers.Null
+	id    peer.ID
+	ready bool
+}
+
+func (f *fakeRouting) FindPeer(ctx context.Context, p peer.ID) (peer.AddrInfo, error) {
+	return peer.AddrInfo{ID: f.id}, nil
+}
+
+func (f *fakeRouting) Ready() bool {
+	return f.ready
+}
+
+func TestFallbackRouting(t *testing.T) {
+	accelerated := &fakeRouting{id: "accelerated"}
+	r := &fallbackRouting{accelerated: accelerated, dht: &fakeRouting{id: "dht"}}
+	var _ routing.Routing = r
+
+	for _, want := range []peer.ID{"dht", "accelerated"} {
+		ai, err := r.FindPeer(context.Background(), "someone")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ai.ID != want {
+			t.Fatalf("lookup answered by %s, want %s", ai.ID, want)
+		}
+		accelerated.ready = true
+	}
+}
+
+func TestNewAccelerated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n, err := New(ctx, Config{
+		ListenAddrs:    []string{"/ip4/127.0.0.1/tcp/0"},
+		ProtocolPrefix: "/test",
+		BootstrapPeers: []peer.AddrInfo{},
+		Accelerated:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if n.FullRT == nil {
+		t.Fatal("accelerated client not created")
+	}
+	if _, ok := n.Routing.(*fallbackRouting); !ok {
+		t.Fatalf("node routes through %T", n.Routing)
+	}
+	if s := n.RoutingStatus(); !s.Accelerated || s.Ready {
+		t.Fatalf("unexpected status %+v", s)
+	}
+}
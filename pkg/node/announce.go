@@ -0,0 +1,101 @@
+package node
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+
+	"github.com/libp2p/go-libp2p"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// AddrsFactory returns the addresses factory advertising a.Announce instead
+// of the host addresses when set, adding a.AppendAnnounce and removing those
+// matching a.NoAnnounce, as Kubo does.
+func AddrsFactory(a config.Addresses) (basichost.AddrsFactory, error) {
+	announce, err := parseAddrs(a.Announce)
+	if err != nil {
+		return nil, fmt.Errorf("Addresses.Announce: %w", err)
+	}
+	appendAnnounce, err := parseAddrs(a.AppendAnnounce)
+	if err != nil {
+		return nil, fmt.Errorf("Addresses.AppendAnnounce: %w", err)
+	}
+	filters := multiaddr.NewFilters()
+	noAnnounce := make(map[string]bool)
+	for _, s := range a.NoAnnounce {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("Addresses.NoAnnounce: %w", err)
+		}
+		ipnet, err := parseCIDR(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Addresses.NoAnnounce: %w", err)
+		}
+		if ipnet != nil {
+			filters.AddFilter(*ipnet, multiaddr.ActionDeny)
+		} else {
+			noAnnounce[string(addr.Bytes())] = true
+		}
+	}
+
+	return func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		if len(announce) > 0 {
+			addrs = announce
+		}
+		var out []multiaddr.Multiaddr
+		seen := make(map[string]bool)
+		for _, addr := range append(addrs[:len(addrs):len(addrs)], appendAnnounce...) {
+			key := string(addr.Bytes())
+			if seen[key] || noAnnounce[key] || filters.AddrBlocked(addr) {
+				continue
+			}
+			seen[key] = true
+			out = append(out, addr)
+		}
+		return out
+	}, nil
+}
+
+// AnnounceOption sets the addresses factory built from a.
+func AnnounceOption(a config.Addresses) (libp2p.Option, error) {
+	f, err := AddrsFactory(a)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.AddrsFactory(f), nil
+}
+
+func parseAddrs(addrs []string) ([]multiaddr.Multiaddr, error) {
+	var res []multiaddr.Multiaddr
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, addr)
+	}
+	return res, nil
+}
+
+// parseCIDR returns the network of /ip4/<ip>/ipcidr/<bits> or
+// /ip6/<ip>/ipcidr/<bits>, nil for other addresses.
+func parseCIDR(addr multiaddr.Multiaddr) (*net.IPNet, error) {
+	bits, err := addr.ValueForProtocol(multiaddr.P_IPCIDR)
+	if err != nil {
+		return nil, nil
+	}
+	ip, err := addr.ValueForProtocol(multiaddr.P_IP4)
+	if err != nil {
+		if ip, err = addr.ValueForProtocol(multiaddr.P_IP6); err != nil {
+			return nil, fmt.Errorf("%s: ipcidr without an IP", addr)
+		}
+	}
+	_, ipnet, err := net.ParseCIDR(ip + "/" + bits)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return ipnet, nil
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrsFactory(t *testing.T) {
+	addrs := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001"),
+		multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/192.168.1.2/tcp/4001"),
+		multiaddr.StringCast("/ip4/8.8.8.8/tcp/4001"),
+		multiaddr.StringCast("/ip6/fe80::1/tcp/4001"),
+	}
+	for _, c := range []struct {
+		name string
+		cfg  config.Addresses
+		want string
+	}{
+		{"empty", config.Addresses{}, fmt.Sprint(addrs)},
+		{"no announce", config.Addresses{
+			NoAnnounce: []string{"/ip4/10.0.0.0/ipcidr/8", "/ip4/192.168.0.0/ipcidr/16", "/ip6/fe80::/ipcidr/10", "/ip4/127.0.0.1/tcp/4001"},
+		}, "[/ip4/8.8.8.8/tcp/4001]"},
+		{"append", config.Addresses{
+			AppendAnnounce: []string{"/dns4/example.com/tcp/4001", "/ip4/8.8.8.8/tcp/4001"},
+			NoAnnounce:     []string{"/ip4/0.0.0.0/ipcidr/0", "/ip6/::/ipcidr/0"},
+		}, "[/dns4/example.com/tcp/4001]"},
+		{"announce", config.Addresses{
+			Announce:       []string{"/ip4/1.2.3.4/tcp/4001", "/ip4/10.0.0.1/tcp/4001"},
+			AppendAnnounce: []string{"/ip4/1.2.3.4/tcp/4001", "/ip4/5.6.7.8/udp/4001/quic-v1"},
+			NoAnnounce:     []string{"/ip4/10.0.0.0/ipcidr/8"},
+		}, "[/ip4/1.2.3.4/tcp/4001 /ip4/5.6.7.8/udp/4001/quic-v1]"},
+	} {
+		f, err := AddrsFactory(c.cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := fmt.Sprint(f(addrs)); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	for _, cfg := range []config.Addresses{
+		{Announce: []string{"1.2.3.4"}},
+		{AppendAnnounce: []string{"/ip4/1.2.3.4/tcp"}},
+		{NoAnnounce: []string{"/ip4/10.0.0.0/ipcidr/33"}},
+		{NoAnnounce: []string{"/dns4/example.com/ipcidr/8"}},
+	} {
+		if _, err := AddrsFactory(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestAnnounceOption(t *testing.T) {
+	opt, err := AnnounceOption(config.Addresses{
+		AppendAnnounce: []string{"/ip4/1.2.3.4/tcp/4001"},
+		NoAnnounce:     []string{"/ip4/127.0.0.0/ipcidr/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if got := fmt.Sprint(h.Addrs()); got != "[/ip4/1.2.3.4/tcp/4001]" {
+		t.Errorf("advertised %s", got)
+	}
+}
@@ -0,0 +1,54 @@
+package node
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// DHTModeResult is returned by the dht/mode command.
+type DHTModeResult struct {
+	Mode    string
+	Serving bool
+}
+
+// RegisterDHTAPI adds the DHT inspection commands to srv: dht/mode shows
+// the mode of d and, with arg set to server or client, switches it, or with
+// auto follows the reachability again, while stats/dht lists the routing
+// table.
+func RegisterDHTAPI(srv *api.Server, d *dht.IpfsDHT) {
+	srv.HandleFunc("dht/mode", func(r *http.Request) (interface{}, error) {
+		var err error
+		switch arg := r.URL.Query().Get("arg"); arg {
+		case "":
+		case "server", "client":
+			err = SetDHTServing(r.Context(), d, arg == "server")
+		case "auto":
+			err = FollowReachability(d)
+		default:
+			return nil, api.ArgError("mode must be server, client or auto")
+		}
+		if errors.Is(err, ErrFixedMode) || errors.Is(err, ErrNoModeSwitch) {
+			return nil, api.ArgError("%v", err)
+		} else if err != nil {
+			return nil, err
+		}
+		return DHTModeResult{Mode: DHTModeString(d.Mode()), Serving: DHTServing(d)}, nil
+	})
+	srv.HandleFunc("stats/dht", func(r *http.Request) (interface{}, error) {
+		return DHTRoutingTable(d), nil
+	})
+}
+
+// RegisterAPI adds the DHT commands of the node to srv, plus routing/status
+// reporting whether the accelerated DHT client is ready.
+func (n *Node) RegisterAPI(srv *api.Server) {
+	if n.DHT != nil {
+		RegisterDHTAPI(srv, n.DHT)
+	}
+	srv.HandleFunc("routing/status", func(r *http.Request) (interface{}, error) {
+		return n.RoutingStatus(), nil
+	})
+}
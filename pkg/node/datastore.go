@@ -0,0 +1,16 @@
+package node
+
+import (
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	leveldb "github.com/ipfs/go-ds-leveldb"
+)
+
+// OpenDatastore opens the LevelDB datastore at path, or an in-memory one if
+// path is empty.
+func OpenDatastore(path string) (datastore.Batching, error) {
+	if path == "" {
+		return dssync.MutexWrap(datastore.NewMapDatastore()), nil
+	}
+	return leveldb.NewDatastore(path, nil)
+}
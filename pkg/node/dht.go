@@ -0,0 +1,274 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+)
+
+// ErrFixedMode is returned when switching the mode of a DHT started in
+// client or server mode.
+var ErrFixedMode = errors.New("DHT mode can only be switched when started in auto or autoserver mode")
+
+// ErrNoModeSwitch is returned when switching the mode of a DHT not created
+// by NewDHT.
+var ErrNoModeSwitch = errors.New("DHT mode can only be switched when created by NewDHT")
+
+var dhtModes = map[string]dht.ModeOpt{
+	"auto":       dht.ModeAuto,
+	"autoserver": dht.ModeAutoServer,
+	"client":     dht.ModeClient,
+	"server":     dht.ModeServer,
+	// Kubo's Routing.Type names.
+	"dht":       dht.ModeAuto,
+	"dhtclient": dht.ModeClient,
+	"dhtserver": dht.ModeServer,
+}
+
+// ParseDHTMode parses auto, autoserver, client or server, or the Kubo
+// Routing.Type names dht, dhtclient and dhtserver.
+func ParseDHTMode(s string) (dht.ModeOpt, error) {
+	m, ok := dhtModes[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown DHT mode %q", s)
+	}
+	return m, nil
+}
+
+// DHTModeString is the inverse of ParseDHTMode.
+func DHTModeString(m dht.ModeOpt) string {
+	switch m {
+	case dht.ModeAuto:
+		return "auto"
+	case dht.ModeAutoServer:
+		return "autoserver"
+	case dht.ModeClient:
+		return "client"
+	case dht.ModeServer:
+		return "server"
+	}
+	return fmt.Sprintf("mode(%d)", m)
+}
+
+// DHTServing reports whether d currently answers DHT queries, that is
+// whether it is in server mode.
+func DHTServing(d *dht.IpfsDHT) bool {
+	for _, p := range d.Host().Mux().Protocols() {
+		if strings.HasSuffix(string(p), "/kad/1.0.0") {
+			return true
+		}
+	}
+	return false
+}
+
+// The events kad-dht subscribes to, besides EvtLocalReachabilityChanged.
+var dhtEvents = []interface{}{
+	new(event.EvtPeerIdentificationCompleted),
+	new(event.EvtPeerProtocolsUpdated),
+	new(event.EvtLocalAddressesUpdated),
+	new(event.EvtPeerConnectednessChanged),
+}
+
+// modeHost is the host given to the DHT by NewDHT. Its event bus forwards
+// the events of the host, except the reachability while SetDHTServing
+// overrides it, so the mode of the DHT can be switched without the rest of
+// the host seeing a different reachability.
+type modeHost struct {
+	host.Host
+	bus          event.Bus
+	emitters     map[reflect.Type]event.Emitter
+	reachability event.Emitter
+
+	mu sync.Mutex
+	// last is the reachability of the host.
+	last network.Reachability
+	// overridden is set by SetDHTServing, until FollowReachability.
+	overridden bool
+}
+
+func (h *modeHost) EventBus() event.Bus { return h.bus }
+
+// NewDHT creates a DHT on h whose mode SetDHTServing can switch at runtime.
+func NewDHT(ctx context.Context, h host.Host, opts ...dht.Option) (*dht.IpfsDHT, error) {
+	mh := &modeHost{Host: h, bus: eventbus.NewBus(), emitters: make(map[reflect.Type]event.Emitter)}
+	for _, evt := range dhtEvents {
+		em, err := mh.bus.Emitter(evt)
+		if err != nil {
+			return nil, err
+		}
+		mh.emitters[reflect.TypeOf(evt).Elem()] = em
+	}
+	var err error
+	mh.reachability, err = mh.bus.Emitter(new(event.EvtLocalReachabilityChanged), eventbus.Stateful)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := h.EventBus().Subscribe(append(dhtEvents, new(event.EvtLocalReachabilityChanged)), eventbus.BufSize(256))
+	if err != nil {
+		return nil, err
+	}
+	d, err := dht.New(ctx, mh, opts...)
+	if err != nil {
+		sub.Close()
+		return nil, err
+	}
+	go mh.forward(d.Context(), sub)
+	return d, nil
+}
+
+func (h *modeHost) forward(ctx context.Context, sub event.Subscription) {
+	defer sub.Close()
+	for {
+		select {
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			if evt, ok := e.(event.EvtLocalReachabilityChanged); ok {
+				h.mu.Lock()
+				h.last = evt.Reachability
+				if !h.overridden {
+					h.reachability.Emit(evt)
+				}
+				h.mu.Unlock()
+				continue
+			}
+			h.emitters[reflect.TypeOf(e)].Emit(e)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func dhtModeHost(d *dht.IpfsDHT) (*modeHost, error) {
+	if m := d.Mode(); m != dht.ModeAuto && m != dht.ModeAutoServer {
+		return nil, ErrFixedMode
+	}
+	h, ok := d.Host().(*modeHost)
+	if !ok {
+		return nil, ErrNoModeSwitch
+	}
+	return h, nil
+}
+
+// SetDHTServing switches d, created by NewDHT in auto or autoserver mode,
+// to server or client mode, and waits until the switch is done. d keeps
+// that mode, whatever AutoNAT finds, until FollowReachability.
+func SetDHTServing(ctx context.Context, d *dht.IpfsDHT, server bool) error {
+	h, err := dhtModeHost(d)
+	if err != nil {
+		return err
+	}
+	// The DHT adds or removes its protocols when switching.
+	sub, err := h.Host.EventBus().Subscribe(new(event.EvtLocalProtocolsUpdated))
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	reachability := network.ReachabilityPrivate
+	if server {
+		reachability = network.ReachabilityPublic
+	}
+	h.mu.Lock()
+	h.overridden = true
+	err = h.reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: reachability})
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for DHTServing(d) != server {
+		select {
+		case <-sub.Out():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// FollowReachability makes d, created by NewDHT, switch its mode with the
+// reachability of the host again.
+func FollowReachability(d *dht.IpfsDHT) error {
+	h, err := dhtModeHost(d)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.overridden = false
+	return h.reachability.Emit(event.EvtLocalReachabilityChanged{Reachability: h.last})
+}
+
+// RoutingTablePeer is a peer in the DHT routing table.
+type RoutingTablePeer struct {
+	ID                            peer.ID
+	AgentVersion                  string `json:",omitempty"`
+	Connected                     bool
+	Latency                       time.Duration
+	AddedAt                       time.Time
+	LastUsefulAt                  time.Time
+	LastSuccessfulOutboundQueryAt time.Time
+}
+
+// Bucket holds the routing table peers sharing Cpl leading bits with our
+// own ID.
+type Bucket struct {
+	Cpl   int
+	Peers []RoutingTablePeer
+}
+
+// RoutingTable describes the DHT routing table of a node.
+type RoutingTable struct {
+	Mode    string
+	Serving bool
+	Size    int
+	Buckets []Bucket
+}
+
+// DHTRoutingTable returns the routing table of d grouped in buckets, with
+// what the peerstore knows about each peer.
+func DHTRoutingTable(d *dht.IpfsDHT) RoutingTable {
+	h := d.Host()
+	self := kb.ConvertPeerID(h.ID())
+	rt := RoutingTable{Mode: DHTModeString(d.Mode()), Serving: DHTServing(d)}
+	buckets := make(map[int][]RoutingTablePeer)
+	for _, pi := range d.RoutingTable().GetPeerInfos() {
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(pi.Id))
+		buckets[cpl] = append(buckets[cpl], describePeer(h, pi))
+		rt.Size++
+	}
+	for cpl, peers := range buckets {
+		sort.Slice(peers, func(i, j int) bool { return peers[i].LastUsefulAt.After(peers[j].LastUsefulAt) })
+		rt.Buckets = append(rt.Buckets, Bucket{Cpl: cpl, Peers: peers})
+	}
+	sort.Slice(rt.Buckets, func(i, j int) bool { return rt.Buckets[i].Cpl < rt.Buckets[j].Cpl })
+	return rt
+}
+
+func describePeer(h host.Host, pi kb.PeerInfo) RoutingTablePeer {
+	p := RoutingTablePeer{
+		ID:                            pi.Id,
+		Connected:                     h.Network().Connectedness(pi.Id) == network.Connected,
+		Latency:                       h.Peerstore().LatencyEWMA(pi.Id),
+		AddedAt:                       pi.AddedAt,
+		LastUsefulAt:                  pi.LastUsefulAt,
+		LastSuccessfulOutboundQueryAt: pi.LastSuccessfulOutboundQueryAt,
+	}
+	if v, err := h.Peerstore().Get(pi.Id, "AgentVersion"); err == nil {
+		p.AgentVersion, _ = v.(string)
+	}
+	return p
+}
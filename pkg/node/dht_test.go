@@ -0,0 +1,125 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/event"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseDHTMode(t *testing.T) {
+	for s, want := range map[string]dht.ModeOpt{"auto": dht.ModeAuto, "AutoServer": dht.ModeAutoServer, "dhtclient": dht.ModeClient, "server": dht.ModeServer} {
+		m, err := ParseDHTMode(s)
+		if err != nil || m != want {
+			t.Errorf("ParseDHTMode(%q) = %v, %v", s, m, err)
+		}
+	}
+	if _, err := ParseDHTMode("none"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestDHTModeAndRoutingTable(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshLinked(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	auto, err := NewDHT(ctx, hosts[0], dht.Mode(dht.ModeAutoServer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auto.Close()
+	var servers []*dht.IpfsDHT
+	for _, h := range hosts[1:] {
+		d, err := NewDHT(ctx, h, dht.Mode(dht.ModeServer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		servers = append(servers, d)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "routing table", func() bool { return auto.RoutingTable().Size() == 2 })
+	rt := DHTRoutingTable(auto)
+	if rt.Mode != "autoserver" || !rt.Serving || rt.Size != 2 {
+		t.Fatalf("unexpected routing table %+v", rt)
+	}
+	n := 0
+	for _, b := range rt.Buckets {
+		for _, p := range b.Peers {
+			if !p.Connected || p.AddedAt.IsZero() {
+				t.Errorf("unexpected peer %+v", p)
+			}
+			n++
+		}
+	}
+	if n != 2 {
+		t.Fatalf("%d peers in buckets, want 2", n)
+	}
+
+	// The host reachability doesn't change with the DHT mode.
+	sub, err := hosts[0].EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := SetDHTServing(ctx, auto, false); err != nil {
+		t.Fatal(err)
+	}
+	if DHTServing(auto) {
+		t.Fatal("still serving after switching to client mode")
+	}
+	if err := SetDHTServing(ctx, auto, true); err != nil {
+		t.Fatal(err)
+	}
+	if !DHTServing(auto) {
+		t.Fatal("not serving after switching to server mode")
+	}
+	if err := SetDHTServing(ctx, auto, false); err != nil {
+		t.Fatal(err)
+	}
+	// Back to the unknown reachability of the host, served by autoserver.
+	if err := FollowReachability(auto); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "server mode", func() bool { return DHTServing(auto) })
+	select {
+	case e := <-sub.Out():
+		t.Errorf("host saw %+v", e)
+	default:
+	}
+
+	if err := SetDHTServing(ctx, servers[0], false); !errors.Is(err, ErrFixedMode) {
+		t.Fatalf("expected ErrFixedMode, got %v", err)
+	}
+	plain, err := dht.New(ctx, hosts[0], dht.Mode(dht.ModeAuto))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if err := SetDHTServing(ctx, plain, true); !errors.Is(err, ErrNoModeSwitch) {
+		t.Fatalf("expected ErrNoModeSwitch, got %v", err)
+	}
+}
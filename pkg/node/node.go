@@ -0,0 +1,250 @@
+// Package node builds the libp2p host and DHT shared by our command line
+// tools, so that they join the private network the same way the rendezvous
+// and bootstrap nodes do.
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/delegated"
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p-kad-dht/fullrt"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var logger = log.Logger("node")
+
+// DefaultBootstrapPeers are the public bootstrap nodes of our network.
+var DefaultBootstrapPeers = []string{
+	"/ip4/122.99.183.54/tcp/7001/p2p/12D3KooWSpgWzEXE5GNjY6hgdAhuuBLe4d3ocqWDnVLdCa8U3cig",
+	"/ip4/82.157.50.32/tcp/7001/p2p/12D3KooWFrTcDtocZWEvEAk2X4poyn13LzT3G7JMBRoPD73YPAoB",
+}
+
+// ExtraBootstrapPeers are the other bootstrap nodes, which only the
+// bootstrap nodes dial in addition to DefaultBootstrapPeers.
+var ExtraBootstrapPeers = []string{
+	"/ip4/122.99.183.54/tcp/8511/p2p/12D3KooWJaNdwbwsvESYZmeEhTwkG1KirKxXpE6CdPx2Z9VqM3Rt",
+	"/ip4/122.99.183.54/tcp/8515/p2p/12D3KooWNp5pyEAtXs52RqssBkrCGojyNLWiZysjr8EpXKK4rpyp",
+}
+
+// Config describes the node to build.
+type Config struct {
+	// ListenAddrs defaults to a random TCP port on all interfaces.
+	ListenAddrs []string
+	// PeerKeyPath is loaded, or generated if missing. An empty path gives
+	// the node a throw-away identity.
+	PeerKeyPath string
+	// PSK is the hex encoded pre-shared key of the private network.
+	PSK string
+	// SwarmKey is the path of a swarm.key file holding the pre-shared key,
+	// used instead of PSK. Both default to the file named by
+	// LIBP2P_SWARM_KEY.
+	SwarmKey string
+	// ProtocolPrefix is attached to all DHT protocols.
+	ProtocolPrefix string
+	DHTMode        dht.ModeOpt
+	// BootstrapPeers defaults to DefaultBootstrapPeers.
+	BootstrapPeers []peer.AddrInfo
+	// Accelerated adds the fullrt DHT client, which crawls the whole network
+	// to answer lookups without walking the DHT. It is used for FindPeer,
+	// FindProviders and the value lookups once its first crawl completed,
+	// the regular DHT until then.
+	Accelerated bool
+	// CrawlInterval is how often the accelerated client crawls the
+	// network, DefaultCrawlInterval by default.
+	CrawlInterval time.Duration
+	// DelegatedRouting is the URL of a Delegated Routing V1 endpoint, such
+	// as the one served by bootstrap-node. When set the node doesn't run a
+	// DHT and sends all its lookups there.
+	DelegatedRouting string
+	// Options are appended to the libp2p options built from the fields
+	// above.
+	Options []libp2p.Option
+	// DHTOptions are appended to the DHT options, e.g. to share a
+	// datastore.
+	DHTOptions []dht.Option
+}
+
+// Node is a libp2p host with a Kademlia DHT used for peer routing. DHT is nil
+// when routing is delegated.
+type Node struct {
+	Host host.Host
+	DHT  *dht.IpfsDHT
+	// FullRT is the accelerated DHT client, nil unless enabled.
+	FullRT *fullrt.FullRT
+	// Routing answers lookups, it is DHT, DHT with FullRT on top or the
+	// delegated router.
+	Routing routing.Routing
+
+	bootstrapPeers []peer.AddrInfo
+}
+
+// ParseBootstrapPeers converts /p2p multiaddrs to AddrInfos.
+func ParseBootstrapPeers(addrs []string) ([]peer.AddrInfo, error) {
+	pinfos := make([]peer.AddrInfo, 0, len(addrs))
+	for _, addr := range addrs {
+		maddr, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, err
+		}
+		p, err := peer.AddrInfoFromP2pAddr(maddr)
+		if err != nil {
+			return nil, err
+		}
+		pinfos = append(pinfos, *p)
+	}
+	return pinfos, nil
+}
+
+// LoadOrGeneratePeerKey loads the peer key at path, generating and saving a
+// new Ed25519 key when the file doesn't exist.
+func LoadOrGeneratePeerKey(path string) (crypto.PrivKey, error) {
+	priv, _, err := config.LoadPeerKey(path)
+	if err == nil {
+		logger.Info("Load peer key success")
+		return priv, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load peer key: %w", err)
+	}
+	priv, _, err = config.GeneratePeerKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("generate peer key: %w", err)
+	}
+	return priv, nil
+}
+
+// New creates the host and its DHT. Call Bootstrap to join the network.
+func New(ctx context.Context, cfg Config) (*Node, error) {
+	listenAddrs := cfg.ListenAddrs
+	if len(listenAddrs) == 0 {
+		listenAddrs = []string{"/ip4/0.0.0.0/tcp/0"}
+	}
+	bootstrapPeers := cfg.BootstrapPeers
+	if bootstrapPeers == nil {
+		var err error
+		bootstrapPeers, err = ParseBootstrapPeers(DefaultBootstrapPeers)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n := &Node{bootstrapPeers: bootstrapPeers}
+	opts := []libp2p.Option{
+		libp2p.ListenAddrStrings(listenAddrs...),
+		libp2p.DefaultPrivateTransports,
+		libp2p.DefaultMuxers,
+		libp2p.DefaultSecurity,
+		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
+			if cfg.DelegatedRouting != "" {
+				var err error
+				n.Routing, err = delegated.NewRouting(cfg.DelegatedRouting)
+				return n.Routing, err
+			}
+			dhtOpts := []dht.Option{
+				dht.BootstrapPeers(bootstrapPeers...),
+				dht.NamespacedValidator(records.Namespace, records.Validator{}),
+			}
+			if cfg.ProtocolPrefix != "" {
+				dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(cfg.ProtocolPrefix)))
+			}
+			var err error
+			dhtOpts = append(dhtOpts, cfg.DHTOptions...)
+			n.DHT, err = NewDHT(ctx, h, append(dhtOpts, dht.Mode(cfg.DHTMode))...)
+			if err != nil {
+				return nil, err
+			}
+			n.Routing = n.DHT
+			if !cfg.Accelerated {
+				return n.DHT, nil
+			}
+			if n.FullRT, err = newAccelerated(h, cfg, dhtOpts); err != nil {
+				n.DHT.Close()
+				return nil, fmt.Errorf("accelerated DHT client: %w", err)
+			}
+			n.Routing = &fallbackRouting{accelerated: n.FullRT, dht: n.DHT}
+			go n.reportReady(ctx)
+			return n.Routing, nil
+		}),
+	}
+	if cfg.PeerKeyPath != "" {
+		priv, err := LoadOrGeneratePeerKey(cfg.PeerKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, libp2p.Identity(priv))
+	}
+	psk, err := config.LoadPSK(cfg.PSK, cfg.SwarmKey)
+	if err != nil {
+		return nil, err
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	opts = append(opts, cfg.Options...)
+
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	n.Host = h
+	return n, nil
+}
+
+// Bootstrap connects to the bootstrap peers and starts refreshing the DHT
+// routing table. It only fails if none of the bootstrap peers is reachable.
+func (n *Node) Bootstrap(ctx context.Context) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	connected := 0
+	for _, peerInfo := range n.bootstrapPeers {
+		wg.Add(1)
+		go func(peerInfo peer.AddrInfo) {
+			defer wg.Done()
+			n.Host.Peerstore().AddAddrs(peerInfo.ID, peerInfo.Addrs, peerstore.PermanentAddrTTL)
+			if err := n.Host.Connect(ctx, peerInfo); err != nil {
+				logger.Warnf("Connect bootstrap node %s: %v", peerInfo.ID, err)
+				return
+			}
+			logger.Info("Connection established with bootstrap node:", peerInfo)
+			mu.Lock()
+			connected++
+			mu.Unlock()
+		}(peerInfo)
+	}
+	wg.Wait()
+
+	if len(n.bootstrapPeers) > 0 && connected == 0 {
+		return fmt.Errorf("none of the %d bootstrap nodes is reachable", len(n.bootstrapPeers))
+	}
+	return n.Routing.Bootstrap(ctx)
+}
+
+// Close shuts down the DHT and the host.
+func (n *Node) Close() error {
+	if n.FullRT != nil {
+		if err := n.FullRT.Close(); err != nil {
+			logger.Warnf("Close accelerated DHT client: %v", err)
+		}
+	}
+	if n.DHT != nil {
+		if err := n.DHT.Close(); err != nil {
+			logger.Warnf("Close DHT: %v", err)
+		}
+	}
+	return n.Host.Close()
+}
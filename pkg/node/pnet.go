@@ -0,0 +1,57 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// JoinNextNetwork starts the second node of a pre-shared key rotation: it
+// has the identity priv and joins the private network of p.NextSwarmKey on
+// p.NextAddresses, bootstrapping from p.NextBootstrap.
+//
+// Nodes on different keys can't connect to each other, so a node on both
+// networks is the only bridge between them while the rotation is under
+// way. cfg should carry the protocol prefix and DHT mode of the node on the
+// current key, and its datastore in DHTOptions so that the records stored
+// on this node are served on both networks. Each DHT keeps its own LRU cache
+// of provider records over that datastore, so a provider set cached by one
+// may miss the providers added through the other until it expires.
+func JoinNextNetwork(ctx context.Context, priv crypto.PrivKey, current pnet.PSK, p config.Pnet, cfg Config) (*Node, error) {
+	if len(p.NextAddresses) == 0 {
+		return nil, errors.New("Pnet.NextAddresses must be set to join the next network")
+	}
+	next, err := config.ReadSwarmKey(p.NextSwarmKey)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(next, current) {
+		return nil, errors.New("Pnet.NextSwarmKey is the current key")
+	}
+	bootstrap, err := ParseBootstrapPeers(p.NextBootstrap)
+	if err != nil {
+		return nil, fmt.Errorf("Pnet.NextBootstrap: %w", err)
+	}
+
+	cfg.ListenAddrs = p.NextAddresses
+	cfg.PeerKeyPath = ""
+	cfg.PSK = ""
+	cfg.SwarmKey = p.NextSwarmKey
+	// Not nil: the default bootstrap peers are on the current network.
+	cfg.BootstrapPeers = append([]peer.AddrInfo{}, bootstrap...)
+	cfg.Options = append(cfg.Options[:len(cfg.Options):len(cfg.Options)], libp2p.Identity(priv))
+	n, err := New(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Joined the network of the next pre-shared key on %v", n.Host.Addrs())
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/records"
+
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestJoinNextNetwork(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv(config.EnvSwarmKey, "")
+	oldPSK, _ := config.GenerateSwarmKey()
+	newPSK, _ := config.GenerateSwarmKey()
+	nextKey := filepath.Join(t.TempDir(), "swarm.key")
+	if err := config.WriteSwarmKey(nextKey, newPSK, config.SwarmKeyBase16); err != nil {
+		t.Fatal(err)
+	}
+
+	newNode := func(cfg Config) *Node {
+		t.Helper()
+		cfg.ProtocolPrefix = "/test"
+		cfg.DHTMode = dht.ModeServer
+		if cfg.ListenAddrs == nil {
+			cfg.ListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
+		}
+		if cfg.BootstrapPeers == nil {
+			cfg.BootstrapPeers = []peer.AddrInfo{}
+		}
+		n, err := New(ctx, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { n.Close() })
+		return n
+	}
+
+	// The bridge is on both networks, its DHTs share a datastore.
+	ds := dssync.MutexWrap(datastore.NewMapDatastore())
+	priv, _, _ := crypto.GenerateEd25519Key(nil)
+	bridge := newNode(Config{
+		PSK:        hex.EncodeToString(oldPSK),
+		Options:    []libp2p.Option{libp2p.Identity(priv)},
+		DHTOptions: []dht.Option{dht.Datastore(ds)},
+	})
+
+	pnetCfg := config.Pnet{NextSwarmKey: nextKey, NextAddresses: []string{"/ip4/127.0.0.1/tcp/0"}}
+	nextCfg := Config{ProtocolPrefix: "/test", DHTMode: dht.ModeServer, DHTOptions: []dht.Option{dht.Datastore(ds)}}
+	if _, err := JoinNextNetwork(ctx, priv, newPSK, pnetCfg, nextCfg); err == nil {
+		t.Error("accepted the current key as the next one")
+	}
+	next, err := JoinNextNetwork(ctx, priv, oldPSK, pnetCfg, nextCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if next.Host.ID() != bridge.Host.ID() {
+		t.Fatal("the next node has another identity")
+	}
+
+	oldClient := newNode(Config{PSK: hex.EncodeToString(oldPSK)})
+	newClient := newNode(Config{SwarmKey: nextKey})
+	if err := oldClient.Host.Connect(ctx, peer.AddrInfo{ID: bridge.Host.ID(), Addrs: bridge.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := newClient.Host.Connect(ctx, peer.AddrInfo{ID: next.Host.ID(), Addrs: next.Host.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	// The networks stay apart.
+	cctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := newClient.Host.Connect(cctx, peer.AddrInfo{ID: oldClient.Host.ID(), Addrs: oldClient.Host.Addrs()}); err == nil {
+		t.Error("connected across pre-shared keys")
+	}
+
+	waitFor(t, "routing tables", func() bool {
+		return oldClient.DHT.RoutingTable().Size() > 0 && newClient.DHT.RoutingTable().Size() > 0
+	})
+	clientKey, _, _ := crypto.GenerateEd25519Key(nil)
+	clientID, _ := peer.IDFromPrivateKey(clientKey)
+	if _, err := records.Put(ctx, oldClient.DHT, clientKey, []byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := records.Get(ctx, newClient.DHT, clientID)
+	if err != nil {
+		t.Fatalf("record put on the old network not found on the new one: %v", err)
+	}
+	if string(rec.Value) != "moved" {
+		t.Errorf("got %q", rec.Value)
+	}
+}
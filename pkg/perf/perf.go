@@ -0,0 +1,261 @@
+// Package perf implements the libp2p perf protocol, /perf/1.0.0, to measure
+// the throughput between two peers.
+//
+// The client opens a stream and writes the number of bytes it wants back as
+// a big endian uint64, then uploads its own bytes and closes its side of the
+// stream. The server discards the upload until EOF, sends the requested
+// bytes and closes the stream. As the server only starts sending once the
+// upload is complete, the time to the first downloaded byte includes the
+// upload.
+package perf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// ID is the perf protocol.
+const ID = protocol.ID("/perf/1.0.0")
+
+// BlockSize is the size of the writes carrying the payload.
+const BlockSize = 64 << 10
+
+// MaxBytes caps the upload and the download of a run. The server resets
+// the streams asking for more, so a single stream can't keep it busy.
+const MaxBytes = 1 << 30
+
+// ServiceName is the resource manager service of the perf streams.
+const ServiceName = "libp2p.perf"
+
+// SetServiceLimits limits the perf streams of a resource manager built from
+// config to a few runs at a time, one per peer, as
+// libp2p.SetDefaultServiceLimits does for the other services.
+func SetServiceLimits(config *rcmgr.ScalingLimitConfig) {
+	config.AddServiceLimit(ServiceName,
+		rcmgr.BaseLimit{StreamsInbound: 4, StreamsOutbound: 4, Streams: 8, Memory: 16 << 20},
+		rcmgr.BaseLimitIncrease{},
+	)
+	config.AddServicePeerLimit(ServiceName,
+		rcmgr.BaseLimit{StreamsInbound: 1, StreamsOutbound: 1, Streams: 2, Memory: 4 << 20},
+		rcmgr.BaseLimitIncrease{},
+	)
+}
+
+var logger = log.Logger("perf")
+
+// Service answers the perf requests of other peers.
+type Service struct {
+	host host.Host
+}
+
+// NewService registers the perf handler on h.
+func NewService(h host.Host) *Service {
+	s := &Service{host: h}
+	h.SetStreamHandler(ID, s.handleStream)
+	return s
+}
+
+// Close removes the stream handler.
+func (s *Service) Close() error {
+	s.host.RemoveStreamHandler(ID)
+	return nil
+}
+
+func (s *Service) handleStream(str network.Stream) {
+	remote := str.Conn().RemotePeer()
+	if err := str.Scope().SetService(ServiceName); err != nil {
+		logger.Debugf("Perf request of %s: %v", remote, err)
+		str.Reset()
+		return
+	}
+	start := time.Now()
+	up, down, err := serve(str)
+	if err != nil {
+		logger.Warnf("Perf request of %s: %v", remote, err)
+		str.Reset()
+		return
+	}
+	str.Close()
+	logger.Infof("Perf request of %s: received %d bytes, sent %d bytes in %s", remote, up, down, time.Since(start))
+}
+
+func serve(s network.Stream) (uint64, uint64, error) {
+	var size [8]byte
+	if _, err := io.ReadFull(s, size[:]); err != nil {
+		return 0, 0, fmt.Errorf("read size: %w", err)
+	}
+	down := binary.BigEndian.Uint64(size[:])
+	if down > MaxBytes {
+		return 0, 0, fmt.Errorf("asked for %d bytes, more than %d", down, MaxBytes)
+	}
+	up, err := io.Copy(io.Discard, io.LimitReader(s, MaxBytes+1))
+	if err != nil {
+		return uint64(up), 0, fmt.Errorf("read upload: %w", err)
+	}
+	if up > MaxBytes {
+		return uint64(up), 0, fmt.Errorf("uploading more than %d bytes", MaxBytes)
+	}
+	if err := sendBytes(s, down); err != nil {
+		return uint64(up), 0, fmt.Errorf("send: %w", err)
+	}
+	return uint64(up), down, nil
+}
+
+var block = make([]byte, BlockSize)
+
+func sendBytes(w io.Writer, n uint64) error {
+	for n > 0 {
+		b := block
+		if n < uint64(len(b)) {
+			b = b[:n]
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		n -= uint64(len(b))
+	}
+	return nil
+}
+
+// Result is the outcome of one perf run.
+type Result struct {
+	Peer peer.ID
+	// Addr is the remote address of the connection, Transport its
+	// transport as named by netutil.Transport.
+	Addr      string
+	Transport string
+	Upload    uint64
+	Download  uint64
+	// TimeToFirstByte goes from opening the stream to the first downloaded
+	// byte, it is also the upload time.
+	TimeToFirstByte time.Duration
+	// DownloadTime goes from the first to the last downloaded byte.
+	DownloadTime time.Duration
+	Total        time.Duration
+	// UploadBps and DownloadBps are the throughputs in bits per second, 0
+	// when nothing was transferred that way.
+	UploadBps   float64
+	DownloadBps float64
+}
+
+// Run uploads upload bytes to p and downloads download bytes from it on a
+// new stream, both at most MaxBytes. The stream may use a relayed
+// connection.
+func Run(ctx context.Context, h host.Host, p peer.ID, upload, download uint64) (*Result, error) {
+	if upload > MaxBytes || download > MaxBytes {
+		return nil, fmt.Errorf("can't transfer more than %d bytes each way", MaxBytes)
+	}
+	start := time.Now()
+	s, err := h.NewStream(network.WithUseTransient(ctx, "perf"), p, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	if err := s.Scope().SetService(ServiceName); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	defer netutil.ResetOnDone(ctx, s)()
+
+	res := &Result{
+		Peer:      p,
+		Addr:      s.Conn().RemoteMultiaddr().String(),
+		Transport: netutil.Transport(s.Conn().RemoteMultiaddr()),
+		Upload:    upload,
+	}
+	if err := runStream(s, res, start, upload, download); err != nil {
+		s.Reset()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	res.Total = time.Since(start)
+	if res.Upload > 0 {
+		res.UploadBps = bitsPerSecond(res.Upload, res.TimeToFirstByte)
+	}
+	if res.Download > 0 {
+		res.DownloadBps = bitsPerSecond(res.Download, res.DownloadTime)
+	}
+	return res, nil
+}
+
+func runStream(s network.Stream, res *Result, start time.Time, upload, download uint64) error {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], download)
+	if _, err := s.Write(size[:]); err != nil {
+		return fmt.Errorf("write size: %w", err)
+	}
+	if err := sendBytes(s, upload); err != nil {
+		return fmt.Errorf("upload: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		return fmt.Errorf("close upload: %w", err)
+	}
+
+	buf := make([]byte, BlockSize)
+	var first time.Time
+	for {
+		n, err := s.Read(buf)
+		if n > 0 && first.IsZero() {
+			first = time.Now()
+			res.TimeToFirstByte = first.Sub(start)
+		}
+		res.Download += uint64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+	}
+	if first.IsZero() {
+		res.TimeToFirstByte = time.Since(start)
+	} else {
+		res.DownloadTime = time.Since(first)
+	}
+	if res.Download != download {
+		return fmt.Errorf("downloaded %d bytes, expected %d", res.Download, download)
+	}
+	return nil
+}
+
+func bitsPerSecond(n uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / d.Seconds()
+}
+
+// RunAddr runs perf with the peer of the /p2p multiaddr addr over a
+// connection to addr only, closing the other connections to the peer first,
+// so that the results of different transports can be compared.
+func RunAddr(ctx context.Context, h host.Host, addr multiaddr.Multiaddr, upload, download uint64) (*Result, error) {
+	ai, err := peer.AddrInfoFromP2pAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ai.ID == h.ID() {
+		return nil, errors.New("can't run perf with self")
+	}
+	h.Network().ClosePeer(ai.ID)
+	h.Peerstore().ClearAddrs(ai.ID)
+	if err := h.Connect(ctx, *ai); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	return Run(ctx, h, ai.ID, upload, download)
+}
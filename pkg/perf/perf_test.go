@@ -0,0 +1,153 @@
+package perf
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/nettest"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func addrOf(t *testing.T, h host.Host, code int) multiaddr.Multiaddr {
+	t.Helper()
+	info := nettest.Info(h)
+	addrs, _ := peer.AddrInfoToP2pAddrs(&info)
+	for _, a := range addrs {
+		if _, err := a.ValueForProtocol(code); err == nil {
+			return a
+		}
+	}
+	t.Fatalf("no address with protocol %d in %v", code, addrs)
+	return nil
+}
+
+func TestRunAddr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	server := nettest.NewHost(t)
+	NewService(server)
+	c := nettest.NewHost(t)
+
+	res, err := RunAddr(ctx, c, addrOf(t, server, multiaddr.P_TCP), 1<<20, 2<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Transport != "tcp" || res.Upload != 1<<20 || res.Download != 2<<20 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if res.TimeToFirstByte <= 0 || res.UploadBps <= 0 || res.DownloadBps <= 0 || res.Total < res.TimeToFirstByte {
+		t.Errorf("unexpected timings %+v", res)
+	}
+
+	// Nothing either way is a valid run, only measuring the round trip.
+	res, err = Run(ctx, c, server.ID(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.UploadBps != 0 || res.DownloadBps != 0 {
+		t.Errorf("unexpected throughput %+v", res)
+	}
+}
+
+func TestRunRelayed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	r := nettest.NewHost(t, libp2p.EnableRelayService(relay.WithResources(relay.DefaultResources())), libp2p.ForceReachabilityPublic())
+	server := nettest.NewHost(t)
+	NewService(server)
+	c := nettest.NewHost(t)
+
+	nettest.Connect(t, server, r)
+	if _, err := client.Reserve(ctx, server, nettest.Info(r)); err != nil {
+		t.Fatal(err)
+	}
+	circuit, err := multiaddr.NewMultiaddr("/p2p-circuit/p2p/" + server.ID().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := addrOf(t, r, multiaddr.P_TCP).Encapsulate(circuit)
+
+	// Relays limit the data of a relayed connection, 128 KiB by default.
+	res, err := RunAddr(ctx, c, addr, 32<<10, 64<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Transport != "p2p-circuit" || res.Upload != 32<<10 || res.Download != 64<<10 {
+		t.Errorf("unexpected result %+v", res)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	server := nettest.NewHost(t)
+	NewService(server)
+	c := nettest.NewHost(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := RunAddr(ctx, c, addrOf(t, server, multiaddr.P_TCP), 0, MaxBytes); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	limits := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&limits)
+	SetServiceLimits(&limits)
+	rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.AutoScale()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := nettest.NewHost(t, libp2p.ResourceManager(rm))
+	NewService(server)
+	c := nettest.NewHost(t)
+
+	if _, err := Run(ctx, c, server.ID(), 0, MaxBytes+1); err == nil {
+		t.Error("Run accepted more than MaxBytes")
+	}
+	nettest.Connect(t, c, server)
+	// A client ignoring MaxBytes gets the stream reset.
+	s, err := c.NewStream(ctx, server.ID(), ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], MaxBytes+1)
+	s.Write(size[:])
+	s.CloseWrite()
+	if _, err := io.ReadAll(s); err == nil {
+		t.Error("server sent more than MaxBytes")
+	}
+
+	// A peer runs one at a time.
+	first, err := c.NewStream(ctx, server.ID(), ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Reset()
+	// Still uploading.
+	binary.BigEndian.PutUint64(size[:], 1)
+	first.Write(size[:])
+	for inbound := 0; inbound == 0; {
+		rm.ViewService(ServiceName, func(s network.ServiceScope) error {
+			inbound = s.Stat().NumStreamsInbound
+			return nil
+		})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := Run(ctx, c, server.ID(), 0, 1); err == nil {
+		t.Error("second run of the peer accepted")
+	}
+}
@@ -0,0 +1,207 @@
+// Package pinger sends libp2p pings at a fixed interval, like ping(8), and
+// summarizes the round trip times.
+package pinger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"math"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// Default options, as ping(8).
+const (
+	DefaultCount    = 5
+	DefaultInterval = time.Second
+	DefaultTimeout  = 5 * time.Second
+)
+
+// ErrTimeout is the error of a ping without a reply within Options.Timeout.
+var ErrTimeout = errors.New("timeout")
+
+// Options of Run. Count 0 pings until the context is done. KeepResults
+// keeps every Result in Stats.Results, e.g. for a JSON report; otherwise
+// the memory of a run doesn't grow with Count.
+type Options struct {
+	Count       int
+	Interval    time.Duration
+	Timeout     time.Duration
+	KeepResults bool
+}
+
+// Result is the outcome of one ping.
+type Result struct {
+	Seq     int
+	RTT     time.Duration `json:",omitempty"`
+	Error   string        `json:",omitempty"`
+	Relayed bool
+}
+
+// Stats summarizes a run.
+type Stats struct {
+	Peer     peer.ID
+	Sent     int
+	Received int
+	// Loss is the percentage of pings without a reply.
+	Loss   float64
+	Min    time.Duration
+	Avg    time.Duration
+	Max    time.Duration
+	StdDev time.Duration
+	// Relayed counts the replies received over a relayed connection.
+	Relayed int
+	// Results holds every ping with Options.KeepResults only.
+	Results []Result `json:",omitempty"`
+
+	// sum and sumSq of the replies' RTTs, in nanoseconds.
+	sum, sumSq float64
+}
+
+// Add records r in the summary of s, without keeping r itself.
+func (s *Stats) Add(r Result) {
+	s.Sent++
+	if r.Error == "" {
+		s.Received++
+		if r.Relayed {
+			s.Relayed++
+		}
+		if s.Received == 1 || r.RTT < s.Min {
+			s.Min = r.RTT
+		}
+		if r.RTT > s.Max {
+			s.Max = r.RTT
+		}
+		s.sum += float64(r.RTT)
+		s.sumSq += float64(r.RTT) * float64(r.RTT)
+	}
+	s.Loss = 100 * float64(s.Sent-s.Received) / float64(s.Sent)
+	if s.Received > 0 {
+		avg := s.sum / float64(s.Received)
+		s.Avg = time.Duration(avg)
+		s.StdDev = time.Duration(math.Sqrt(math.Max(0, s.sumSq/float64(s.Received)-avg*avg)))
+	}
+}
+
+// Run pings p every opts.Interval, calling onResult after each ping when not
+// nil. A ping without a reply within opts.Timeout counts as lost, the next
+// one is sent on a new stream. Run returns when opts.Count pings were sent
+// or ctx is done.
+func Run(ctx context.Context, h host.Host, p peer.ID, opts Options, onResult func(Result)) *Stats {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	stats := &Stats{Peer: p}
+	t := time.NewTicker(opts.Interval)
+	defer t.Stop()
+
+	var s network.Stream
+	defer func() {
+		if s != nil {
+			s.Close()
+		}
+	}()
+	for seq := 1; opts.Count == 0 || seq <= opts.Count; seq++ {
+		if seq > 1 {
+			select {
+			case <-ctx.Done():
+				return stats
+			case <-t.C:
+			}
+		}
+		r := Result{Seq: seq}
+		var err error
+		if s == nil {
+			s, err = newStream(ctx, h, p, opts.Timeout)
+		}
+		if err == nil {
+			r.Relayed = netutil.IsRelayed(s.Conn().RemoteMultiaddr())
+			r.RTT, err = pingOnce(s, opts.Timeout)
+		}
+		if err == nil {
+			h.Peerstore().RecordLatency(p, r.RTT)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return stats
+			}
+			r.Error = err.Error()
+			if s != nil {
+				s.Reset()
+				s = nil
+			}
+		}
+		stats.Add(r)
+		if opts.KeepResults {
+			stats.Results = append(stats.Results, r)
+		}
+		if onResult != nil {
+			onResult(r)
+		}
+	}
+	return stats
+}
+
+func newStream(ctx context.Context, h host.Host, p peer.ID, timeout time.Duration) (network.Stream, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return h.NewStream(network.WithUseTransient(ctx, "ping"), p, ping.ID)
+}
+
+// pingOnce sends one ping on s, as ping.Ping does. Not every transport
+// honours stream deadlines, so s is reset when the reply doesn't come within
+// timeout and must not be used again after an error.
+func pingOnce(s network.Stream, timeout time.Duration) (time.Duration, error) {
+	buf := make([]byte, ping.PingSize)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, err
+	}
+	s.SetDeadline(time.Now().Add(timeout))
+	defer s.SetDeadline(time.Time{})
+
+	type reply struct {
+		rtt time.Duration
+		err error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		before := time.Now()
+		if _, err := s.Write(buf); err != nil {
+			done <- reply{err: err}
+			return
+		}
+		data := make([]byte, ping.PingSize)
+		if _, err := io.ReadFull(s, data); err != nil {
+			done <- reply{err: err}
+			return
+		}
+		rtt := time.Since(before)
+		if !bytes.Equal(buf, data) {
+			done <- reply{err: errors.New("ping packet was incorrect")}
+			return
+		}
+		done <- reply{rtt: rtt}
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-done:
+		return r.rtt, r.err
+	case <-t.C:
+		s.Reset()
+		return 0, ErrTimeout
+	}
+}
@@ -0,0 +1,83 @@
+package pinger
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+func TestStats(t *testing.T) {
+	var s Stats
+	for i, rtt := range []time.Duration{2 * time.Millisecond, 4 * time.Millisecond, 0} {
+		r := Result{Seq: i + 1, RTT: rtt}
+		if rtt == 0 {
+			r.Error = "timeout"
+		}
+		s.Add(r)
+	}
+	if s.Sent != 3 || s.Received != 2 {
+		t.Errorf("sent %d, received %d", s.Sent, s.Received)
+	}
+	if s.Min != 2*time.Millisecond || s.Max != 4*time.Millisecond || s.Avg != 3*time.Millisecond || s.StdDev != time.Millisecond {
+		t.Errorf("min %s avg %s max %s stddev %s", s.Min, s.Avg, s.Max, s.StdDev)
+	}
+	if s.Loss < 33.3 || s.Loss > 33.4 {
+		t.Errorf("loss %.2f%%", s.Loss)
+	}
+}
+
+func TestRun(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	a, b := mn.Hosts()[0], mn.Hosts()[1]
+	ping.NewPingService(b)
+
+	var seqs []int
+	stats := Run(context.Background(), a, b.ID(), Options{Count: 3, Interval: 10 * time.Millisecond}, func(r Result) {
+		seqs = append(seqs, r.Seq)
+	})
+	if stats.Received != 3 || stats.Loss != 0 || len(seqs) != 3 || stats.Relayed != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Min <= 0 || stats.Max < stats.Min {
+		t.Errorf("min %s max %s", stats.Min, stats.Max)
+	}
+	if stats.Results != nil {
+		t.Errorf("results kept without KeepResults: %+v", stats.Results)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mn.Close()
+	a, b := mn.Hosts()[0], mn.Hosts()[1]
+	// Answer the first ping of every stream only.
+	b.SetStreamHandler(ping.ID, func(s network.Stream) {
+		defer s.Reset()
+		buf := make([]byte, ping.PingSize)
+		if _, err := io.ReadFull(s, buf); err != nil {
+			return
+		}
+		s.Write(buf)
+		io.Copy(io.Discard, s)
+	})
+
+	stats := Run(context.Background(), a, b.ID(), Options{Count: 4, Interval: 10 * time.Millisecond, Timeout: 100 * time.Millisecond, KeepResults: true}, nil)
+	if stats.Sent != 4 || stats.Received != 2 || stats.Loss != 50 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if stats.Results[1].Error == "" || stats.Results[2].Error != "" {
+		t.Errorf("unexpected results %+v", stats.Results)
+	}
+}
@@ -0,0 +1,267 @@
+// Package portmap wraps the UPnP and NAT-PMP port mapping of go-libp2p to
+// tell whether a gateway was found, which external addresses were mapped,
+// and when a mapping is lost.
+package portmap
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/multiformats/go-multiaddr"
+)
+
+var logger = log.Logger("portmap")
+
+// DefaultInterval is how often the mappings are checked. It only reads the
+// state of go-libp2p, which renews the mappings with the gateway itself.
+const DefaultInterval = 10 * time.Second
+
+// MaxEvents is the number of events kept for Status.
+const MaxEvents = 50
+
+// go-libp2p gives up looking for a gateway after 10 seconds.
+const discoveryTimeout = 15 * time.Second
+
+// The states of Status.Gateway.
+const (
+	GatewayDisabled    = "disabled"
+	GatewayDiscovering = "discovering"
+	GatewayFound       = "found"
+	GatewayNotFound    = "not found"
+)
+
+// The types of Event.
+const (
+	EventMapped  = "mapped"
+	EventChanged = "changed"
+	EventLost    = "lost"
+)
+
+// Event is emitted on the event bus of the host when a mapping is created,
+// changes its external address or is lost while still listening.
+type Event struct {
+	Time     time.Time
+	Type     string
+	Internal string
+	External string `json:",omitempty"`
+	// Previous is the external address before a change or loss.
+	Previous string `json:",omitempty"`
+}
+
+// Mapping maps a listen address to an external address of the gateway.
+type Mapping struct {
+	Internal string
+	External string
+	Since    time.Time
+}
+
+// Status is returned by the stats/portmap command.
+type Status struct {
+	Gateway  string
+	Mappings []Mapping
+	// Events are the last MaxEvents events, oldest first.
+	Events []Event
+}
+
+// Tracker follows the port mappings of a host. Pass Option to libp2p.New,
+// instead of libp2p.NATPortMap, and call Start with the host.
+type Tracker struct {
+	interval time.Duration
+	// newNAT is basichost.NewNATManager, replaced by tests.
+	newNAT func(network.Network) basichost.NATManager
+
+	mu       sync.Mutex
+	nat      basichost.NATManager
+	net      network.Network
+	started  time.Time
+	gateway  string
+	mappings map[string]Mapping
+	events   []Event
+	emitter  event.Emitter
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New returns a Tracker checking the mappings every interval.
+func New(interval time.Duration) *Tracker {
+	return &Tracker{
+		interval: interval,
+		newNAT:   basichost.NewNATManager,
+		gateway:  GatewayDisabled,
+		mappings: make(map[string]Mapping),
+	}
+}
+
+// Option enables port mapping followed by t.
+func (t *Tracker) Option() libp2p.Option {
+	return libp2p.NATManager(func(n network.Network) basichost.NATManager {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.nat = t.newNAT(n)
+		t.net = n
+		t.started = time.Now()
+		t.gateway = GatewayDiscovering
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+		go t.run()
+		return (*natManager)(t)
+	})
+}
+
+// Start emits the events on the event bus of h.
+func (t *Tracker) Start(h host.Host) error {
+	em, err := h.EventBus().Emitter(new(Event))
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.emitter = em
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *Tracker) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.check()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// check compares the mappings with the last ones.
+func (t *Tracker) check() {
+	discovered := t.nat.HasDiscoveredNAT()
+	listening := make(map[string]bool)
+	current := make(map[string]string)
+	for _, a := range t.net.ListenAddresses() {
+		// Only the TCP and UDP ports are mapped.
+		if _, err := a.ValueForProtocol(multiaddr.P_CIRCUIT); err == nil {
+			continue
+		}
+		listening[a.String()] = true
+		if !discovered {
+			continue
+		}
+		if ext := t.nat.GetMapping(a); ext != nil {
+			current[a.String()] = ext.String()
+		}
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	switch {
+	case discovered && t.gateway != GatewayFound:
+		t.gateway = GatewayFound
+		logger.Info("Found a UPnP or NAT-PMP gateway")
+	case !discovered && t.gateway == GatewayDiscovering && now.Sub(t.started) > discoveryTimeout:
+		t.gateway = GatewayNotFound
+		logger.Warn("No UPnP or NAT-PMP gateway found, the node is only dialable directly with a public IP or a manual port forward")
+	}
+	var events []Event
+	for internal, ext := range current {
+		m, ok := t.mappings[internal]
+		switch {
+		case !ok:
+			events = append(events, Event{Time: now, Type: EventMapped, Internal: internal, External: ext})
+		case m.External != ext:
+			events = append(events, Event{Time: now, Type: EventChanged, Internal: internal, External: ext, Previous: m.External})
+		default:
+			continue
+		}
+		t.mappings[internal] = Mapping{Internal: internal, External: ext, Since: now}
+	}
+	for internal, m := range t.mappings {
+		if _, ok := current[internal]; ok {
+			continue
+		}
+		delete(t.mappings, internal)
+		// Closing a listener removes its mapping, that isn't a loss.
+		if listening[internal] {
+			events = append(events, Event{Time: now, Type: EventLost, Internal: internal, Previous: m.External})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Internal < events[j].Internal })
+	t.events = append(t.events, events...)
+	if len(t.events) > MaxEvents {
+		t.events = append([]Event(nil), t.events[len(t.events)-MaxEvents:]...)
+	}
+	em := t.emitter
+	t.mu.Unlock()
+
+	for _, e := range events {
+		switch e.Type {
+		case EventMapped:
+			logger.Infof("Port mapping %s -> %s", e.Internal, e.External)
+		case EventChanged:
+			logger.Infof("Port mapping %s changed from %s to %s", e.Internal, e.Previous, e.External)
+		case EventLost:
+			logger.Warnf("Port mapping %s -> %s lost", e.Internal, e.Previous)
+		}
+		if em != nil {
+			if err := em.Emit(e); err != nil {
+				logger.Debugf("Emit port mapping event: %v", err)
+			}
+		}
+	}
+}
+
+// Status returns the gateway state, the mappings and the last events.
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Status{Gateway: t.gateway, Events: append([]Event(nil), t.events...)}
+	for _, m := range t.mappings {
+		s.Mappings = append(s.Mappings, m)
+	}
+	sort.Slice(s.Mappings, func(i, j int) bool { return s.Mappings[i].Internal < s.Mappings[j].Internal })
+	return s
+}
+
+// RegisterAPI adds stats/portmap, returning Status, to srv.
+func (t *Tracker) RegisterAPI(srv *api.Server) {
+	srv.HandleFunc("stats/portmap", func(r *http.Request) (interface{}, error) {
+		return t.Status(), nil
+	})
+}
+
+// natManager is the basichost.NATManager given to the host.
+type natManager Tracker
+
+func (m *natManager) GetMapping(a multiaddr.Multiaddr) multiaddr.Multiaddr {
+	return m.nat.GetMapping(a)
+}
+
+func (m *natManager) HasDiscoveredNAT() bool {
+	return m.nat.HasDiscoveredNAT()
+}
+
+// Close stops following the mappings and removes them from the gateway.
+func (m *natManager) Close() error {
+	close(m.stop)
+	<-m.done
+	err := m.nat.Close()
+	t := (*Tracker)(m)
+	t.mu.Lock()
+	if em := t.emitter; em != nil {
+		em.Close()
+		t.emitter = nil
+	}
+	t.mu.Unlock()
+	return err
+}
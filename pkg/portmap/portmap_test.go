@@ -0,0 +1,113 @@
+package portmap
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	basichost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// fakeNAT maps the listen addresses to external set by the test.
+type fakeNAT struct {
+	mu         sync.Mutex
+	discovered bool
+	external   string
+	closed     bool
+}
+
+func (f *fakeNAT) set(discovered bool, external string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.discovered, f.external = discovered, external
+}
+
+func (f *fakeNAT) GetMapping(a multiaddr.Multiaddr) multiaddr.Multiaddr {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := a.ValueForProtocol(multiaddr.P_TCP); err != nil || !f.discovered || f.external == "" {
+		return nil
+	}
+	return multiaddr.StringCast(f.external)
+}
+
+func (f *fakeNAT) HasDiscoveredNAT() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.discovered
+}
+
+func (f *fakeNAT) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	nat := new(fakeNAT)
+	tr := New(10 * time.Millisecond)
+	tr.newNAT = func(network.Network) basichost.NATManager { return nat }
+	if s := tr.Status(); s.Gateway != GatewayDisabled {
+		t.Errorf("gateway %q before the host", s.Gateway)
+	}
+
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), tr.Option())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Start(h); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := h.EventBus().Subscribe(new(Event))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	var internal string
+	for _, a := range h.Network().ListenAddresses() {
+		if _, err := a.ValueForProtocol(multiaddr.P_TCP); err == nil {
+			internal = a.String()
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if s := tr.Status(); s.Gateway != GatewayDiscovering || len(s.Mappings) != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	next := func(typ, external, previous string) {
+		t.Helper()
+		select {
+		case e := <-sub.Out():
+			ev := e.(Event)
+			if ev.Type != typ || ev.Internal != internal || ev.External != external || ev.Previous != previous {
+				t.Errorf("got %+v, want %s %s -> %s (was %s)", ev, typ, internal, external, previous)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", typ)
+		}
+	}
+	nat.set(true, "/ip4/1.2.3.4/tcp/4001")
+	next(EventMapped, "/ip4/1.2.3.4/tcp/4001", "")
+	s := tr.Status()
+	if s.Gateway != GatewayFound || len(s.Mappings) != 1 || s.Mappings[0].Internal != internal || s.Mappings[0].External != "/ip4/1.2.3.4/tcp/4001" {
+		t.Errorf("unexpected status %+v", s)
+	}
+	nat.set(true, "/ip4/1.2.3.4/tcp/4002")
+	next(EventChanged, "/ip4/1.2.3.4/tcp/4002", "/ip4/1.2.3.4/tcp/4001")
+	nat.set(true, "")
+	next(EventLost, "", "/ip4/1.2.3.4/tcp/4002")
+
+	s = tr.Status()
+	if len(s.Mappings) != 0 || len(s.Events) != 3 {
+		t.Errorf("unexpected status %+v", s)
+	}
+	h.Close()
+	if !nat.closed {
+		t.Error("NAT manager not closed with the host")
+	}
+}
@@ -0,0 +1,206 @@
+package reachability
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Report is returned by the stats/reachability command.
+type Report struct {
+	Mode Mode
+	// Reachability is the one of AutoNAT, or the one assumed by Mode.
+	Reachability string
+	// Addrs are the results of the last dial back check by Server, at
+	// Checked.
+	Addrs   []Result
+	Server  peer.ID   `json:",omitempty"`
+	Checked time.Time `json:",omitempty"`
+	Error   string    `json:",omitempty"`
+}
+
+// Checker has the public addresses of a host checked by the dial back
+// servers, when started and whenever they change.
+type Checker struct {
+	host    host.Host
+	servers []peer.AddrInfo
+	// allowPrivate lets tests check loopback addresses.
+	allowPrivate bool
+
+	mu     sync.Mutex
+	report Report
+	// checked are the addresses of the last check.
+	checked string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewChecker returns a Checker of h asking servers, usually the bootstrap
+// peers, in turn.
+func NewChecker(h host.Host, mode Mode, servers []peer.AddrInfo) *Checker {
+	return &Checker{
+		host:    h,
+		servers: servers,
+		report:  Report{Mode: mode, Reachability: network.ReachabilityUnknown.String()},
+	}
+}
+
+// Start checks the addresses every interval and when they change, until
+// Close.
+func (c *Checker) Start(interval time.Duration) error {
+	sub, err := c.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		defer sub.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		if err := c.Check(ctx); err != nil {
+			logger.Warnf("Check addresses: %v", err)
+		}
+		for {
+			select {
+			case e := <-sub.Out():
+				if evt, ok := e.(event.EvtLocalReachabilityChanged); ok {
+					c.mu.Lock()
+					c.report.Reachability = evt.Reachability.String()
+					c.mu.Unlock()
+					logger.Infof("Reachability %s", evt.Reachability)
+					continue
+				}
+				c.mu.Lock()
+				unchanged := c.checked == addrsKey(c.Addrs())
+				c.mu.Unlock()
+				if unchanged {
+					continue
+				}
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if err := c.Check(ctx); err != nil {
+				logger.Warnf("Check addresses: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the checks.
+func (c *Checker) Close() {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+	}
+}
+
+// Addrs returns the addresses worth checking: the public listen and
+// observed addresses, without the relayed ones.
+func (c *Checker) Addrs() []multiaddr.Multiaddr {
+	all := c.host.Addrs()
+	if ids, ok := c.host.(interface{ IDService() identify.IDService }); ok {
+		all = append(all, ids.IDService().OwnObservedAddrs()...)
+	}
+	var addrs []multiaddr.Multiaddr
+	seen := make(map[string]bool)
+	for _, a := range all {
+		if seen[string(a.Bytes())] || netutil.IsRelayed(a) || !(c.allowPrivate || manet.IsPublicAddr(a)) {
+			continue
+		}
+		seen[string(a.Bytes())] = true
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+func addrsKey(addrs []multiaddr.Multiaddr) string {
+	var key string
+	for _, a := range addrs {
+		key += a.String() + " "
+	}
+	return key
+}
+
+// Check has the addresses checked by the first server answering.
+func (c *Checker) Check(ctx context.Context) error {
+	addrs := c.Addrs()
+	c.mu.Lock()
+	c.checked = addrsKey(addrs)
+	c.mu.Unlock()
+	if len(addrs) == 0 {
+		c.setResults(nil, "", nil)
+		return nil
+	}
+	err := errors.New("no dial back server")
+	for _, s := range c.servers {
+		sctx, cancel := context.WithTimeout(ctx, time.Duration(len(addrs)+1)*DialTimeout)
+		var results []Result
+		if err = c.host.Connect(sctx, s); err == nil {
+			results, err = Check(sctx, c.host, s.ID, addrs)
+		}
+		cancel()
+		if err == nil {
+			c.setResults(results, s.ID, nil)
+			for _, r := range results {
+				if r.Reachable {
+					logger.Infof("%s is reachable", r.Addr)
+				} else {
+					logger.Infof("%s is not reachable: %s", r.Addr, r.Error)
+				}
+			}
+			return nil
+		}
+		logger.Debugf("Dial back server %s: %v", s.ID, err)
+	}
+	c.setResults(nil, "", err)
+	return err
+}
+
+func (c *Checker) setResults(results []Result, server peer.ID, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.report.Addrs = results
+	c.report.Server = server
+	c.report.Checked = time.Now()
+	c.report.Error = ""
+	if err != nil {
+		c.report.Error = err.Error()
+	}
+}
+
+// Report returns the last results.
+func (c *Checker) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := c.report
+	r.Addrs = append([]Result(nil), c.report.Addrs...)
+	return r
+}
+
+// RegisterAPI adds stats/reachability, returning Report, to srv.
+func (c *Checker) RegisterAPI(srv *api.Server) {
+	srv.HandleFunc("stats/reachability", func(r *http.Request) (interface{}, error) {
+		return c.Report(), nil
+	})
+}
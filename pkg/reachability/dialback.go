@@ -0,0 +1,256 @@
+package reachability
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// ID is the dial back protocol. The client writes a Request as JSON and
+// closes its side of the stream, the server dials each address from its
+// dialer host and answers with a Response.
+const ID = protocol.ID("/libp2p-node/dialback/1.0.0")
+
+// MaxAddrs is the number of addresses checked per request.
+const MaxAddrs = 8
+
+// DialTimeout bounds the dial of one address.
+const DialTimeout = 15 * time.Second
+
+const maxMessageSize = 64 << 10
+
+// ErrRateLimited is returned when the server refused the request.
+var ErrRateLimited = errors.New("rate limited")
+
+// Request lists the addresses to check.
+type Request struct {
+	Addrs []string
+}
+
+// Response holds a Result per address of the Request, or the Error refusing
+// it.
+type Response struct {
+	Results []Result `json:",omitempty"`
+	Error   string   `json:",omitempty"`
+}
+
+// Result is the outcome of dialing one address.
+type Result struct {
+	Addr      string
+	Reachable bool
+	Error     string `json:",omitempty"`
+}
+
+// Server answers the dial back requests of other peers.
+type Server struct {
+	host   host.Host
+	dialer host.Host
+	limits Limits
+	// allowPrivate lets tests check loopback addresses.
+	allowPrivate bool
+
+	mu      sync.Mutex
+	reset   time.Time
+	global  int
+	perPeer map[peer.ID]int
+	// dialing serializes the requests of each peer, the dials of the
+	// dialer to the same peer would share a connection.
+	dialing map[peer.ID]*peerDials
+}
+
+type peerDials struct {
+	sync.Mutex
+	refs int
+}
+
+// NewServer registers the dial back handler on h, dialing from dialer as
+// returned by NewDialer.
+func NewServer(h, dialer host.Host, limits Limits) (*Server, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		host:    h,
+		dialer:  dialer,
+		limits:  limits,
+		perPeer: make(map[peer.ID]int),
+		dialing: make(map[peer.ID]*peerDials),
+	}
+	h.SetStreamHandler(ID, s.handleStream)
+	return s, nil
+}
+
+// Close removes the stream handler.
+func (s *Server) Close() error {
+	s.host.RemoveStreamHandler(ID)
+	return nil
+}
+
+// allow counts a request of p against the limits.
+func (s *Server) allow(p peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.After(s.reset) {
+		s.reset = now.Add(s.limits.Interval)
+		s.global = 0
+		s.perPeer = make(map[peer.ID]int)
+	}
+	if s.global >= s.limits.Global || s.perPeer[p] >= s.limits.PerPeer {
+		return false
+	}
+	s.global++
+	s.perPeer[p]++
+	return true
+}
+
+// lockPeer waits for the other requests of p, call the returned function
+// when done.
+func (s *Server) lockPeer(p peer.ID) func() {
+	s.mu.Lock()
+	d := s.dialing[p]
+	if d == nil {
+		d = new(peerDials)
+		s.dialing[p] = d
+	}
+	d.refs++
+	s.mu.Unlock()
+	d.Lock()
+	return func() {
+		d.Unlock()
+		s.mu.Lock()
+		if d.refs--; d.refs == 0 {
+			delete(s.dialing, p)
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *Server) handleStream(str network.Stream) {
+	defer str.Close()
+	str.SetDeadline(time.Now().Add(MaxAddrs*DialTimeout + time.Minute))
+	remote := str.Conn().RemotePeer()
+	resp, err := s.serve(str)
+	if err != nil {
+		logger.Debugf("Dial back request of %s refused: %v", remote, err)
+		resp = &Response{Error: err.Error()}
+	}
+	if err := json.NewEncoder(str).Encode(resp); err != nil {
+		logger.Debugf("Dial back response to %s: %v", remote, err)
+		str.Reset()
+	}
+}
+
+func (s *Server) serve(str network.Stream) (*Response, error) {
+	var req Request
+	if err := json.NewDecoder(io.LimitReader(str, maxMessageSize)).Decode(&req); err != nil {
+		return nil, fmt.Errorf("read request: %w", err)
+	}
+	conn := str.Conn()
+	if !s.allow(conn.RemotePeer()) {
+		return nil, ErrRateLimited
+	}
+	// Only the addresses of the IP the request comes from are dialed, the
+	// server can't be used to dial third parties.
+	if netutil.IsRelayed(conn.RemoteMultiaddr()) {
+		return nil, errors.New("relayed connection, the IP is unknown")
+	}
+	ip, err := manet.ToIP(conn.RemoteMultiaddr())
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Addrs) > MaxAddrs {
+		req.Addrs = req.Addrs[:MaxAddrs]
+	}
+	defer s.lockPeer(conn.RemotePeer())()
+	resp := &Response{}
+	for _, a := range req.Addrs {
+		res := Result{Addr: a}
+		if err := s.check(conn.RemotePeer(), ip, a); err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Reachable = true
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	logger.Infof("Checked %d addresses of %s", len(resp.Results), conn.RemotePeer())
+	return resp, nil
+}
+
+func (s *Server) check(p peer.ID, ip net.IP, a string) error {
+	addr, err := multiaddr.NewMultiaddr(a)
+	if err != nil {
+		return err
+	}
+	if netutil.IsRelayed(addr) {
+		return errors.New("relayed address")
+	}
+	addrIP, err := manet.ToIP(addr)
+	if err != nil {
+		return err
+	}
+	if !s.allowPrivate && !manet.IsPublicAddr(addr) {
+		return errors.New("not a public address")
+	}
+	if !addrIP.Equal(ip) {
+		return fmt.Errorf("the IP differs from the one of the connection, %s", ip)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DialTimeout)
+	defer cancel()
+	s.dialer.Peerstore().ClearAddrs(p)
+	defer s.dialer.Network().ClosePeer(p)
+	return s.dialer.Connect(ctx, peer.AddrInfo{ID: p, Addrs: []multiaddr.Multiaddr{addr}})
+}
+
+// Check asks server to dial each of addrs. The connection to server must be
+// direct, and the addresses use its IP.
+func Check(ctx context.Context, h host.Host, server peer.ID, addrs []multiaddr.Multiaddr) ([]Result, error) {
+	s, err := h.NewStream(ctx, server, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	defer netutil.ResetOnDone(ctx, s)()
+
+	req := Request{}
+	for _, a := range addrs {
+		req.Addrs = append(req.Addrs, a.String())
+	}
+	if err := json.NewEncoder(s).Encode(req); err != nil {
+		s.Reset()
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	var resp Response
+	if err := json.NewDecoder(io.LimitReader(s, maxMessageSize)).Decode(&resp); err != nil {
+		s.Reset()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.Error == ErrRateLimited.Error() {
+		return nil, ErrRateLimited
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Results, nil
+}
@@ -0,0 +1,106 @@
+// Package reachability configures how a node learns whether it can be
+// dialed: the reachability mode, the rate limits of the AutoNAT service it
+// runs for others, and a dial back protocol checking each address on its own.
+//
+// AutoNAT v1, the only version in our go-libp2p, answers for the node as a
+// whole: the server dials all the addresses and reports success if any
+// works. The dial back protocol of this package reports a result per
+// address, as AutoNAT v2 does, until go-libp2p is upgraded to a version
+// shipping it.
+package reachability
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+var logger = log.Logger("reachability")
+
+// Mode tells whether the reachability is detected by AutoNAT or assumed.
+type Mode string
+
+// The reachability modes.
+const (
+	// ModeAuto asks the AutoNAT servers of other peers.
+	ModeAuto Mode = "auto"
+	// ModePublic assumes a public address, e.g. on a server.
+	ModePublic Mode = "public"
+	// ModePrivate assumes a NAT, getting relay reservations right away.
+	ModePrivate Mode = "private"
+)
+
+// ParseMode parses auto, public or private.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeAuto, ModePublic, ModePrivate:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid reachability mode %q, expected auto, public or private", s)
+}
+
+// Option configures the host for m.
+func (m Mode) Option() libp2p.Option {
+	switch m {
+	case ModePublic:
+		return libp2p.ForceReachabilityPublic()
+	case ModePrivate:
+		return libp2p.ForceReachabilityPrivate()
+	}
+	return func(*libp2p.Config) error { return nil }
+}
+
+// Limits rate limit the AutoNAT service and the dial back server: at most
+// Global requests, and PerPeer requests of a peer, per Interval.
+type Limits struct {
+	Global   int
+	PerPeer  int
+	Interval time.Duration
+}
+
+// DefaultLimits are those of go-libp2p.
+var DefaultLimits = Limits{Global: 30, PerPeer: 3, Interval: time.Minute}
+
+// Validate checks that l limits anything: with a zero Interval the counts
+// would start over on every request.
+func (l Limits) Validate() error {
+	switch {
+	case l.Global <= 0 || l.PerPeer <= 0:
+		return fmt.Errorf("the request limits must be positive, got %d and %d per peer", l.Global, l.PerPeer)
+	case l.Interval <= 0:
+		return fmt.Errorf("the limit interval must be positive, got %s", l.Interval)
+	}
+	return nil
+}
+
+// ServiceOptions enable the AutoNAT service with the limits l.
+func ServiceOptions(l Limits) []libp2p.Option {
+	return []libp2p.Option{
+		libp2p.EnableNATService(),
+		libp2p.AutoNATServiceRateLimit(l.Global, l.PerPeer, l.Interval),
+	}
+}
+
+// NewDialer returns the host the dial back server dials from. It has its own
+// identity and no listen address, so that its dials never reuse a
+// connection of the node.
+func NewDialer(psk pnet.PSK) (host.Host, error) {
+	opts := []libp2p.Option{
+		libp2p.RandomIdentity,
+		libp2p.NoListenAddrs,
+		libp2p.DisableRelay(),
+		libp2p.Ping(false),
+		libp2p.DefaultMuxers,
+		libp2p.DefaultSecurity,
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.DefaultPrivateTransports, libp2p.PrivateNetwork(psk))
+	} else {
+		opts = append(opts, libp2p.DefaultTransports)
+	}
+	return libp2p.New(opts...)
+}
@@ -0,0 +1,141 @@
+package reachability
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/nettest"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func newServer(t *testing.T, limits Limits) (*Server, host.Host) {
+	t.Helper()
+	h := nettest.NewHost(t)
+	dialer, err := NewDialer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dialer.Close() })
+	s, err := NewServer(h, dialer, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.allowPrivate = true
+	t.Cleanup(func() { s.Close() })
+	return s, h
+}
+
+func TestParseMode(t *testing.T) {
+	for _, s := range []string{"auto", "public", "private"} {
+		m, err := ParseMode(s)
+		if err != nil || string(m) != s {
+			t.Errorf("ParseMode(%q) = %q, %v", s, m, err)
+		}
+		h, err := libp2p.New(libp2p.NoListenAddrs, m.Option())
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		h.Close()
+	}
+	if _, err := ParseMode("unknown"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestLimitsValidate(t *testing.T) {
+	if err := DefaultLimits.Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, l := range []Limits{
+		{Global: 30, PerPeer: 3},
+		{Global: 30, PerPeer: 3, Interval: -time.Second},
+		{Global: 0, PerPeer: 3, Interval: time.Minute},
+		{Global: 30, PerPeer: -1, Interval: time.Minute},
+	} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%+v accepted", l)
+		}
+		if _, err := NewServer(nil, nil, l); err == nil {
+			t.Errorf("server with %+v created", l)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, server := newServer(t, DefaultLimits)
+	client := nettest.NewHost(t)
+	nettest.Connect(t, client, server)
+
+	// A closed port and an address of another IP.
+	closed := nettest.NewHost(t)
+	closedAddr := closed.Addrs()[0]
+	closed.Close()
+	addrs := []multiaddr.Multiaddr{
+		client.Addrs()[0],
+		closedAddr,
+		multiaddr.StringCast("/ip4/1.2.3.4/tcp/4001"),
+	}
+	results, err := Check(ctx, client, server.ID(), addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || !results[0].Reachable || results[1].Reachable || results[1].Error == "" || results[2].Reachable {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestCheckRateLimited(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, server := newServer(t, Limits{Global: 10, PerPeer: 1, Interval: time.Minute})
+	client := nettest.NewHost(t)
+	nettest.Connect(t, client, server)
+	if _, err := Check(ctx, client, server.ID(), client.Addrs()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Check(ctx, client, server.ID(), client.Addrs()); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("expected %v, got %v", ErrRateLimited, err)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, server := newServer(t, DefaultLimits)
+	client := nettest.NewHost(t, ModePrivate.Option())
+	down := nettest.NewHost(t)
+	down.Close()
+
+	c := NewChecker(client, ModePrivate, []peer.AddrInfo{
+		nettest.Info(down),
+		nettest.Info(server),
+	})
+	c.allowPrivate = true
+	if err := c.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r := c.Report()
+	if r.Server != server.ID() || len(r.Addrs) != 1 || !r.Addrs[0].Reachable || r.Error != "" {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	if err := c.Start(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for c.Report().Reachability != "Private" {
+		select {
+		case <-ctx.Done():
+			t.Fatal("reachability not reported")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
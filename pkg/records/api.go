@@ -0,0 +1,60 @@
+package records
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// RecordResult is returned by the record commands.
+type RecordResult struct {
+	Key   string
+	Peer  string
+	Seq   uint64
+	Time  time.Time
+	Value []byte
+}
+
+func result(p peer.ID, r *Record) RecordResult {
+	return RecordResult{Key: "/" + Namespace + "/" + p.String(), Peer: p.String(), Seq: r.Seq, Time: r.Time, Value: r.Value}
+}
+
+// RegisterAPI adds the record/put and record/get commands to srv. put
+// publishes the request body as the record of the node owning priv, get
+// fetches the record of the peer named by arg.
+func RegisterAPI(srv *api.Server, vs routing.ValueStore, priv crypto.PrivKey) {
+	srv.HandleFunc("record/put", func(r *http.Request) (interface{}, error) {
+		value, err := io.ReadAll(io.LimitReader(r.Body, MaxValueSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > MaxValueSize {
+			return nil, api.ArgError("%v", ErrValueTooLarge)
+		}
+		rec, err := Put(r.Context(), vs, priv, value)
+		if err != nil {
+			return nil, err
+		}
+		p, err := peer.IDFromPrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		return result(p, rec), nil
+	})
+	srv.HandleFunc("record/get", func(r *http.Request) (interface{}, error) {
+		p, err := peer.Decode(r.URL.Query().Get("arg"))
+		if err != nil {
+			return nil, api.ArgError("invalid peer ID: %v", err)
+		}
+		rec, err := Get(r.Context(), vs, p)
+		if err != nil {
+			return nil, err
+		}
+		return result(p, rec), nil
+	})
+}
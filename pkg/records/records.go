@@ -0,0 +1,210 @@
+// Package records stores small signed metadata records in the private DHT.
+//
+// A record lives under /libp2p-node/<peer ID> and may only be written by the
+// owner of that peer ID: the validator checks the signature against the key
+// embedded in the peer ID (or carried in the record for key types that can't
+// be inlined) and selects the record with the highest sequence number.
+package records
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// Namespace is the DHT key namespace of our records.
+const Namespace = "libp2p-node"
+
+// MaxValueSize bounds the metadata carried by a record.
+const MaxValueSize = 8 << 10
+
+// signaturePrefix separates our signatures from any other use of the key.
+const signaturePrefix = "libp2p-node-record:"
+
+var logger = log.Logger("records")
+
+var (
+	ErrInvalidKey       = errors.New("invalid record key")
+	ErrInvalidSignature = errors.New("invalid record signature")
+	ErrValueTooLarge    = fmt.Errorf("record value larger than %d bytes", MaxValueSize)
+)
+
+// Record is the signed value stored in the DHT.
+type Record struct {
+	Seq   uint64    `json:"seq"`
+	Time  time.Time `json:"time"`
+	Value []byte    `json:"value"`
+	// PubKey is only set when it can't be extracted from the peer ID.
+	PubKey    []byte `json:"pubkey,omitempty"`
+	Signature []byte `json:"sig"`
+}
+
+// Key returns the DHT key of the record published by p.
+func Key(p peer.ID) string {
+	return "/" + Namespace + "/" + string(p)
+}
+
+// ParseKey returns the peer owning key.
+func ParseKey(key string) (peer.ID, error) {
+	ns, rest, ok := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+	if !ok || ns != Namespace {
+		return "", ErrInvalidKey
+	}
+	p, err := peer.IDFromBytes([]byte(rest))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return p, nil
+}
+
+func (r *Record) signedBytes(key string) []byte {
+	buf := make([]byte, 0, len(signaturePrefix)+len(key)+16+len(r.Value))
+	buf = append(buf, signaturePrefix...)
+	buf = append(buf, key...)
+	buf = binary.BigEndian.AppendUint64(buf, r.Seq)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Time.UnixNano()))
+	return append(buf, r.Value...)
+}
+
+// NewRecord signs value with priv as the record with sequence number seq.
+func NewRecord(priv crypto.PrivKey, seq uint64, value []byte) ([]byte, error) {
+	if len(value) > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	p, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	r := &Record{Seq: seq, Time: time.Now().UTC(), Value: value}
+	if _, err := p.ExtractPublicKey(); err != nil {
+		if r.PubKey, err = crypto.MarshalPublicKey(priv.GetPublic()); err != nil {
+			return nil, err
+		}
+	}
+	if r.Signature, err = priv.Sign(r.signedBytes(Key(p))); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r)
+}
+
+// Parse decodes a record without validating it.
+func Parse(value []byte) (*Record, error) {
+	var r Record
+	if err := json.Unmarshal(value, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Validator is the record.Validator of Namespace.
+type Validator struct{}
+
+// Validate checks that value is a record for key signed by the key's peer.
+func (Validator) Validate(key string, value []byte) error {
+	p, err := ParseKey(key)
+	if err != nil {
+		return err
+	}
+	r, err := Parse(value)
+	if err != nil {
+		return err
+	}
+	if len(r.Value) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	var pub crypto.PubKey
+	if len(r.PubKey) > 0 {
+		if pub, err = crypto.UnmarshalPublicKey(r.PubKey); err != nil {
+			return err
+		}
+		if !p.MatchesPublicKey(pub) {
+			return fmt.Errorf("%w: public key doesn't match %s", ErrInvalidSignature, p)
+		}
+	} else if pub, err = p.ExtractPublicKey(); err != nil {
+		return fmt.Errorf("%w: no public key for %s", ErrInvalidSignature, p)
+	}
+
+	ok, err := pub.Verify(r.signedBytes(key), r.Signature)
+	if err != nil || !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Select picks the record with the highest sequence number, the latest one
+// on ties.
+func (Validator) Select(key string, values [][]byte) (int, error) {
+	best := -1
+	var bestRecord *Record
+	for i, value := range values {
+		r, err := Parse(value)
+		if err != nil {
+			continue
+		}
+		if bestRecord == nil || newer(r, bestRecord, value, values[best]) {
+			best, bestRecord = i, r
+		}
+	}
+	if best < 0 {
+		return 0, errors.New("no valid record")
+	}
+	return best, nil
+}
+
+func newer(a, b *Record, aRaw, bRaw []byte) bool {
+	if a.Seq != b.Seq {
+		return a.Seq > b.Seq
+	}
+	if !a.Time.Equal(b.Time) {
+		return a.Time.After(b.Time)
+	}
+	// Same seq and time, any deterministic choice does.
+	return bytes.Compare(aRaw, bRaw) > 0
+}
+
+// Put publishes value as the record of priv's peer in r, using a sequence
+// number above the one currently stored.
+func Put(ctx context.Context, r routing.ValueStore, priv crypto.PrivKey, value []byte) (*Record, error) {
+	p, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	var seq uint64 = 1
+	if current, err := Get(ctx, r, p); err == nil {
+		seq = current.Seq + 1
+	} else if !errors.Is(err, routing.ErrNotFound) {
+		logger.Debugf("Look up current record of %s: %v", p, err)
+	}
+
+	raw, err := NewRecord(priv, seq, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.PutValue(ctx, Key(p), raw); err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
+
+// Get fetches and validates the record published by p.
+func Get(ctx context.Context, r routing.ValueStore, p peer.ID) (*Record, error) {
+	raw, err := r.GetValue(ctx, Key(p))
+	if err != nil {
+		return nil, err
+	}
+	if err := (Validator{}).Validate(Key(p), raw); err != nil {
+		return nil, err
+	}
+	return Parse(raw)
+}
@@ -0,0 +1,143 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+)
+
+// memStore is a ValueStore that validates and selects records like the DHT.
+type memStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (m *memStore) PutValue(ctx context.Context, key string, value []byte, opts ...routing.Option) error {
+	if err := (Validator{}).Validate(key, value); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.values[key]; ok {
+		if i, _ := (Validator{}).Select(key, [][]byte{old, value}); i == 0 {
+			return errors.New("value is older than the stored one")
+		}
+	}
+	m.values[key] = value
+	return nil
+}
+
+func (m *memStore) GetValue(ctx context.Context, key string, opts ...routing.Option) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		return nil, routing.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *memStore) SearchValue(ctx context.Context, key string, opts ...routing.Option) (<-chan []byte, error) {
+	return nil, routing.ErrNotSupported
+}
+
+func newKey(t *testing.T, typ int) (crypto.PrivKey, peer.ID) {
+	priv, _, err := crypto.GenerateKeyPairWithReader(typ, 2048, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, p
+}
+
+func TestValidate(t *testing.T) {
+	for name, typ := range map[string]int{"ed25519": crypto.Ed25519, "rsa": crypto.RSA} {
+		t.Run(name, func(t *testing.T) {
+			priv, p := newKey(t, typ)
+			raw, err := NewRecord(priv, 1, []byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := (Validator{}).Validate(Key(p), raw); err != nil {
+				t.Fatal(err)
+			}
+
+			// Another peer can't publish under p's key.
+			_, other := newKey(t, crypto.Ed25519)
+			if err := (Validator{}).Validate(Key(other), raw); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("record accepted under another peer's key: %v", err)
+			}
+
+			r, _ := Parse(raw)
+			r.Value = []byte("tampered")
+			tampered, _ := json.Marshal(r)
+			if err := (Validator{}).Validate(Key(p), tampered); !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("tampered record accepted: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	priv, p := newKey(t, crypto.Ed25519)
+	raw, _ := NewRecord(priv, 1, nil)
+	for _, key := range []string{"/other/" + string(p), "/" + Namespace + "/notapeer", string(p)} {
+		if err := (Validator{}).Validate(key, raw); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("key %q: expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+	if _, err := NewRecord(priv, 1, make([]byte, MaxValueSize+1)); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	priv, p := newKey(t, crypto.Ed25519)
+	v1, _ := NewRecord(priv, 1, []byte("one"))
+	v3, _ := NewRecord(priv, 3, []byte("three"))
+	v2, _ := NewRecord(priv, 2, []byte("two"))
+	i, err := (Validator{}).Select(Key(p), [][]byte{v1, []byte("garbage"), v3, v2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 2 {
+		t.Fatalf("selected record %d, want 2", i)
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	ctx := context.Background()
+	vs := &memStore{values: make(map[string][]byte)}
+	priv, p := newKey(t, crypto.Ed25519)
+
+	if _, err := Get(ctx, vs, p); !errors.Is(err, routing.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	for i, value := range []string{"v1", "v2", "v3"} {
+		r, err := Put(ctx, vs, priv, []byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Seq != uint64(i+1) {
+			t.Fatalf("put %s with seq %d, want %d", value, r.Seq, i+1)
+		}
+	}
+	r, err := Get(ctx, vs, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 3 || !bytes.Equal(r.Value, []byte("v3")) {
+		t.Fatalf("got seq %d value %q", r.Seq, r.Value)
+	}
+}
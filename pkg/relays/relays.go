@@ -0,0 +1,304 @@
+// Package relays feeds autorelay with relay candidates found at run time
+// instead of a fixed list: the static relays, the peers advertising
+// Namespace in the DHT and the connected peers speaking circuit v2. The
+// candidates that can't be reached are left out and the others are offered
+// lowest latency first, so that a saturated or lost relay is replaced by the
+// next best one.
+package relays
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	msmux "github.com/multiformats/go-multistream"
+)
+
+var logger = log.Logger("relays")
+
+// Namespace is advertised in the DHT by the nodes running the relay service.
+const Namespace = "/libp2p-node/relay"
+
+// Defaults of the Finder options.
+const (
+	DefaultNumRelays  = 2
+	DefaultCandidates = 6
+	// DefaultBackoff is much shorter than the hour of autorelay, our few
+	// relays would otherwise stay unused long after a restart.
+	DefaultBackoff     = 5 * time.Minute
+	DefaultMinInterval = 30 * time.Second
+	// DefaultTimeout bounds the DHT lookup and each latency measurement.
+	DefaultTimeout = 10 * time.Second
+)
+
+// Sources of the candidates.
+const (
+	SourceStatic = "static"
+	SourceDHT    = "dht"
+	SourcePeer   = "peer"
+)
+
+// Candidate is a relay offered to autorelay.
+type Candidate struct {
+	peer.AddrInfo
+	Source string
+	// Latency is the round trip time of a ping, or of refusing the ping
+	// protocol when the relay doesn't run the ping service. It is 0 if
+	// unknown.
+	Latency time.Duration
+}
+
+// Finder is the autorelay peer source. Pass Option to libp2p.New and call
+// Start with the host.
+type Finder struct {
+	static      []peer.AddrInfo
+	namespace   string
+	numRelays   int
+	candidates  int
+	backoff     time.Duration
+	minInterval time.Duration
+	timeout     time.Duration
+
+	started chan struct{}
+	mu      sync.Mutex
+	host    host.Host
+	disc    discovery.Discoverer
+}
+
+// Option configures a Finder.
+type Option func(*Finder)
+
+// WithNamespace sets the DHT namespace relays are looked up in, empty to
+// only use the static and connected relays.
+func WithNamespace(ns string) Option {
+	return func(f *Finder) { f.namespace = ns }
+}
+
+// WithNumRelays sets the number of relays to hold a reservation with.
+func WithNumRelays(n int) Option {
+	return func(f *Finder) { f.numRelays = n }
+}
+
+// WithCandidates sets the number of best candidates offered to autorelay,
+// which picks the relays among them.
+func WithCandidates(n int) Option {
+	return func(f *Finder) { f.candidates = n }
+}
+
+// WithBackoff sets how long a relay that refused a reservation is skipped.
+func WithBackoff(d time.Duration) Option {
+	return func(f *Finder) { f.backoff = d }
+}
+
+// WithMinInterval sets the minimum interval between two searches.
+func WithMinInterval(d time.Duration) Option {
+	return func(f *Finder) { f.minInterval = d }
+}
+
+// WithTimeout bounds the DHT lookup and each latency measurement.
+func WithTimeout(d time.Duration) Option {
+	return func(f *Finder) { f.timeout = d }
+}
+
+// New returns a Finder always offering the static relays, when reachable.
+func New(static []peer.AddrInfo, opts ...Option) *Finder {
+	f := &Finder{
+		static:      static,
+		namespace:   Namespace,
+		numRelays:   DefaultNumRelays,
+		candidates:  DefaultCandidates,
+		backoff:     DefaultBackoff,
+		minInterval: DefaultMinInterval,
+		timeout:     DefaultTimeout,
+		started:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.candidates < f.numRelays {
+		f.candidates = f.numRelays
+	}
+	return f
+}
+
+// Option enables autorelay fed by f, opts are applied after ours.
+func (f *Finder) Option(opts ...autorelay.Option) libp2p.Option {
+	return libp2p.EnableAutoRelayWithPeerSource(f.PeerSource, append([]autorelay.Option{
+		autorelay.WithNumRelays(f.numRelays),
+		autorelay.WithMaxCandidates(f.candidates),
+		// Reserve as soon as enough relays are known, the candidates are
+		// already the best ones.
+		autorelay.WithMinCandidates(f.numRelays),
+		autorelay.WithBackoff(f.backoff),
+		autorelay.WithMinInterval(f.minInterval),
+	}, opts...)...)
+}
+
+// Start gives f the host to measure the candidates from, the peer source
+// waits for it.
+func (f *Finder) Start(h host.Host) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.host != nil {
+		return
+	}
+	f.host = h
+	close(f.started)
+}
+
+// SetDiscovery adds the relays advertising the namespace in d, usually the
+// routing discovery of the DHT created after the host.
+func (f *Finder) SetDiscovery(d discovery.Discoverer) {
+	f.mu.Lock()
+	f.disc = d
+	f.mu.Unlock()
+}
+
+// PeerSource implements autorelay.PeerSource.
+func (f *Finder) PeerSource(ctx context.Context, num int) <-chan peer.AddrInfo {
+	out := make(chan peer.AddrInfo)
+	go func() {
+		defer close(out)
+		select {
+		case <-f.started:
+		case <-ctx.Done():
+			return
+		}
+		cands := f.Candidates(ctx)
+		if len(cands) > num {
+			cands = cands[:num]
+		}
+		for _, c := range cands {
+			logger.Debugf("Relay candidate %s from %s, latency %s", c.ID, c.Source, c.Latency)
+			select {
+			case out <- c.AddrInfo:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Candidates returns the reachable candidates, lowest latency first and
+// those of unknown latency last.
+func (f *Finder) Candidates(ctx context.Context) []Candidate {
+	f.mu.Lock()
+	h, disc := f.host, f.disc
+	f.mu.Unlock()
+
+	var cands []*Candidate
+	byID := make(map[peer.ID]*Candidate)
+	add := func(ai peer.AddrInfo, source string) {
+		if ai.ID == h.ID() {
+			return
+		}
+		if c, ok := byID[ai.ID]; ok {
+			c.Addrs = append(c.Addrs, ai.Addrs...)
+			return
+		}
+		c := &Candidate{AddrInfo: ai, Source: source}
+		byID[ai.ID] = c
+		cands = append(cands, c)
+	}
+	for _, ai := range f.static {
+		add(ai, SourceStatic)
+	}
+	for _, p := range h.Network().Peers() {
+		if ok, _ := h.Peerstore().SupportsProtocols(p, proto.ProtoIDv2Hop); len(ok) > 0 {
+			add(peer.AddrInfo{ID: p}, SourcePeer)
+		}
+	}
+	if disc != nil && f.namespace != "" {
+		dctx, cancel := context.WithTimeout(ctx, f.timeout)
+		found, err := disc.FindPeers(dctx, f.namespace, discovery.Limit(2*f.candidates))
+		if err != nil {
+			logger.Warnf("Find relays in %s: %v", f.namespace, err)
+		} else {
+			for ai := range found {
+				add(ai, SourceDHT)
+			}
+		}
+		cancel()
+	}
+
+	var wg sync.WaitGroup
+	reachable := make([]bool, len(cands))
+	for i, c := range cands {
+		wg.Add(1)
+		go func(i int, c *Candidate) {
+			defer wg.Done()
+			var err error
+			if c.Latency, err = f.latency(ctx, h, c.AddrInfo); err != nil {
+				logger.Debugf("Relay candidate %s from %s unreachable: %v", c.ID, c.Source, err)
+				return
+			}
+			reachable[i] = true
+		}(i, c)
+	}
+	wg.Wait()
+
+	var res []Candidate
+	for i, c := range cands {
+		if reachable[i] {
+			res = append(res, *c)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Latency == 0 || res[j].Latency == 0 {
+			return res[j].Latency == 0 && res[i].Latency != 0
+		}
+		return res[i].Latency < res[j].Latency
+	})
+	return res
+}
+
+var errNoAddrs = errors.New("no addresses")
+
+// latency returns the latency known to the peerstore, or measures it with a
+// ping, connecting first if needed.
+func (f *Finder) latency(ctx context.Context, h host.Host, ai peer.AddrInfo) (time.Duration, error) {
+	if l := h.Peerstore().LatencyEWMA(ai.ID); l > 0 && h.Network().Connectedness(ai.ID) == network.Connected {
+		return l, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	var dialed time.Duration
+	if h.Network().Connectedness(ai.ID) != network.Connected {
+		if len(ai.Addrs) == 0 && len(h.Peerstore().Addrs(ai.ID)) == 0 {
+			return 0, errNoAddrs
+		}
+		start := time.Now()
+		if err := h.Connect(ctx, ai); err != nil {
+			return 0, err
+		}
+		dialed = time.Since(start)
+	}
+	// Records the latency in the peerstore.
+	start := time.Now()
+	res, ok := <-ping.Ping(ctx, h, ai.ID)
+	if ok && res.Error == nil {
+		return res.RTT, nil
+	}
+	// The relay doesn't run the ping service, refusing the protocol took one
+	// round trip.
+	var notSupported msmux.ErrNotSupported[protocol.ID]
+	if ok && errors.As(res.Error, &notSupported) {
+		return time.Since(start), nil
+	}
+	// Unknown if already connected, the handshakes took a few round trips
+	// otherwise.
+	return dialed, nil
+}
@@ -0,0 +1,134 @@
+package relays
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/nettest"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+)
+
+func newRelay(t *testing.T) host.Host {
+	return nettest.NewHost(t, libp2p.EnableRelayService(relay.WithResources(relay.DefaultResources())), libp2p.ForceReachabilityPublic())
+}
+
+type discoverer []peer.AddrInfo
+
+func (d discoverer) FindPeers(ctx context.Context, ns string, opts ...discovery.Option) (<-chan peer.AddrInfo, error) {
+	ch := make(chan peer.AddrInfo, len(d))
+	if ns == Namespace {
+		for _, ai := range d {
+			ch <- ai
+		}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func TestCandidates(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	slow, fast, down, connected := newRelay(t), newRelay(t), newRelay(t), newRelay(t)
+	// Measured without the ping service.
+	found := nettest.NewHost(t, libp2p.EnableRelayService(), libp2p.ForceReachabilityPublic(), libp2p.Ping(false))
+	down.Close()
+	h := nettest.NewHost(t)
+
+	// Known latencies are only trusted on a live connection.
+	for p, l := range map[host.Host]time.Duration{slow: time.Second, fast: 500 * time.Millisecond} {
+		nettest.Connect(t, h, p)
+		h.Peerstore().RecordLatency(p.ID(), l)
+	}
+	nettest.Connect(t, h, connected)
+	for {
+		if ok, _ := h.Peerstore().SupportsProtocols(connected.ID(), proto.ProtoIDv2Hop); len(ok) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("identify didn't complete")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	f := New([]peer.AddrInfo{nettest.Info(slow), nettest.Info(down), nettest.Info(fast)}, WithCandidates(3))
+	f.Start(h)
+	f.SetDiscovery(discoverer{nettest.Info(found)})
+	cands := f.Candidates(ctx)
+	if len(cands) != 4 {
+		t.Fatalf("unexpected candidates %+v", cands)
+	}
+	// Loopback pings take less than the recorded latencies.
+	got := map[peer.ID]Candidate{}
+	for _, c := range cands[:2] {
+		got[c.ID] = c
+	}
+	if got[found.ID()].Source != SourceDHT || got[found.ID()].Latency <= 0 || got[connected.ID()].Source != SourcePeer {
+		t.Errorf("unexpected best candidates %+v", cands[:2])
+	}
+	if cands[2].ID != fast.ID() || cands[3].ID != slow.ID() || cands[3].Source != SourceStatic {
+		t.Errorf("unexpected order %+v", cands)
+	}
+
+	var offered []peer.ID
+	for ai := range f.PeerSource(ctx, 3) {
+		offered = append(offered, ai.ID)
+	}
+	if len(offered) != 3 || offered[2] != fast.ID() {
+		t.Errorf("unexpected peer source output %v", offered)
+	}
+}
+
+func waitRelay(ctx context.Context, t *testing.T, h host.Host, relays ...host.Host) host.Host {
+	t.Helper()
+	for {
+		for _, r := range relays {
+			if h.ConnManager().IsProtected(r.ID(), "autorelay") {
+				return r
+			}
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("no relay reservation")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	r1, r2 := newRelay(t), newRelay(t)
+	f := New([]peer.AddrInfo{nettest.Info(r1), nettest.Info(r2)}, WithNumRelays(1), WithCandidates(2), WithMinInterval(100*time.Millisecond))
+	h := nettest.NewHost(t, libp2p.ForceReachabilityPrivate(), f.Option())
+	f.Start(h)
+
+	first := waitRelay(ctx, t, h, r1, r2)
+	other := r2
+	if first == r2 {
+		other = r1
+	}
+	first.Close()
+	if r := waitRelay(ctx, t, h, other); r != other {
+		t.Errorf("reserved with %s", r.ID())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	f := New(nil, WithNumRelays(3), WithCandidates(1), WithNamespace(""))
+	if f.candidates != 3 || f.namespace != "" {
+		t.Errorf("unexpected finder %+v", f)
+	}
+	// The autorelay options are valid.
+	h := nettest.NewHost(t, f.Option(autorelay.WithBootDelay(time.Second)))
+	f.Start(h)
+	f.Start(h)
+}
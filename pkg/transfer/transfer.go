@@ -0,0 +1,448 @@
+// Package transfer implements /file-transfer/1.0.0, a resumable file
+// transfer protocol between two peers.
+//
+// The sender opens a stream and writes an Offer. The receiver answers with a
+// Reply, either rejecting the offer or accepting it from the offset of the
+// partial file it already holds. The sender then writes the remaining bytes
+// and closes its side of the stream, and the receiver verifies the SHA-256
+// of the complete file before reporting the Result. Control messages are
+// uvarint length prefixed JSON documents, file data is sent as is.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/netutil"
+
+	"github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// ProtocolID is the file transfer protocol.
+const ProtocolID = protocol.ID("/file-transfer/1.0.0")
+
+// ChunkSize is the size of the writes carrying file data.
+const ChunkSize = 64 << 10
+
+const maxControlSize = 64 << 10
+
+// DefaultMaxSize is the largest file a Receiver accepts unless WithMaxSize
+// says otherwise.
+const DefaultMaxSize = 16 << 30
+
+var logger = log.Logger("transfer")
+
+var (
+	// ErrRejected is returned when the receiver declines the offer.
+	ErrRejected = errors.New("file offer rejected")
+	// ErrChecksumMismatch is returned when the received file doesn't match
+	// the offered SHA-256.
+	ErrChecksumMismatch = errors.New("sha256 mismatch")
+)
+
+// Offer describes the file the sender wants to transfer.
+type Offer struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Reply is the receiver's answer to an Offer.
+type Reply struct {
+	Accept bool   `json:"accept"`
+	Offset int64  `json:"offset"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Result reports whether the receiver stored and verified the file.
+type Result struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func writeControl(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	msg := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(body)), uint64(len(body)))
+	_, err = w.Write(append(msg, body...))
+	return err
+}
+
+func readControl(r *bufio.Reader, v interface{}) error {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if size > maxControlSize {
+		return fmt.Errorf("control message too large: %d bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// HashFile returns the hex encoded SHA-256 of the file at path and its size.
+func HashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// Receiver stores the files offered by other peers in a directory. It
+// never replaces a file already in the directory.
+type Receiver struct {
+	host    host.Host
+	dir     string
+	accept  func(peer.ID, Offer) bool
+	maxSize int64
+
+	mu sync.Mutex
+	// receiving serializes the streams of the same offer, they share a
+	// part file.
+	receiving map[string]*partLock
+}
+
+type partLock struct {
+	sync.Mutex
+	refs int
+}
+
+// ReceiverOption configures a Receiver.
+type ReceiverOption func(*Receiver)
+
+// WithMaxSize rejects the offers of files larger than size bytes,
+// DefaultMaxSize by default.
+func WithMaxSize(size int64) ReceiverOption {
+	return func(r *Receiver) {
+		r.maxSize = size
+	}
+}
+
+// NewReceiver registers the file transfer handler on h. accept decides
+// whether an offer is taken, nil accepts every offer.
+func NewReceiver(h host.Host, dir string, accept func(peer.ID, Offer) bool, opts ...ReceiverOption) *Receiver {
+	r := &Receiver{
+		host:      h,
+		dir:       dir,
+		accept:    accept,
+		maxSize:   DefaultMaxSize,
+		receiving: make(map[string]*partLock),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	h.SetStreamHandler(ProtocolID, r.handleStream)
+	return r
+}
+
+// Close removes the stream handler.
+func (r *Receiver) Close() error {
+	r.host.RemoveStreamHandler(ProtocolID)
+	return nil
+}
+
+// partPath is where the partial data of an offer is kept between attempts.
+// The checksum is part of the name so a resumed transfer never continues
+// the data of a different file.
+func (r *Receiver) partPath(offer Offer) string {
+	return filepath.Join(r.dir, fmt.Sprintf(".%s.%s.part", offer.Name, offer.SHA256[:16]))
+}
+
+// lockPart waits for the other streams receiving into path, call the
+// returned function when done.
+func (r *Receiver) lockPart(path string) func() {
+	r.mu.Lock()
+	l := r.receiving[path]
+	if l == nil {
+		l = new(partLock)
+		r.receiving[path] = l
+	}
+	l.refs++
+	r.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		r.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(r.receiving, path)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func validOffer(offer Offer, maxSize int64) error {
+	name := offer.Name
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	if offer.Size < 0 {
+		return fmt.Errorf("invalid size %d", offer.Size)
+	}
+	if offer.Size > maxSize {
+		return fmt.Errorf("size %d exceeds the maximum of %d bytes", offer.Size, maxSize)
+	}
+	if sum, err := hex.DecodeString(offer.SHA256); err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("invalid sha256 %q", offer.SHA256)
+	}
+	return nil
+}
+
+func (r *Receiver) handleStream(s network.Stream) {
+	remote := s.Conn().RemotePeer()
+	if err := r.receive(s); err != nil {
+		logger.Warnf("Receive file from %s: %v", remote, err)
+		s.Reset()
+		return
+	}
+	s.Close()
+}
+
+func (r *Receiver) receive(s network.Stream) error {
+	remote := s.Conn().RemotePeer()
+	br := bufio.NewReader(s)
+	var offer Offer
+	if err := readControl(br, &offer); err != nil {
+		return fmt.Errorf("read offer: %w", err)
+	}
+	if err := validOffer(offer, r.maxSize); err != nil {
+		return writeControl(s, Reply{Reason: err.Error()})
+	}
+	if r.accept != nil && !r.accept(remote, offer) {
+		logger.Infof("Rejected %s (%d bytes) from %s", offer.Name, offer.Size, remote)
+		return writeControl(s, Reply{Reason: "rejected by receiver"})
+	}
+
+	partPath := r.partPath(offer)
+	defer r.lockPart(partPath)()
+	dest := filepath.Join(r.dir, offer.Name)
+	if _, err := os.Lstat(dest); err == nil {
+		logger.Infof("Rejected %s from %s: the file exists", offer.Name, remote)
+		return writeControl(s, Reply{Reason: "file exists"})
+	}
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		writeControl(s, Reply{Reason: "can't store file"})
+		return err
+	}
+	defer part.Close()
+	offset, err := part.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > offer.Size {
+		if err := part.Truncate(0); err != nil {
+			return err
+		}
+		offset = 0
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	logger.Infof("Receiving %s (%d bytes) from %s, resuming at %d", offer.Name, offer.Size, remote, offset)
+	if err := writeControl(s, Reply{Accept: true, Offset: offset}); err != nil {
+		return err
+	}
+
+	n, err := io.CopyBuffer(part, io.LimitReader(br, offer.Size-offset), make([]byte, ChunkSize))
+	if err != nil {
+		return fmt.Errorf("receive data at offset %d: %w", offset+n, err)
+	}
+	if offset+n != offer.Size {
+		return fmt.Errorf("transfer interrupted at offset %d", offset+n)
+	}
+
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != offer.SHA256 {
+		part.Close()
+		os.Remove(partPath)
+		return writeControl(s, Result{Error: fmt.Sprintf("%v: got %s", ErrChecksumMismatch, sum)})
+	}
+	part.Close()
+	// Creating dest first makes sure the rename only replaces our own
+	// empty file, even if another transfer stored the same name meanwhile.
+	placeholder, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			writeControl(s, Result{Error: "file exists"})
+		} else {
+			writeControl(s, Result{Error: "can't store file"})
+		}
+		return err
+	}
+	placeholder.Close()
+	if err := os.Rename(partPath, dest); err != nil {
+		os.Remove(dest)
+		writeControl(s, Result{Error: "can't store file"})
+		return err
+	}
+
+	logger.Infof("Received %s from %s", dest, remote)
+	return writeControl(s, Result{OK: true})
+}
+
+// SendOption configures SendFile.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	attempts int
+	backoff  time.Duration
+	progress func(sent, total int64)
+}
+
+// WithRetries makes SendFile open a new stream and resume from the
+// receiver's offset up to attempts times after a failed transfer.
+func WithRetries(attempts int, backoff time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithProgress calls fn after each chunk written to the stream.
+func WithProgress(fn func(sent, total int64)) SendOption {
+	return func(o *sendOptions) {
+		o.progress = fn
+	}
+}
+
+// SendFile offers the file at path to p and transfers it once accepted.
+func SendFile(ctx context.Context, h host.Host, p peer.ID, path string, opts ...SendOption) error {
+	o := sendOptions{backoff: time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	sum, size, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	offer := Offer{Name: filepath.Base(path), Size: size, SHA256: sum}
+
+	for attempt := 0; ; attempt++ {
+		err = sendOnce(ctx, h, p, path, offer, o.progress)
+		if err == nil || errors.Is(err, ErrRejected) || attempt >= o.attempts || ctx.Err() != nil {
+			return err
+		}
+		logger.Warnf("Send %s to %s (attempt %d/%d): %v", offer.Name, p, attempt+1, o.attempts+1, err)
+		select {
+		case <-time.After(o.backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func sendOnce(ctx context.Context, h host.Host, p peer.ID, path string, offer Offer, progress func(sent, total int64)) error {
+	s, err := h.NewStream(ctx, p, ProtocolID)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Cancelling ctx aborts a blocked read or write.
+	defer netutil.ResetOnDone(ctx, s)()
+
+	if err := writeControl(s, offer); err != nil {
+		s.Reset()
+		return err
+	}
+	br := bufio.NewReader(s)
+	var reply Reply
+	if err := readControl(br, &reply); err != nil {
+		s.Reset()
+		return fmt.Errorf("read reply: %w", err)
+	}
+	if !reply.Accept {
+		return fmt.Errorf("%w: %s", ErrRejected, reply.Reason)
+	}
+	if reply.Offset < 0 || reply.Offset > offer.Size {
+		s.Reset()
+		return fmt.Errorf("invalid resume offset %d", reply.Offset)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		s.Reset()
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(reply.Offset, io.SeekStart); err != nil {
+		s.Reset()
+		return err
+	}
+
+	sent := reply.Offset
+	buf := make([]byte, ChunkSize)
+	for sent < offer.Size {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if _, err := s.Write(buf[:n]); err != nil {
+				s.Reset()
+				return fmt.Errorf("send data at offset %d: %w", sent, err)
+			}
+			sent += int64(n)
+			if progress != nil {
+				progress(sent, offer.Size)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Reset()
+			return err
+		}
+	}
+	if sent != offer.Size {
+		s.Reset()
+		return fmt.Errorf("%s changed while sending", path)
+	}
+	if err := s.CloseWrite(); err != nil {
+		s.Reset()
+		return err
+	}
+
+	var result Result
+	if err := readControl(br, &result); err != nil {
+		s.Reset()
+		return fmt.Errorf("read result: %w", err)
+	}
+	if !result.OK {
+		return errors.New(result.Error)
+	}
+	return nil
+}
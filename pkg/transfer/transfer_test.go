@@ -0,0 +1,192 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func setup(t *testing.T, size int) (mocknet.Mocknet, string, []byte, string) {
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mn.Close() })
+
+	data := make([]byte, size)
+	rand.Read(data)
+	src := filepath.Join(t.TempDir(), "model.bin")
+	if err := os.WriteFile(src, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return mn, src, data, t.TempDir()
+}
+
+func checkReceived(t *testing.T, dir string, want []byte) {
+	got, err := os.ReadFile(filepath.Join(dir, "model.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("received file differs from the original")
+	}
+	parts, _ := filepath.Glob(filepath.Join(dir, "*.part"))
+	if len(parts) != 0 {
+		t.Fatalf("partial files left behind: %v", parts)
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	mn, src, data, dir := setup(t, 3*ChunkSize+17)
+	hosts := mn.Hosts()
+	NewReceiver(hosts[1], dir, nil)
+
+	var last int64
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := SendFile(ctx, hosts[0], hosts[1].ID(), src, WithProgress(func(sent, total int64) { last = sent }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != int64(len(data)) {
+		t.Fatalf("progress stopped at %d of %d", last, len(data))
+	}
+	checkReceived(t, dir, data)
+}
+
+func TestResume(t *testing.T) {
+	mn, src, data, dir := setup(t, 5*ChunkSize)
+	hosts := mn.Hosts()
+	r := NewReceiver(hosts[1], dir, nil)
+
+	// An earlier attempt was cut off half way.
+	sum, size, err := HashFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(data) / 2
+	part := r.partPath(Offer{Name: "model.bin", Size: size, SHA256: sum})
+	if err := os.WriteFile(part, data[:half], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	first := int64(-1)
+	err = SendFile(context.Background(), hosts[0], hosts[1].ID(), src, WithProgress(func(sent, total int64) {
+		if first < 0 {
+			first = sent
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != int64(half+ChunkSize) {
+		t.Fatalf("transfer did not resume at %d, first progress at %d", half, first)
+	}
+	checkReceived(t, dir, data)
+}
+
+func TestCorruptPartialIsRetried(t *testing.T) {
+	mn, src, data, dir := setup(t, 2*ChunkSize)
+	hosts := mn.Hosts()
+	r := NewReceiver(hosts[1], dir, nil)
+
+	sum, size, err := HashFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part := r.partPath(Offer{Name: "model.bin", Size: size, SHA256: sum})
+	if err := os.WriteFile(part, make([]byte, ChunkSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src); err == nil {
+		t.Fatal("expected a checksum error without retries")
+	}
+	if err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src, WithRetries(1, 10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	checkReceived(t, dir, data)
+}
+
+func TestReject(t *testing.T) {
+	mn, src, _, dir := setup(t, 10)
+	hosts := mn.Hosts()
+	NewReceiver(hosts[1], dir, func(p peer.ID, offer Offer) bool {
+		return offer.Size > 100
+	})
+
+	err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src, WithRetries(3, time.Second))
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+}
+
+func TestExistingFileKept(t *testing.T) {
+	mn, src, _, dir := setup(t, 10)
+	hosts := mn.Hosts()
+	NewReceiver(hosts[1], dir, nil)
+	dest := filepath.Join(dir, "model.bin")
+	if err := os.WriteFile(dest, []byte("keep me"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src)
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+	if got, _ := os.ReadFile(dest); string(got) != "keep me" {
+		t.Fatalf("existing file replaced with %q", got)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	mn, src, data, dir := setup(t, 2*ChunkSize)
+	hosts := mn.Hosts()
+	r := NewReceiver(hosts[1], dir, nil, WithMaxSize(ChunkSize))
+
+	err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src)
+	if !errors.Is(err, ErrRejected) {
+		t.Fatalf("expected ErrRejected, got %v", err)
+	}
+	r.Close()
+	NewReceiver(hosts[1], dir, nil, WithMaxSize(int64(len(data))))
+	if err := SendFile(context.Background(), hosts[0], hosts[1].ID(), src); err != nil {
+		t.Fatal(err)
+	}
+	checkReceived(t, dir, data)
+}
+
+func TestSameOfferSerialized(t *testing.T) {
+	mn, src, data, dir := setup(t, 2*ChunkSize)
+	hosts := mn.Hosts()
+	r := NewReceiver(hosts[1], dir, nil)
+
+	// Another stream is receiving the same offer.
+	sum, size, err := HashFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock := r.lockPart(r.partPath(Offer{Name: "model.bin", Size: size, SHA256: sum}))
+	done := make(chan error, 1)
+	go func() {
+		done <- SendFile(context.Background(), hosts[0], hosts[1].ID(), src)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("offer handled while the part file was in use: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	checkReceived(t, dir, data)
+}
@@ -3,67 +3,51 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
-	"sync"
 
-	"github.com/libp2p/go-libp2p"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/libp2p/go-libp2p/core/host"
-	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/protocol"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
-	"github.com/multiformats/go-multiaddr"
 )
 
 var (
-	DefaultBootstrapPeers = convertPeers([]string{
-		"/ip4/122.99.183.54/tcp/7001/p2p/12D3KooWSpgWzEXE5GNjY6hgdAhuuBLe4d3ocqWDnVLdCa8U3cig",
-		"/ip4/122.99.183.54/tcp/8511/p2p/12D3KooWJaNdwbwsvESYZmeEhTwkG1KirKxXpE6CdPx2Z9VqM3Rt",
-		"/ip4/82.157.50.32/tcp/7001/p2p/12D3KooWFrTcDtocZWEvEAk2X4poyn13LzT3G7JMBRoPD73YPAoB",
-	})
-	topicNameFlag  = flag.String("topicName", "applesauce", "name of topic to join")
-	protocolPrefix = flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	topicNameFlag    = flag.String("topicName", "applesauce", "name of topic to join")
+	protocolPrefix   = flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	dhtModeFlag      = flag.String("dht-mode", "client", "DHT mode: auto, autoserver, client or server")
+	delegatedRouting = flag.String("delegated-routing", "", "URL of a /routing/v1 endpoint to use instead of running a DHT, we then connect to the peers advertising the topic but can't advertise it")
 )
 
-func convertPeers(peers []string) []multiaddr.Multiaddr {
-	maddrs := make([]multiaddr.Multiaddr, len(peers))
-	for i, peer := range peers {
-		maddr, err := multiaddr.NewMultiaddr(peer)
-		if err != nil {
-			panic(err)
-		}
-		maddrs[i] = maddr
-	}
-	return maddrs
-}
-
 func main() {
-	pskString := flag.String("psk", "", "Pre-Shared Key")
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
 	flag.Parse()
 	ctx := context.Background()
 
-	opts := []libp2p.Option{
-		libp2p.ListenAddrStrings("/ip4/0.0.0.0/tcp/0"),
-	}
-	if *pskString != "" {
-		psk, err := hex.DecodeString(*pskString)
-		if err != nil {
-			panic(err)
-		}
-		fmt.Println("Pre-Shared Key ", psk)
-		opts = append(opts, libp2p.PrivateNetwork(psk))
+	// Start a DHT, for use in peer discovery. We can't just make a new DHT
+	// client because we want each peer to maintain its own local copy of the
+	// DHT, so that the bootstrapping node of the DHT can go down without
+	// inhibiting future peer discovery.
+	mode, err := node.ParseDHTMode(*dhtModeFlag)
+	if err != nil {
+		panic(err)
 	}
-	h, err := libp2p.New(opts...)
+	n, err := node.New(ctx, node.Config{
+		PSK:              *pskString,
+		SwarmKey:         *swarmKeyPath,
+		ProtocolPrefix:   *protocolPrefix,
+		DHTMode:          mode,
+		DelegatedRouting: *delegatedRouting,
+	})
 	if err != nil {
 		panic(err)
 	}
-	defer h.Close()
-	go discoverPeers(ctx, h)
+	defer n.Close()
+	h := n.Host
+	go discoverPeers(ctx, n)
 
 	ps, err := pubsub.NewGossipSub(ctx, h)
 	if err != nil {
@@ -82,46 +66,17 @@ func main() {
 	printMessagesFrom(ctx, sub)
 }
 
-func initDHT(ctx context.Context, h host.Host) *dht.IpfsDHT {
-	// Start a DHT, for use in peer discovery. We can't just make a new DHT
-	// client because we want each peer to maintain its own local copy of the
-	// DHT, so that the bootstrapping node of the DHT can go down without
-	// inhibiting future peer discovery.
-	dhtOpts := []dht.Option{
-		dht.Mode(dht.ModeClient),
-	}
-	if *protocolPrefix != "" {
-		dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(*protocolPrefix)))
-	}
-
-	kademliaDHT, err := dht.New(ctx, h, dhtOpts...)
-	if err != nil {
-		panic(err)
+func discoverPeers(ctx context.Context, n *node.Node) {
+	if err := n.Bootstrap(ctx); err != nil {
+		fmt.Println("Bootstrap warning:", err)
 	}
-	if err = kademliaDHT.Bootstrap(ctx); err != nil {
-		panic(err)
+	routingDiscovery := drouting.NewRoutingDiscovery(n.Routing)
+	if *delegatedRouting != "" {
+		// /routing/v1 doesn't take provider records, we can only look up the
+		// peers running a DHT that advertised the topic.
+		connectPeers(ctx, n.Host, routingDiscovery)
+		return
 	}
-	var wg sync.WaitGroup
-	for _, peerAddr := range DefaultBootstrapPeers {
-		peerinfo, _ := peer.AddrInfoFromP2pAddr(peerAddr)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			if err := h.Connect(ctx, *peerinfo); err != nil {
-				fmt.Println("Bootstrap warning:", err)
-			} else {
-				fmt.Println("Connection established with bootstrap node:", *peerinfo)
-			}
-		}()
-	}
-	wg.Wait()
-
-	return kademliaDHT
-}
-
-func discoverPeers(ctx context.Context, h host.Host) {
-	kademliaDHT := initDHT(ctx, h)
-	routingDiscovery := drouting.NewRoutingDiscovery(kademliaDHT)
 	dutil.Advertise(ctx, routingDiscovery, *topicNameFlag)
 
 	// Look for others who have announced and attempt to connect to them
@@ -148,6 +103,27 @@ func discoverPeers(ctx context.Context, h host.Host) {
 	fmt.Println("Peer discovery complete")
 }
 
+// connectPeers connects to the peers that advertised the topic.
+func connectPeers(ctx context.Context, h host.Host, d *drouting.RoutingDiscovery) {
+	fmt.Println("Searching for peers...")
+	peerChan, err := d.FindPeers(ctx, *topicNameFlag)
+	if err != nil {
+		fmt.Println("Find peers:", err)
+		return
+	}
+	for p := range peerChan {
+		if p.ID == h.ID() {
+			continue // No self connection
+		}
+		if err := h.Connect(ctx, p); err != nil {
+			fmt.Printf("Failed connecting to %s, error: %s\n", p.ID, err)
+		} else {
+			fmt.Println("Connected to:", p.ID)
+		}
+	}
+	fmt.Println("Peer discovery complete")
+}
+
 func streamConsoleTo(ctx context.Context, topic *pubsub.Topic) {
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -3,23 +3,22 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
-	"sync"
-
-	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/api"
+	"github.com/Jerry-se/libp2p-node/pkg/chat"
+	"github.com/Jerry-se/libp2p-node/pkg/dcutr"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
+	"github.com/Jerry-se/libp2p-node/pkg/portmap"
+	"github.com/Jerry-se/libp2p-node/pkg/reachability"
+	"github.com/Jerry-se/libp2p-node/pkg/relays"
 	"github.com/libp2p/go-libp2p"
-	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
-	"github.com/libp2p/go-libp2p/core/peerstore"
-	"github.com/libp2p/go-libp2p/core/protocol"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
-	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
-
-	dht "github.com/libp2p/go-libp2p-kad-dht"
 
 	"github.com/ipfs/go-log/v2"
 
@@ -27,12 +26,7 @@ import (
 )
 
 var (
-	DefaultBootstrapPeers = convertPeers([]string{
-		"/ip4/122.99.183.54/tcp/7001/p2p/12D3KooWSpgWzEXE5GNjY6hgdAhuuBLe4d3ocqWDnVLdCa8U3cig",
-		// "/ip4/122.99.183.54/tcp/8511/p2p/12D3KooWJaNdwbwsvESYZmeEhTwkG1KirKxXpE6CdPx2Z9VqM3Rt",
-		// "/ip4/122.99.183.54/tcp/8515/p2p/12D3KooWNp5pyEAtXs52RqssBkrCGojyNLWiZysjr8EpXKK4rpyp",
-		"/ip4/82.157.50.32/tcp/7001/p2p/12D3KooWFrTcDtocZWEvEAk2X4poyn13LzT3G7JMBRoPD73YPAoB",
-	})
+	DefaultBootstrapPeers = convertPeers(node.DefaultBootstrapPeers)
 )
 
 var logger = log.Logger("rendezvous")
@@ -50,72 +44,28 @@ func convertPeers(peers []string) []peer.AddrInfo {
 	return pinfos
 }
 
-func handleStream(stream network.Stream) {
-	logger.Info("Got a new stream!")
-
-	// Create a buffer stream for non-blocking read and write.
-	rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
-
-	go readData(rw)
-	go writeData(rw)
-
-	// 'stream' will stay open until you close it (or the other side closes it).
-}
-
-func readData(rw *bufio.ReadWriter) {
-	for {
-		str, err := rw.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from buffer")
-			panic(err)
-		}
-
-		if str == "" {
-			return
-		}
-		if str != "\n" {
-			// Green console colour: 	\x1b[32m
-			// Reset console colour: 	\x1b[0m
-			fmt.Printf("\x1b[32m%s\x1b[0m> ", str)
-		}
-
-	}
-}
-
-func writeData(rw *bufio.ReadWriter) {
-	stdReader := bufio.NewReader(os.Stdin)
-
-	for {
-		fmt.Print("> ")
-		sendData, err := stdReader.ReadString('\n')
-		if err != nil {
-			fmt.Println("Error reading from stdin")
-			panic(err)
-		}
-
-		_, err = rw.WriteString(fmt.Sprintf("%s\n", sendData))
-		if err != nil {
-			fmt.Println("Error writing to buffer")
-			panic(err)
-		}
-		err = rw.Flush()
-		if err != nil {
-			fmt.Println("Error flushing buffer")
-			panic(err)
-		}
-	}
-}
-
 func main() {
 	log.SetAllLoggers(log.LevelInfo)
 	log.SetLogLevel("rendezvous", "debug")
 	help := flag.Bool("h", false, "Display Help")
 	listenF := flag.Int("l", 6000, "listening port waiting for incoming connections")
 	peerKeyPath := flag.String("peerkey", "", "the file path of peer key")
-	pskString := flag.String("psk", "", "Pre-Shared Key")
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
 	rendezvousString := flag.String("rendezvous", "meet me here",
 		"Unique string to identify group of nodes. Share this with your friends to let them connect with you")
 	protocolPrefix := flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	dhtMode := flag.String("dht-mode", "auto", "DHT mode: auto, autoserver, client or server")
+	reconnect := flag.Int("reconnect", 0, "number of attempts to re-open a chat stream that ended unexpectedly")
+	numRelays := flag.Int("relays", relays.DefaultNumRelays, "number of relays to hold a reservation with")
+	relayCandidates := flag.Int("relay-candidates", relays.DefaultCandidates, "number of lowest latency relays to choose from")
+	relayNamespace := flag.String("relay-namespace", relays.Namespace, "DHT namespace the relays advertise, empty to only use the bootstrap peers and connected relays")
+	relayBackoff := flag.Duration("relay-backoff", relays.DefaultBackoff, "how long a relay that refused a reservation is skipped")
+	reachabilityFlag := flag.String("reachability", string(reachability.ModePrivate), "reachability mode: auto asks AutoNAT, public or private assume it")
+	checkInterval := flag.Duration("check-addrs", 30*time.Minute, "interval of the per address reachability checks by the bootstrap nodes, 0 to disable them")
+	portMap := flag.Bool("portmap", true, "map the listen port on the router with UPnP or NAT-PMP")
+	apiAddr := flag.String("api", "", "listen address of the HTTP API serving the hole punching, reachability, port mapping and DHT commands, empty to disable it")
+	delegatedRouting := flag.String("delegated-routing", "", "URL of a /routing/v1 endpoint to use instead of running a DHT, we then find the peers advertising the rendezvous string but can't advertise it")
 	flag.Parse()
 
 	if *help {
@@ -129,121 +79,138 @@ func main() {
 	if *peerKeyPath == "" {
 		logger.Fatal("Please provide a filepath to save peer key")
 	}
-	peerKey, _, err := config.LoadPeerKey(*peerKeyPath)
+
+	reachabilityMode, err := reachability.ParseMode(*reachabilityFlag)
 	if err != nil {
-		// log.Fatalf("Load peer key: %v", err)
-		peerKey, _, err = config.GeneratePeerKey(*peerKeyPath)
-		if err != nil {
-			logger.Fatalf("Generate peer key: %v", err)
-		}
-	} else {
-		logger.Info("Load peer key success")
+		logger.Fatal(err)
 	}
 
 	ctx := context.Background()
-	// var kademliaDHT *dht.IpfsDHT
+	// Counts how often hole punching replaces the relayed connections.
+	holePunches := dcutr.New()
+	// Reports the external addresses mapped on the router.
+	portMappings := portmap.New(portmap.DefaultInterval)
+	// The bootstrap nodes are relays too, others are found in the DHT.
+	relayFinder := relays.New(DefaultBootstrapPeers,
+		relays.WithNumRelays(*numRelays),
+		relays.WithCandidates(*relayCandidates),
+		relays.WithNamespace(*relayNamespace),
+		relays.WithBackoff(*relayBackoff),
+	)
 
 	opts := []libp2p.Option{
-		libp2p.ListenAddrStrings(
-			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *listenF),
-		),
-		libp2p.Identity(peerKey),
-		libp2p.DefaultPrivateTransports,
-		// libp2p.DefaultTransports,
-		libp2p.DefaultMuxers,
-		libp2p.DefaultSecurity,
-		// libp2p.ProtocolVersion("ipfs/0.1.0"),
-		libp2p.NATPortMap(),
-		// libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-		// 	dhtOpts := []dht.Option{
-		// 		dht.Mode(dht.ModeAuto),
-		// 		dht.BootstrapPeers(DefaultBootstrapPeers...),
-		// 	}
-		// 	if *protocolPrefix != "" {
-		// 		dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(*protocolPrefix)))
-		// 	}
-		// 	kademliaDHT, err = dht.New(ctx, h, dhtOpts...)
-		// 	return kademliaDHT, err
-		// }),
-		libp2p.ForceReachabilityPrivate(),
-		libp2p.EnableAutoRelayWithStaticRelays(
-			DefaultBootstrapPeers,
-			autorelay.WithNumRelays(1),
-			autorelay.WithMinCandidates(1),
-		),
-		libp2p.EnableHolePunching(),
+		reachabilityMode.Option(),
+		relayFinder.Option(),
+		holePunches.Option(),
 	}
 
-	if *pskString != "" {
-		psk, err := hex.DecodeString(*pskString)
-		if err != nil {
-			logger.Fatalf("Decoding PSK: %v", err)
-		}
-		logger.Infof("Pre-Shared Key %v", psk)
-		opts = append(opts, libp2p.PrivateNetwork(psk))
+	if *portMap {
+		opts = append(opts, portMappings.Option())
 	}
 
-	// libp2p.New constructs a new libp2p Host. Other options can be added
-	// here.
-	host, err := libp2p.New(opts...)
+	// Start a DHT, for use in peer discovery. We can't just make a new DHT
+	// client because we want each peer to maintain its own local copy of the
+	// DHT, so that the bootstrapping node of the DHT can go down without
+	// inhibiting future peer discovery.
+	mode, err := node.ParseDHTMode(*dhtMode)
 	if err != nil {
-		panic(err)
+		logger.Fatal(err)
+	}
+	// Its mode can be switched at runtime through the API.
+	n, err := node.New(ctx, node.Config{
+		ListenAddrs:      []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *listenF)},
+		PeerKeyPath:      *peerKeyPath,
+		PSK:              *pskString,
+		SwarmKey:         *swarmKeyPath,
+		ProtocolPrefix:   *protocolPrefix,
+		DHTMode:          mode,
+		BootstrapPeers:   DefaultBootstrapPeers,
+		DelegatedRouting: *delegatedRouting,
+		Options:          opts,
+	})
+	if err != nil {
+		logger.Fatalf("Create libp2p host: %v", err)
+	}
+	defer n.Close()
+	host := n.Host
+	holePunches.Start(host)
+	if err := portMappings.Start(host); err != nil {
+		logger.Fatalf("Port mapping events: %v", err)
 	}
-	defer host.Close()
+	relayFinder.Start(host)
 
 	logger.Info("Host created. We are:", host.ID())
 	logger.Info(host.Addrs())
 
-	// Set a function as stream handler. This function is called when a peer
-	// initiates a connection and starts a stream with this peer.
-	host.SetStreamHandler("/chat/1.0.0", handleStream)
+	// The bootstrap nodes dial back each of our public addresses.
+	checker := reachability.NewChecker(host, reachabilityMode, DefaultBootstrapPeers)
 
-	// Start a DHT, for use in peer discovery. We can't just make a new DHT
-	// client because we want each peer to maintain its own local copy of the
-	// DHT, so that the bootstrapping node of the DHT can go down without
-	// inhibiting future peer discovery.
-	dhtOpts := []dht.Option{
-		dht.Mode(dht.ModeAuto),
-		dht.BootstrapPeers(DefaultBootstrapPeers...),
-	}
-	if *protocolPrefix != "" {
-		dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(*protocolPrefix)))
-	}
-	kademliaDHT, err := dht.New(ctx, host, dhtOpts...)
-	if err != nil {
-		panic(err)
+	// The chat service handles streams opened by other peers as well as the
+	// ones we open ourselves. A peer going away only ends its own session.
+	chatService := chat.New(host, func(m chat.Message) {
+		switch m.Type {
+		case chat.TypeText:
+			// Green console colour: 	\x1b[32m
+			// Reset console colour: 	\x1b[0m
+			fmt.Printf("\x1b[32m%s\x1b[0m\n> ", m.Text())
+		case chat.TypeTyping:
+			logger.Debugf("%s is typing...", m.From)
+		case chat.TypeAck:
+			logger.Debugf("Message %d delivered to %s", m.ID, m.From)
+		case chat.TypeFileOffer:
+			offer, err := m.FileOffer()
+			if err != nil {
+				logger.Warnf("Bad file offer from %s: %v", m.From, err)
+				return
+			}
+			fmt.Printf("%s offers %s (%d bytes, sha256 %s)\n> ", m.From, offer.Name, offer.Size, offer.SHA256)
+		}
+	}, chat.WithReconnect(*reconnect, 5*time.Second))
+	defer chatService.Close()
+
+	if *apiAddr != "" {
+		apiServer := api.NewServer()
+		holePunches.RegisterAPI(apiServer)
+		checker.RegisterAPI(apiServer)
+		portMappings.RegisterAPI(apiServer)
+		n.RegisterAPI(apiServer)
+		addr, err := apiServer.Serve(*apiAddr)
+		if err != nil {
+			logger.Fatalf("Start API server: %v", err)
+		}
+		defer apiServer.Close()
+		logger.Info("API server listening on ", addr)
 	}
 
-	// Bootstrap the DHT. In the default configuration, this spawns a Background
-	// thread that will refresh the peer table every five minutes.
+	// Let's connect to the bootstrap nodes first. They will tell us about the
+	// other nodes in the network. In the default configuration, bootstrapping
+	// the DHT spawns a background thread that refreshes the peer table every
+	// five minutes.
 	logger.Debug("Bootstrapping the DHT")
-	if err = kademliaDHT.Bootstrap(ctx); err != nil {
-		panic(err)
+	if err := n.Bootstrap(ctx); err != nil {
+		logger.Warnf("Bootstrap: %v", err)
 	}
 
-	// Let's connect to the bootstrap nodes first. They will tell us about the
-	// other nodes in the network.
-	var wg sync.WaitGroup
-	for _, peerInfo := range DefaultBootstrapPeers {
-		wg.Add(1)
-		go func(peerInfo peer.AddrInfo) {
-			defer wg.Done()
-			host.Peerstore().AddAddrs(peerInfo.ID, peerInfo.Addrs, peerstore.PermanentAddrTTL)
-			if err := host.Connect(ctx, peerInfo); err != nil {
-				logger.Warning(err)
-			} else {
-				logger.Info("Connection established with bootstrap node:", peerInfo)
-			}
-		}(peerInfo)
+	if *checkInterval > 0 {
+		if err := checker.Start(*checkInterval); err != nil {
+			logger.Fatalf("Start reachability checks: %v", err)
+		}
+		defer checker.Close()
 	}
-	wg.Wait()
 
 	// We use a rendezvous point "meet me here" to announce our location.
 	// This is like telling your friends to meet you at the Eiffel Tower.
-	logger.Info("Announcing ourselves...")
-	routingDiscovery := drouting.NewRoutingDiscovery(kademliaDHT)
-	dutil.Advertise(ctx, routingDiscovery, *rendezvousString)
-	logger.Debug("Successfully announced!")
+	routingDiscovery := drouting.NewRoutingDiscovery(n.Routing)
+	relayFinder.SetDiscovery(routingDiscovery)
+	if *delegatedRouting != "" {
+		// /routing/v1 doesn't take provider records, only the peers running a
+		// DHT can announce themselves.
+		logger.Warn("Routing is delegated, we can't announce ourselves, only connect to the peers that did")
+	} else {
+		logger.Info("Announcing ourselves...")
+		dutil.Advertise(ctx, routingDiscovery, *rendezvousString)
+		logger.Debug("Successfully announced!")
+	}
 
 	// Now, look for others who have announced
 	// This is like your friend telling you the location to meet you.
@@ -253,27 +220,31 @@ func main() {
 		panic(err)
 	}
 
-	for peer := range peerChan {
-		if peer.ID == host.ID() {
-			continue
-		}
-		logger.Debug("Found peer:", peer)
-
-		logger.Debug("Connecting to:", peer)
-		stream, err := host.NewStream(ctx, peer.ID, "/chat/1.0.0")
+	go func() {
+		for peer := range peerChan {
+			if peer.ID == host.ID() {
+				continue
+			}
+			logger.Debug("Found peer:", peer)
 
-		if err != nil {
-			logger.Warning("Connection failed:", err)
-			continue
-		} else {
-			rw := bufio.NewReadWriter(bufio.NewReader(stream), bufio.NewWriter(stream))
+			logger.Debug("Connecting to:", peer)
+			if err := chatService.Connect(ctx, peer.ID); err != nil {
+				logger.Warning("Connection failed:", err)
+				continue
+			}
 
-			go writeData(rw)
-			go readData(rw)
+			logger.Info("Connected to:", peer)
 		}
+	}()
 
-		logger.Info("Connected to:", peer)
+	stdReader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Print("> ")
+		sendData, err := stdReader.ReadString('\n')
+		if err != nil {
+			logger.Warnf("Reading from stdin: %v", err)
+			return
+		}
+		chatService.Send(sendData)
 	}
-
-	select {}
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/crawl"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func main() {
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
+	protocolPrefix := flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	bootstrap := flag.String("bootstrap", "", "comma separated multiaddrs to start from, the default bootstrap peers if empty")
+	format := flag.String("format", "json", "output format, json or csv")
+	output := flag.String("o", "", "output file, stdout if empty")
+	parallelism := flag.Int("parallelism", 100, "number of peers crawled at once")
+	timeout := flag.Duration("timeout", 5*time.Second, "connect and request timeout per peer")
+	flag.Parse()
+
+	if *format != "json" && *format != "csv" {
+		log.Fatalf("Unknown format %q", *format)
+	}
+	addrs := node.DefaultBootstrapPeers
+	if *bootstrap != "" {
+		addrs = strings.Split(*bootstrap, ",")
+	}
+	peers, err := node.ParseBootstrapPeers(addrs)
+	if err != nil {
+		log.Fatalf("Parse bootstrap peers: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// The crawler only needs a host that can join the network, its DHT
+	// stays a client and is never bootstrapped.
+	n, err := node.New(ctx, node.Config{
+		PSK:            *pskString,
+		SwarmKey:       *swarmKeyPath,
+		ProtocolPrefix: *protocolPrefix,
+		DHTMode:        dht.ModeClient,
+		BootstrapPeers: peers,
+	})
+	if err != nil {
+		log.Fatalf("Create libp2p host: %v", err)
+	}
+	defer n.Close()
+
+	res, err := crawl.Crawl(ctx, n.Host, peers,
+		crawl.WithProtocolPrefix(*protocolPrefix),
+		crawl.WithParallelism(*parallelism),
+		crawl.WithTimeout(*timeout))
+	if err != nil {
+		log.Printf("Crawl interrupted: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Create output file: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		err = res.WriteCSV(w)
+	} else {
+		err = res.WriteJSON(w)
+	}
+	if err != nil {
+		log.Fatalf("Write result: %v", err)
+	}
+
+	s := res.Summary()
+	fmt.Fprintf(os.Stderr, "Crawled %d peers in %s: %d reachable, %d unreachable\n",
+		s.Total, res.Duration.Round(time.Millisecond), s.Reachable, s.Unreachable)
+	agents := make([]string, 0, len(s.Agents))
+	for agent := range s.Agents {
+		agents = append(agents, agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return s.Agents[agents[i]] > s.Agents[agents[j]] })
+	for _, agent := range agents {
+		fmt.Fprintf(os.Stderr, "  %5d  %s\n", s.Agents[agent], agent)
+	}
+}
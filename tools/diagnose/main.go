@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/diagnose"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/host/autorelay"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func main() {
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
+	protocolPrefix := flag.String("protocol", "", "the prefix attached to all DHT protocols")
+	bootstrap := flag.String("bootstrap", "", "comma separated multiaddrs of the bootstrap peers, also used as relays, the default bootstrap peers if empty")
+	listenF := flag.Int("l", 0, "listening port, random if 0")
+	target := flag.String("target", "", "peer ID or /p2p multiaddr of the peer to connect to")
+	forcePrivate := flag.Bool("force-private", false, "assume the node is behind a NAT as rendezvous does, instead of asking AutoNAT")
+	timeout := flag.Duration("timeout", 30*time.Second, "time to wait for the reachability and relay reservation, and again for the hole punch")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	addrs := node.DefaultBootstrapPeers
+	if *bootstrap != "" {
+		addrs = strings.Split(*bootstrap, ",")
+	}
+	peers, err := node.ParseBootstrapPeers(addrs)
+	if err != nil {
+		log.Fatalf("Parse bootstrap peers: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// The same host as rendezvous, with the collector watching it.
+	collector := diagnose.New()
+	opts := append([]libp2p.Option{
+		libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", *listenF)),
+		libp2p.DefaultPrivateTransports,
+		libp2p.DefaultMuxers,
+		libp2p.DefaultSecurity,
+		libp2p.NATPortMap(),
+		libp2p.EnableAutoRelayWithStaticRelays(peers,
+			autorelay.WithNumRelays(1),
+			autorelay.WithMinCandidates(1),
+		),
+	}, collector.Options()...)
+	if *forcePrivate {
+		opts = append(opts, libp2p.ForceReachabilityPrivate())
+	}
+	psk, err := config.LoadPSK(*pskString, *swarmKeyPath)
+	if err != nil {
+		log.Fatalf("Pre-Shared Key: %v", err)
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		log.Fatalf("Create libp2p host: %v", err)
+	}
+	defer h.Close()
+	if err := collector.Start(h); err != nil {
+		log.Fatalf("Watch host events: %v", err)
+	}
+	defer collector.Close()
+
+	var wg sync.WaitGroup
+	for _, pi := range peers {
+		wg.Add(1)
+		go func(pi peer.AddrInfo) {
+			defer wg.Done()
+			if err := h.Connect(ctx, pi); err != nil {
+				log.Printf("Connect bootstrap node %s: %v", pi.ID, err)
+			}
+		}(pi)
+	}
+	wg.Wait()
+
+	log.Printf("Waiting up to %s for AutoNAT and a relay reservation", *timeout)
+	readyCtx, readyCancel := context.WithTimeout(ctx, *timeout)
+	collector.WaitReady(readyCtx, h)
+	readyCancel()
+
+	var targetReport *diagnose.TargetReport
+	if *target != "" {
+		ai, err := findTarget(ctx, h, *target, *protocolPrefix, peers, *timeout)
+		if err != nil {
+			targetReport = &diagnose.TargetReport{Error: err.Error()}
+			if id, err := peer.Decode(*target); err == nil {
+				targetReport.Peer = id
+			}
+		} else {
+			log.Printf("Connecting to %s, waiting up to %s for a direct connection", ai.ID, *timeout)
+			targetCtx, targetCancel := context.WithTimeout(ctx, *timeout)
+			targetReport = collector.Target(targetCtx, h, ai)
+			targetCancel()
+		}
+	}
+
+	report := collector.Report(h)
+	report.Target = targetReport
+	report.AddHints()
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		report.WriteText(os.Stdout)
+	}
+	if targetReport != nil && !targetReport.Connected {
+		os.Exit(1)
+	}
+}
+
+// findTarget parses target, a /p2p multiaddr or a peer ID whose addresses
+// are looked up in the DHT.
+func findTarget(ctx context.Context, h host.Host, target, protocolPrefix string, bootstrap []peer.AddrInfo, timeout time.Duration) (peer.AddrInfo, error) {
+	if strings.HasPrefix(target, "/") {
+		addr, err := multiaddr.NewMultiaddr(target)
+		if err != nil {
+			return peer.AddrInfo{}, err
+		}
+		ai, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			return peer.AddrInfo{}, err
+		}
+		return *ai, nil
+	}
+	id, err := peer.Decode(target)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("target is neither a multiaddr nor a peer ID: %w", err)
+	}
+
+	dhtOpts := []dht.Option{dht.Mode(dht.ModeClient), dht.BootstrapPeers(bootstrap...)}
+	if protocolPrefix != "" {
+		dhtOpts = append(dhtOpts, dht.ProtocolPrefix(protocol.ID(protocolPrefix)))
+	}
+	kadDHT, err := dht.New(ctx, h, dhtOpts...)
+	if err != nil {
+		return peer.AddrInfo{}, err
+	}
+	defer kadDHT.Close()
+	if err := kadDHT.Bootstrap(ctx); err != nil {
+		return peer.AddrInfo{}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ai, err := kadDHT.FindPeer(ctx, id)
+	if err != nil {
+		return peer.AddrInfo{}, fmt.Errorf("find %s in the DHT: %w", id, err)
+	}
+	return ai, nil
+}
@@ -1,27 +1,69 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/keystore"
+	"github.com/Jerry-se/libp2p-node/pkg/node"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
+const keysUsage = "[-dir keystore] list | gen [-type t] [-bits n] <name> | rm <name> | rename <old> <new> | rotate [-type t] [-old name] [-config config.json]"
+
+type command struct {
+	usage string
+	run   func(args []string)
+}
+
+var commands = map[string]command{
+	"generate": {"[-type ed25519|secp256k1|ecdsa|rsa] [-bits n] [-format raw|base64|pem] [-encrypt] -o file", runGenerate},
+	"inspect":  {"-in file: print the peer ID, key type and public key", runInspect},
+	"convert":  {"-in file [-format raw|base64|pem] [-encrypt] -o file", runConvert},
+	"import":   {"-in file -config config.json [-force]: store the key in the Identity section", runImport},
+	"export":   {"-config config.json [-format raw|base64|pem] -o file", runExport},
+	"keys":     {keysUsage, runKeys},
+	"vanity":   {"[-prefix s] [-suffix s] [-i] [-workers n] [-timeout d] -o file: search an Ed25519 key with a matching peer ID", runVanity},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [arguments]\n       %s -peerkey file\n\nCommands:\n", os.Args[0], os.Args[0])
+	for _, name := range []string{"generate", "inspect", "convert", "import", "export", "keys", "vanity"} {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	flag.PrintDefaults()
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+
+	// Without a command, load or generate the key at -peerkey like before.
 	peerKeyPath := flag.String("peerkey", "", "the file path of peer key")
+	flag.Usage = usage
 	flag.Parse()
 
 	if *peerKeyPath == "" {
 		log.Fatal("Please provide a filepath to save peer key")
 	}
 
-	privKey, pubKey, err := config.LoadPeerKey(*peerKeyPath)
+	privKey, _, err := config.LoadPeerKey(*peerKeyPath)
 	if err != nil {
-		// log.Fatalf("Load peer key: %v", err)
-		privKey, pubKey, err = config.GeneratePeerKey(*peerKeyPath)
+		if !os.IsNotExist(err) {
+			log.Fatalf("Load peer key: %v", err)
+		}
+		privKey, _, err = config.GeneratePeerKey(*peerKeyPath)
 		if err != nil {
 			log.Fatalf("Generate peer key: %v", err)
 		}
@@ -29,23 +71,292 @@ func main() {
 	} else {
 		log.Println("Load peer key success")
 	}
+	printKey(privKey)
+}
 
+func printKey(privKey crypto.PrivKey) {
 	privkeyBytes, err := crypto.MarshalPrivateKey(privKey)
 	if err != nil {
 		log.Fatalf("Marshal Private Key err: %v", err)
 	}
 	log.Println("Encode private key:", crypto.ConfigEncodeKey(privkeyBytes))
+	inspect(privKey)
+}
 
-	pubkeyBytes, err := crypto.MarshalPublicKey(pubKey)
+func inspect(privKey crypto.PrivKey) {
+	pubkeyBytes, err := crypto.MarshalPublicKey(privKey.GetPublic())
 	if err != nil {
 		log.Fatalf("Marshal Public Key err: %v", err)
 	}
-	log.Println("Encode public key:", crypto.ConfigEncodeKey(pubkeyBytes))
-
-	id, err := peer.IDFromPublicKey(pubKey)
+	id, err := peer.IDFromPublicKey(privKey.GetPublic())
 	if err != nil {
 		log.Fatalf("Transform Peer ID err: %v", err)
-	} else {
-		log.Println("Transform Peer ID:", id)
 	}
+	fmt.Println("Peer ID:   ", id)
+	fmt.Println("Key type:  ", config.KeyTypeString(int(privKey.Type())))
+	fmt.Println("Public key:", crypto.ConfigEncodeKey(pubkeyBytes))
+}
+
+func readKey(path string) crypto.PrivKey {
+	if path == "" {
+		log.Fatal("Please provide the key file with -in")
+	}
+	priv, _, err := config.LoadPeerKey(path)
+	if err != nil {
+		log.Fatalf("Load key %s: %v", path, err)
+	}
+	return priv
+}
+
+// writeKey saves priv to path, which must not exist: overwriting it could
+// destroy an identity key.
+func writeKey(path string, priv crypto.PrivKey, format string, encrypt bool) {
+	if path == "" {
+		log.Fatal("Please provide the output file with -o")
+	}
+	// Checked before asking for a passphrase, writeNewFile checks again.
+	if _, err := os.Stat(path); err == nil {
+		log.Fatalf("%s already exists", path)
+	}
+	if encrypt {
+		passphrase, err := config.NewPassphrase(path)
+		if err != nil {
+			log.Fatalf("Passphrase: %v", err)
+		}
+		data, err := config.EncryptPrivateKey(priv, passphrase)
+		if err != nil {
+			log.Fatalf("Encrypt key: %v", err)
+		}
+		if err := writeNewFile(path, data); err != nil {
+			log.Fatalf("Save encrypted key: %v", err)
+		}
+		log.Printf("Saved encrypted key to %s", path)
+		return
+	}
+	data, err := config.EncodePrivateKey(priv, format)
+	if err != nil {
+		log.Fatalf("Encode key: %v", err)
+	}
+	if format == config.FormatBase64 {
+		data = append(data, '\n')
+	}
+	if err := writeNewFile(path, data); err != nil {
+		log.Fatalf("Write key: %v", err)
+	}
+	log.Printf("Saved %s key to %s", format, path)
+}
+
+// writeNewFile is os.WriteFile failing when path exists.
+func writeNewFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	keyType := fs.String("type", "ed25519", "key type: ed25519, secp256k1, ecdsa or rsa")
+	bits := fs.Int("bits", 2048, "RSA key size")
+	format := fs.String("format", config.FormatRaw, "output format: raw, base64 or pem")
+	encrypt := fs.Bool("encrypt", false, "encrypt the key with a passphrase, -format is ignored")
+	out := fs.String("o", "", "output file")
+	fs.Parse(args)
+
+	typ, err := config.ParseKeyType(*keyType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := config.GenerateKey(typ, *bits)
+	if err != nil {
+		log.Fatalf("Generate key: %v", err)
+	}
+	writeKey(*out, priv, *format, *encrypt)
+	inspect(priv)
+}
+
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	in := fs.String("in", "", "key file in any format")
+	fs.Parse(args)
+	inspect(readKey(*in))
+}
+
+func runConvert(args []string) {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	in := fs.String("in", "", "key file in any format")
+	format := fs.String("format", config.FormatPEM, "output format: raw, base64 or pem")
+	encrypt := fs.Bool("encrypt", false, "encrypt the key with a passphrase, -format is ignored")
+	out := fs.String("o", "", "output file")
+	fs.Parse(args)
+	writeKey(*out, readKey(*in), *format, *encrypt)
+}
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	in := fs.String("in", "", "key file in any format")
+	configPath := fs.String("config", "config.json", "config file to update")
+	force := fs.Bool("force", false, "replace an existing Identity.PrivKey, losing its peer ID")
+	fs.Parse(args)
+
+	id, err := config.NewIdentity(readKey(*in))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.ImportIdentity(*configPath, id, *force); err != nil {
+		if errors.Is(err, config.ErrIdentityExists) {
+			log.Fatalf("%s already has an identity, export it first or use -force", *configPath)
+		}
+		log.Fatalf("Save identity: %v", err)
+	}
+	log.Printf("Stored identity %s in %s", id.PeerID, *configPath)
+}
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", "config.json", "config file to read")
+	format := fs.String("format", config.FormatRaw, "output format: raw, base64 or pem")
+	out := fs.String("o", "", "output file")
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Load configuration: %v", err)
+	}
+	if cfg.Identity.PrivKey == "" {
+		log.Fatalf("No Identity.PrivKey in %s", *configPath)
+	}
+	priv, err := cfg.Identity.PrivateKey()
+	if err != nil {
+		log.Fatalf("Identity: %v", err)
+	}
+	writeKey(*out, priv, *format, false)
+}
+
+func runKeys(args []string) {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	dir := fs.String("dir", "keystore", "keystore directory")
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) == 0 {
+		log.Fatalf("Usage: keys %s", keysUsage)
+	}
+	ks, err := keystore.Open(*dir)
+	if err != nil {
+		log.Fatalf("Open keystore: %v", err)
+	}
+
+	switch args[0] {
+	case "list":
+		keys, err := ks.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, k := range keys {
+			fmt.Printf("%-20s %-10s %s\n", k.Name, k.Type, k.ID)
+		}
+	case "gen":
+		gfs := flag.NewFlagSet("keys gen", flag.ExitOnError)
+		keyType := gfs.String("type", "ed25519", "key type: ed25519, secp256k1, ecdsa or rsa")
+		bits := gfs.Int("bits", 2048, "RSA key size")
+		gfs.Parse(args[1:])
+		if gfs.NArg() != 1 {
+			log.Fatal("Usage: keys gen [-type t] [-bits n] <name>")
+		}
+		typ, err := config.ParseKeyType(*keyType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		priv, err := ks.Generate(gfs.Arg(0), typ, *bits)
+		if err != nil {
+			log.Fatal(err)
+		}
+		inspect(priv)
+	case "rm":
+		if len(args) != 2 {
+			log.Fatal("Usage: keys rm <name>")
+		}
+		if err := ks.Remove(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	case "rename":
+		if len(args) != 3 {
+			log.Fatal("Usage: keys rename <old> <new>")
+		}
+		if err := ks.Rename(args[1], args[2]); err != nil {
+			log.Fatal(err)
+		}
+	case "rotate":
+		rotate(ks, args[1:])
+	default:
+		log.Fatalf("Unknown keys command %q", args[0])
+	}
+}
+
+// rotate replaces the identity of the keystore and points the config file
+// at the new peer ID.
+func rotate(ks *keystore.Keystore, args []string) {
+	fs := flag.NewFlagSet("keys rotate", flag.ExitOnError)
+	keyType := fs.String("type", "ed25519", "type of the new key")
+	bits := fs.Int("bits", 2048, "RSA key size")
+	oldName := fs.String("old", "", "name to keep the old identity under, self-<time> if empty")
+	configPath := fs.String("config", "", "config file whose Identity and Bootstrap sections are updated")
+	fs.Parse(args)
+
+	typ, err := config.ParseKeyType(*keyType)
+	if err != nil {
+		log.Fatal(err)
+	}
+	t, err := ks.Rotate(*oldName, typ, *bits)
+	if err != nil {
+		log.Fatalf("Rotate: %v", err)
+	}
+	fmt.Printf("Rotated identity %s -> %s\n", t.Old, t.New)
+
+	if *configPath != "" {
+		cfg, err := config.LoadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Load configuration: %v", err)
+		}
+		if cfg.Identity.PrivKey != "" {
+			self, err := ks.Get(keystore.Self)
+			if err != nil {
+				log.Fatal(err)
+			}
+			id, err := config.NewIdentity(self)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := config.SaveIdentity(*configPath, id); err != nil {
+				log.Fatalf("Save identity: %v", err)
+			}
+			log.Printf("Updated Identity of %s", *configPath)
+		}
+		if addrs, changed := config.ReplaceBootstrapPeer(cfg.Bootstrap, t.Old, t.New); changed {
+			if err := config.SaveBootstrap(*configPath, addrs); err != nil {
+				log.Fatalf("Save bootstrap list: %v", err)
+			}
+			log.Printf("Updated Bootstrap of %s", *configPath)
+		}
+	}
+	for _, list := range []struct {
+		name  string
+		addrs []string
+	}{
+		{"node.DefaultBootstrapPeers", node.DefaultBootstrapPeers},
+		{"node.ExtraBootstrapPeers", node.ExtraBootstrapPeers},
+	} {
+		if addrs, changed := config.ReplaceBootstrapPeer(list.addrs, t.Old, t.New); changed {
+			fmt.Printf("The old identity is a built-in bootstrap peer, update %s to:\n", list.name)
+			for _, addr := range addrs {
+				fmt.Println(" ", addr)
+			}
+		}
+	}
+	fmt.Println("Run the node with -keystore to publish the transition record signed by the old key.")
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ed25519Prefix starts every Ed25519 peer ID, the identity multihash of the
+// protobuf encoded public key.
+const ed25519Prefix = "12D3KooW"
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// firstChars are the characters following ed25519Prefix: the leading bits
+// of the encoded key are fixed, so the next character only takes 19 values.
+const firstChars = "9ABCDEFGHJKLMNPQRST"
+
+// vanityPattern matches peer IDs on the characters after ed25519Prefix.
+type vanityPattern struct {
+	prefix, suffix string
+	ignoreCase     bool
+}
+
+func (p vanityPattern) match(id string) bool {
+	id = id[len(ed25519Prefix):]
+	if p.ignoreCase {
+		id = strings.ToLower(id)
+	}
+	return strings.HasPrefix(id, p.prefix) && strings.HasSuffix(id, p.suffix)
+}
+
+// choices returns how many characters of alphabet match c.
+func (p vanityPattern) choices(c rune, alphabet string) int {
+	if !p.ignoreCase {
+		if strings.ContainsRune(alphabet, c) {
+			return 1
+		}
+		return 0
+	}
+	n := 0
+	for _, a := range alphabet {
+		if unicode.ToLower(a) == unicode.ToLower(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// attempts is the expected number of keys to try before a match.
+func (p vanityPattern) attempts() float64 {
+	n := 1.0
+	for i, c := range p.prefix {
+		if i == 0 {
+			n *= float64(len(firstChars)) / float64(p.choices(c, firstChars))
+		} else {
+			n *= 58 / float64(p.choices(c, base58Alphabet))
+		}
+	}
+	for _, c := range p.suffix {
+		n *= 58 / float64(p.choices(c, base58Alphabet))
+	}
+	return n
+}
+
+func newVanityPattern(prefix, suffix string, ignoreCase bool) (vanityPattern, error) {
+	p := vanityPattern{prefix: strings.TrimPrefix(prefix, ed25519Prefix), suffix: suffix, ignoreCase: ignoreCase}
+	if p.prefix+p.suffix == "" {
+		return p, fmt.Errorf("please provide -prefix or -suffix")
+	}
+	for _, c := range p.prefix + p.suffix {
+		if p.choices(c, base58Alphabet) == 0 {
+			return p, fmt.Errorf("%q is not a base58 character (0, O, I and l are not used)", c)
+		}
+	}
+	if p.prefix != "" && p.choices([]rune(p.prefix)[0], firstChars) == 0 {
+		return p, fmt.Errorf("Ed25519 peer IDs continue with one of %s after %s", firstChars, ed25519Prefix)
+	}
+	if ignoreCase {
+		p.prefix, p.suffix = strings.ToLower(p.prefix), strings.ToLower(p.suffix)
+	}
+	return p, nil
+}
+
+// searchVanity generates Ed25519 keys on workers goroutines until one has a
+// peer ID matching p or ctx is done. tried counts the keys generated.
+func searchVanity(ctx context.Context, p vanityPattern, workers int, tried *atomic.Uint64) (crypto.PrivKey, peer.ID, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		priv crypto.PrivKey
+		id   peer.ID
+	}
+	found := make(chan result, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+				if err != nil {
+					log.Println("Generate key:", err)
+					return
+				}
+				tried.Add(1)
+				id, err := peer.IDFromPublicKey(pub)
+				if err != nil {
+					continue
+				}
+				if p.match(id.String()) {
+					select {
+					case found <- result{priv, id}:
+					default:
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case r := <-found:
+		return r.priv, r.id, nil
+	default:
+		if ctx.Err() == nil {
+			return nil, "", errors.New("all workers failed")
+		}
+		return nil, "", ctx.Err()
+	}
+}
+
+func runVanity(args []string) {
+	fs := flag.NewFlagSet("vanity", flag.ExitOnError)
+	prefix := fs.String("prefix", "", "characters the peer ID starts with after "+ed25519Prefix)
+	suffix := fs.String("suffix", "", "characters the peer ID ends with")
+	ignoreCase := fs.Bool("i", false, "match case insensitively")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of searching goroutines")
+	timeout := fs.Duration("timeout", 0, "give up after this long, 0 for no limit")
+	interval := fs.Duration("progress", 5*time.Second, "progress report interval, 0 for no reports")
+	out := fs.String("o", "", "file to save the key to")
+	fs.Parse(args)
+
+	if *out == "" {
+		log.Fatal("Please provide the output file with -o")
+	}
+	if _, err := os.Stat(*out); err == nil {
+		log.Fatalf("%s already exists", *out)
+	}
+	if *workers <= 0 {
+		log.Fatal("-workers must be at least 1")
+	}
+	p, err := newVanityPattern(*prefix, *suffix, *ignoreCase)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	expected := p.attempts()
+	log.Printf("Searching %s%s...%s on %d workers, about %.0f keys expected", ed25519Prefix, p.prefix, p.suffix, *workers, expected)
+	var tried atomic.Uint64
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		if *interval <= 0 {
+			return
+		}
+		t := time.NewTicker(*interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				n := tried.Load()
+				rate := float64(n) / time.Since(start).Seconds()
+				if rate == 0 {
+					continue
+				}
+				// The chance of a match within the keys tried so far.
+				chance := 1 - math.Exp(-float64(n)/expected)
+				half := time.Duration(expected * math.Ln2 / rate * float64(time.Second))
+				log.Printf("Tried %d keys, %.0f keys/s, %.1f%% chance so far, 50%% chance after %s",
+					n, rate, chance*100, half.Round(time.Second))
+			}
+		}
+	}()
+
+	priv, id, err := searchVanity(ctx, p, *workers, &tried)
+	close(done)
+	if err != nil {
+		log.Fatalf("Search stopped after %d keys: %v", tried.Load(), err)
+	}
+	log.Printf("Found after %d keys in %s", tried.Load(), time.Since(start).Round(time.Millisecond))
+	if err := config.SavePeerKey(*out, priv); err != nil {
+		log.Fatalf("Save peer key: %v", err)
+	}
+	fmt.Println("Peer ID:", id)
+}
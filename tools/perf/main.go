@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
+	"github.com/Jerry-se/libp2p-node/pkg/perf"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <multiaddr>...\n\nRuns the perf protocol with the /p2p multiaddrs in turn, each over a connection to that address only.\n\nOptions:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	pskString := flag.String("psk", "", "hex encoded Pre-Shared Key, prefer -swarm-key")
+	swarmKeyPath := flag.String("swarm-key", "", "the file path of the swarm.key of the private network, $LIBP2P_SWARM_KEY if unset")
+	upload := flag.Uint64("upload", 10<<20, "bytes to upload")
+	download := flag.Uint64("download", 10<<20, "bytes to download")
+	runs := flag.Int("n", 1, "runs per address")
+	timeout := flag.Duration("timeout", time.Minute, "timeout of each run")
+	jsonOutput := flag.Bool("json", false, "print the results as JSON")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	addrs := make([]multiaddr.Multiaddr, flag.NArg())
+	for i, arg := range flag.Args() {
+		addr, err := multiaddr.NewMultiaddr(arg)
+		if err != nil {
+			log.Fatalf("Parse %s: %v", arg, err)
+		}
+		addrs[i] = addr
+	}
+
+	// QUIC, WebTransport and the relay client come with the default
+	// transports, a private network only works over TCP and WebSocket.
+	opts := []libp2p.Option{libp2p.NoListenAddrs}
+	psk, err := config.LoadPSK(*pskString, *swarmKeyPath)
+	if err != nil {
+		log.Fatalf("Pre-Shared Key: %v", err)
+	}
+	if psk != nil {
+		log.Println("Private network enabled")
+		opts = append(opts, libp2p.PrivateNetwork(psk), libp2p.DefaultPrivateTransports)
+	}
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		log.Fatalf("Create libp2p host: %v", err)
+	}
+	defer h.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var results []*perf.Result
+	failed := false
+	for _, addr := range addrs {
+		for i := 0; i < *runs && ctx.Err() == nil; i++ {
+			runCtx, cancel := context.WithTimeout(ctx, *timeout)
+			res, err := perf.RunAddr(runCtx, h, addr, *upload, *download)
+			cancel()
+			if err != nil {
+				log.Printf("Perf with %s: %v", addr, err)
+				failed = true
+				continue
+			}
+			if !*jsonOutput {
+				log.Printf("%s over %s: upload %s, download %s", res.Peer, res.Transport, bitrate(res.UploadBps), bitrate(res.DownloadBps))
+			}
+			results = append(results, res)
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TRANSPORT\tADDRESS\tUPLOAD\tDOWNLOAD\tTTFB\tUPLOAD RATE\tDOWNLOAD RATE\tTOTAL")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", r.Transport, r.Addr, r.Upload, r.Download,
+				r.TimeToFirstByte.Round(time.Microsecond), bitrate(r.UploadBps), bitrate(r.DownloadBps), r.Total.Round(time.Microsecond))
+		}
+		w.Flush()
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// bitrate formats bits per second with a decimal unit.
+func bitrate(bps float64) string {
+	units := []string{"bit/s", "Kbit/s", "Mbit/s", "Gbit/s"}
+	i := 0
+	for bps >= 1000 && i < len(units)-1 {
+		bps /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", bps, units[i])
+}
@@ -1,17 +1,47 @@
 package main
 
 import (
-	"crypto/rand"
 	"encoding/hex"
+	"flag"
 	"log"
+	"os"
+
+	"github.com/Jerry-se/libp2p-node/pkg/config"
 )
 
 func main() {
+	output := flag.String("o", "", "file to write the swarm.key to, stdout if empty")
+	encoding := flag.String("encoding", config.SwarmKeyBase16, "encoding of the key, base16 or base64")
+	in := flag.String("in", "", "print the hex key of an existing swarm.key instead of generating one")
+	flag.Parse()
+
+	if *in != "" {
+		psk, err := config.ReadSwarmKey(*in)
+		if err != nil {
+			log.Fatalf("Read swarm key: %v", err)
+		}
+		log.Println("Pre-Shared key:", hex.EncodeToString(psk))
+		return
+	}
+
 	// 生成 256 位密钥
-	key := make([]byte, 32)
-	_, err := rand.Read(key)
+	psk, err := config.GenerateSwarmKey()
 	if err != nil {
 		log.Fatalf("Generate Pre-Shared key: %v", err)
 	}
-	log.Println("Generate Pre-Shared key:", hex.EncodeToString(key))
+	data, err := config.EncodeSwarmKey(psk, *encoding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if _, err := os.Stat(*output); err == nil {
+		log.Fatalf("%s already exists", *output)
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		log.Fatalf("Write swarm key: %v", err)
+	}
+	log.Println("Generate Pre-Shared key:", *output)
 }